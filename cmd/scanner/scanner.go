@@ -1,15 +1,18 @@
 package main
 
 import (
+	"context"
 	"fmt"
 	"os"
 	"os/signal"
 	"syscall"
+	"time"
 
 	"github.com/ricoberger/harbor-snyk-scanner/pkg/log"
 	"github.com/ricoberger/harbor-snyk-scanner/pkg/metrics"
 	"github.com/ricoberger/harbor-snyk-scanner/pkg/scanner"
 	"github.com/ricoberger/harbor-snyk-scanner/pkg/snyk"
+	"github.com/ricoberger/harbor-snyk-scanner/pkg/tracing"
 	"github.com/ricoberger/harbor-snyk-scanner/pkg/version"
 
 	flag "github.com/spf13/pflag"
@@ -51,13 +54,16 @@ func main() {
 	// production environment you should consider to use json, so that the logs can be parsed by a logging system like
 	// Elasticsearch.
 	// Next to the log format it is also possible to configure the log leven. The accepted values are "debug", "info",
-	// "warn", "error", "fatal" and "panic". The default log level is "info".
+	// "warn", "error", "fatal" and "panic". The default log level is "info". The level can be changed at runtime via the
+	// "/log/level" endpoint of the metrics server, when a token for the endpoint is configured.
+	level := log.ParseLevel(logLevel)
+
 	zapEncoderCfg := zap.NewProductionEncoderConfig()
 	zapEncoderCfg.TimeKey = "timestamp"
 	zapEncoderCfg.EncodeTime = zapcore.ISO8601TimeEncoder
 
 	zapConfig := zap.Config{
-		Level:            log.ParseLevel(logLevel),
+		Level:            level,
 		Development:      false,
 		Encoding:         logFormat,
 		EncoderConfig:    zapEncoderCfg,
@@ -94,14 +100,28 @@ func main() {
 	log.Info(nil, "Version information", version.Info()...)
 	log.Info(nil, "Build context", version.BuildContext()...)
 
+	// Configure the tracing before the other components are initialized, so that the Snyk client and the scanner server
+	// are using the configured tracer provider. When tracing is disabled, only the propagation of the trace context is
+	// configured.
+	shutdownTracing, err := tracing.Setup()
+	if err != nil {
+		log.Fatal(nil, "Could not setup tracing", zap.Error(err))
+	}
+
 	// Initialize each component and start it in it's own goroutine, so that the main goroutine is only used as listener
 	// for terminal signals, to initialize the graceful shutdown of the components.
-	snykClient := snyk.NewClient()
+	snykClient, err := snyk.NewClient()
+	if err != nil {
+		log.Fatal(nil, "Could not create Snyk client", zap.Error(err))
+	}
 
-	scannerServer := scanner.New(snykClient)
+	scannerServer, err := scanner.New(snykClient)
+	if err != nil {
+		log.Fatal(nil, "Could not create scanner server", zap.Error(err))
+	}
 	go scannerServer.Start()
 
-	metricsServer := metrics.New()
+	metricsServer := metrics.New(level)
 	go metricsServer.Start()
 
 	// All components should be terminated gracefully. For that we are listen for the SIGINT and SIGTERM signals and try
@@ -117,5 +137,12 @@ func main() {
 	metricsServer.Stop()
 	scannerServer.Stop()
 
+	ctx, cancel := context.WithTimeout(context.Background(), 10*time.Second)
+	defer cancel()
+
+	if err := shutdownTracing(ctx); err != nil {
+		log.Error(nil, "Could not export remaining spans", zap.Error(err))
+	}
+
 	log.Info(nil, "Shutdown is done")
 }
@@ -0,0 +1,118 @@
+package harbor
+
+import (
+	"context"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"net/http"
+	"net/url"
+	"os"
+	"strings"
+	"time"
+
+	flag "github.com/spf13/pflag"
+)
+
+var (
+	apiURL   string
+	username string
+	password string
+	timeout  time.Duration
+)
+
+// init is used to define all flags, which are needed for the Harbor API client. These are the url of Harbor and the
+// credentials of a user or robot account, which is allowed to read the artifacts of all projects.
+func init() {
+	defaultAPIURL := ""
+	if os.Getenv("HARBOR_URL") != "" {
+		defaultAPIURL = os.Getenv("HARBOR_URL")
+	}
+
+	defaultUsername := ""
+	if os.Getenv("HARBOR_USERNAME") != "" {
+		defaultUsername = os.Getenv("HARBOR_USERNAME")
+	}
+
+	defaultPassword := ""
+	if os.Getenv("HARBOR_PASSWORD") != "" {
+		defaultPassword = os.Getenv("HARBOR_PASSWORD")
+	}
+
+	defaultTimeout := 30 * time.Second
+	if os.Getenv("HARBOR_TIMEOUT") != "" {
+		parsedTimeout, err := time.ParseDuration(os.Getenv("HARBOR_TIMEOUT"))
+		if err == nil {
+			defaultTimeout = parsedTimeout
+		}
+	}
+
+	flag.StringVar(&apiURL, "harbor.url", defaultAPIURL, "The url of Harbor, e.g. \"https://harbor.example.com\".")
+	flag.StringVar(&username, "harbor.username", defaultUsername, "The username of the user or robot account to access the Harbor API.")
+	flag.StringVar(&password, "harbor.password", defaultPassword, "The password of the user or robot account to access the Harbor API.")
+	flag.DurationVar(&timeout, "harbor.timeout", defaultTimeout, "The timeout for a single request against the Harbor API.")
+}
+
+// Client is the client for the REST API of Harbor.
+type Client struct {
+	url        *url.URL
+	username   string
+	password   string
+	httpClient *http.Client
+}
+
+// Host returns the host of Harbor, which is also the host of the registry.
+func (c *Client) Host() string {
+	return c.url.Host
+}
+
+// ArtifactExists returns true, when the artifact with the given reference exists in the repository of the Harbor
+// project. The reference can be a tag or a digest. The repository can contain slashes, which must be encoded twice,
+// because Harbor decodes the path before it routes the request.
+func (c *Client) ArtifactExists(ctx context.Context, project, repository, reference string) (bool, error) {
+	u := fmt.Sprintf("%s/api/v2.0/projects/%s/repositories/%s/artifacts/%s", strings.TrimSuffix(c.url.String(), "/"), url.PathEscape(project), url.PathEscape(url.PathEscape(repository)), url.PathEscape(reference))
+
+	req, err := http.NewRequestWithContext(ctx, http.MethodGet, u, nil)
+	if err != nil {
+		return false, err
+	}
+
+	req.Header.Set("Accept", "application/json")
+	if c.username != "" {
+		req.SetBasicAuth(c.username, c.password)
+	}
+
+	resp, err := c.httpClient.Do(req)
+	if err != nil {
+		return false, err
+	}
+
+	defer resp.Body.Close()
+	io.Copy(ioutil.Discard, resp.Body)
+
+	switch resp.StatusCode {
+	case http.StatusOK:
+		return true, nil
+	case http.StatusNotFound:
+		return false, nil
+	default:
+		return false, fmt.Errorf("unexpected status code %d for artifact %s in repository %s/%s", resp.StatusCode, reference, project, repository)
+	}
+}
+
+// NewClient returns a new client for the Harbor API. An error is returned, when the url of Harbor is not set or invalid.
+func NewClient() (*Client, error) {
+	u, err := url.Parse(apiURL)
+	if err != nil || u.Scheme == "" || u.Host == "" {
+		return nil, fmt.Errorf("invalid Harbor url %q", apiURL)
+	}
+
+	return &Client{
+		url:      u,
+		username: username,
+		password: password,
+		httpClient: &http.Client{
+			Timeout: timeout,
+		},
+	}, nil
+}
@@ -0,0 +1,65 @@
+package harbor
+
+import (
+	"context"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"testing"
+
+	"github.com/stretchr/testify/require"
+)
+
+func TestArtifactExists(t *testing.T) {
+	ts := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		user, pass, ok := r.BasicAuth()
+		require.True(t, ok)
+		require.Equal(t, "robot$gc", user)
+		require.Equal(t, "secret", pass)
+
+		switch r.URL.EscapedPath() {
+		case "/api/v2.0/projects/library/repositories/nginx/artifacts/latest":
+			w.WriteHeader(http.StatusOK)
+		case "/api/v2.0/projects/team/repositories/apps%252Fapi/artifacts/sha256:1234":
+			w.WriteHeader(http.StatusOK)
+		case "/api/v2.0/projects/library/repositories/broken/artifacts/latest":
+			w.WriteHeader(http.StatusInternalServerError)
+		default:
+			w.WriteHeader(http.StatusNotFound)
+		}
+	}))
+	defer ts.Close()
+
+	u, err := url.Parse(ts.URL)
+	require.NoError(t, err)
+
+	c := &Client{url: u, username: "robot$gc", password: "secret", httpClient: ts.Client()}
+
+	exists, err := c.ArtifactExists(context.Background(), "library", "nginx", "latest")
+	require.NoError(t, err)
+	require.True(t, exists)
+
+	exists, err = c.ArtifactExists(context.Background(), "team", "apps/api", "sha256:1234")
+	require.NoError(t, err)
+	require.True(t, exists)
+
+	exists, err = c.ArtifactExists(context.Background(), "library", "nginx", "deleted")
+	require.NoError(t, err)
+	require.False(t, exists)
+
+	_, err = c.ArtifactExists(context.Background(), "library", "broken", "latest")
+	require.EqualError(t, err, "unexpected status code 500 for artifact latest in repository library/broken")
+}
+
+func TestNewClient(t *testing.T) {
+	defer func(previous string) { apiURL = previous }(apiURL)
+
+	apiURL = ""
+	_, err := NewClient()
+	require.EqualError(t, err, "invalid Harbor url \"\"")
+
+	apiURL = "https://harbor.example.com"
+	c, err := NewClient()
+	require.NoError(t, err)
+	require.Equal(t, "harbor.example.com", c.Host())
+}
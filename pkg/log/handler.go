@@ -0,0 +1,187 @@
+package log
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"sync"
+	"time"
+
+	"go.uber.org/zap"
+	"go.uber.org/zap/zapcore"
+)
+
+// levelRequest is the body of a PUT request to change the log level. The TTL is optional, when it is set the level is
+// reverted to the previous level after the given duration.
+type levelRequest struct {
+	Level string `json:"level"`
+	TTL   string `json:"ttl,omitempty"`
+}
+
+// levelResponse is the body of the response for the log level endpoint. RevertTo and RevertAt are only set, when the
+// current level was set with a TTL.
+type levelResponse struct {
+	Level    string     `json:"level"`
+	RevertTo string     `json:"revertTo,omitempty"`
+	RevertAt *time.Time `json:"revertAt,omitempty"`
+}
+
+type errorResponse struct {
+	Error string `json:"error"`
+}
+
+// LevelHandler is a http.Handler to read and change the log level at runtime. A GET request returns the current level
+// and a PUT request changes the level, e.g. `{"level": "debug", "ttl": "15m"}`. When a TTL is set, the level is
+// reverted automatically after the TTL, so that a forgotten debug level doesn't flood the logs. Each change is logged
+// as audit event.
+type LevelHandler struct {
+	level zap.AtomicLevel
+
+	mu          sync.Mutex
+	revertTo    zapcore.Level
+	revertAt    time.Time
+	revertTimer *time.Timer
+	generation  int
+}
+
+// NewLevelHandler returns a new LevelHandler for the given level. The level must be the one, which was used to build
+// the logger.
+func NewLevelHandler(level zap.AtomicLevel) *LevelHandler {
+	return &LevelHandler{level: level}
+}
+
+func (h *LevelHandler) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	switch r.Method {
+	case http.MethodGet:
+		writeJSON(w, http.StatusOK, h.response())
+	case http.MethodPut:
+		var data levelRequest
+		if err := json.NewDecoder(r.Body).Decode(&data); err != nil {
+			writeJSON(w, http.StatusBadRequest, errorResponse{Error: fmt.Sprintf("could not decode request body: %s", err.Error())})
+			return
+		}
+
+		var level zapcore.Level
+		if err := level.UnmarshalText([]byte(data.Level)); err != nil {
+			writeJSON(w, http.StatusBadRequest, errorResponse{Error: fmt.Sprintf("invalid level %q", data.Level)})
+			return
+		}
+
+		var ttl time.Duration
+		if data.TTL != "" {
+			parsedTTL, err := time.ParseDuration(data.TTL)
+			if err != nil || parsedTTL <= 0 {
+				writeJSON(w, http.StatusBadRequest, errorResponse{Error: fmt.Sprintf("invalid ttl %q, must be a positive duration", data.TTL)})
+				return
+			}
+			ttl = parsedTTL
+		}
+
+		h.setLevel(r.Context(), level, ttl, zap.String("remoteAddr", r.RemoteAddr), zap.String("userAgent", r.UserAgent()))
+		writeJSON(w, http.StatusOK, h.response())
+	default:
+		w.Header().Set("Allow", "GET, PUT")
+		writeJSON(w, http.StatusMethodNotAllowed, errorResponse{Error: fmt.Sprintf("method %s is not allowed", r.Method)})
+	}
+}
+
+// setLevel changes the log level. When a TTL is set, the level is reverted after the TTL to the level which was active
+// before the first change with a TTL. This means that multiple changes with a TTL do not extend the time of a debug
+// level beyond the last TTL and a change without a TTL cancels a pending revert.
+func (h *LevelHandler) setLevel(ctx context.Context, level zapcore.Level, ttl time.Duration, fields ...zapcore.Field) {
+	h.mu.Lock()
+	defer h.mu.Unlock()
+
+	previous := h.level.Level()
+
+	if h.revertTimer != nil {
+		h.revertTimer.Stop()
+		h.revertTimer = nil
+	} else {
+		h.revertTo = previous
+	}
+
+	// The generation ensures that a timer, which already fired but is still waiting for the lock, doesn't revert a
+	// newer change.
+	h.generation++
+	generation := h.generation
+
+	if ttl > 0 {
+		h.revertAt = time.Now().Add(ttl)
+		h.revertTimer = time.AfterFunc(ttl, func() { h.revert(generation) })
+		fields = append(fields, zap.Duration("ttl", ttl), zap.String("revertTo", h.revertTo.String()))
+	} else {
+		h.revertAt = time.Time{}
+	}
+
+	h.change(ctx, previous, level, fields...)
+}
+
+// revert resets the log level to the level, which was active before the level was changed with a TTL. It is called by
+// the timer for the TTL.
+func (h *LevelHandler) revert(generation int) {
+	h.mu.Lock()
+	defer h.mu.Unlock()
+
+	if generation != h.generation {
+		return
+	}
+
+	h.revertTimer = nil
+	h.revertAt = time.Time{}
+	h.change(nil, h.level.Level(), h.revertTo, zap.String("reason", "ttl expired"))
+}
+
+// change sets the log level and logs the change as audit event. The event is logged with the info level, but at least
+// with the more verbose of the old and new level, so that the event is not dropped when the logger only logs errors.
+// To be sure that the event is written, it is logged with the old level before the level is decreased and with the
+// new level after the level is increased. The caller must hold the lock of the handler.
+func (h *LevelHandler) change(ctx context.Context, previous, level zapcore.Level, fields ...zapcore.Field) {
+	auditLevel := previous
+	if level < auditLevel {
+		auditLevel = level
+	}
+	if auditLevel < zapcore.InfoLevel {
+		auditLevel = zapcore.InfoLevel
+	}
+	if auditLevel > zapcore.ErrorLevel {
+		auditLevel = zapcore.ErrorLevel
+	}
+
+	fields = append(getFields(ctx, fields...), zap.Bool("audit", true), zap.String("previousLevel", previous.String()), zap.String("level", level.String()))
+
+	if level > previous {
+		audit(auditLevel, fields...)
+		h.level.SetLevel(level)
+	} else {
+		h.level.SetLevel(level)
+		audit(auditLevel, fields...)
+	}
+}
+
+func audit(level zapcore.Level, fields ...zapcore.Field) {
+	if ce := zap.L().Check(level, "Log level changed"); ce != nil {
+		ce.Write(fields...)
+	}
+}
+
+func (h *LevelHandler) response() levelResponse {
+	h.mu.Lock()
+	defer h.mu.Unlock()
+
+	response := levelResponse{Level: h.level.Level().String()}
+	if h.revertTimer != nil {
+		revertAt := h.revertAt
+		response.RevertTo = h.revertTo.String()
+		response.RevertAt = &revertAt
+	}
+
+	return response
+}
+
+func writeJSON(w http.ResponseWriter, status int, v interface{}) {
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(status)
+	json.NewEncoder(w).Encode(v)
+}
@@ -0,0 +1,109 @@
+package log
+
+import (
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/require"
+	"go.uber.org/zap"
+	"go.uber.org/zap/zapcore"
+	"go.uber.org/zap/zaptest/observer"
+)
+
+func setLevel(t *testing.T, handler *LevelHandler, body string) (int, levelResponse) {
+	w := httptest.NewRecorder()
+	handler.ServeHTTP(w, httptest.NewRequest(http.MethodPut, "/log/level", strings.NewReader(body)))
+
+	var response levelResponse
+	if w.Code == http.StatusOK {
+		require.NoError(t, json.NewDecoder(w.Body).Decode(&response))
+	}
+
+	return w.Code, response
+}
+
+func TestLevelHandler(t *testing.T) {
+	level := zap.NewAtomicLevelAt(zapcore.InfoLevel)
+	core, logs := observer.New(level)
+
+	defer zap.ReplaceGlobals(zap.New(core))()
+
+	handler := NewLevelHandler(level)
+
+	t.Run("get level", func(t *testing.T) {
+		w := httptest.NewRecorder()
+		handler.ServeHTTP(w, httptest.NewRequest(http.MethodGet, "/log/level", nil))
+		require.Equal(t, http.StatusOK, w.Code)
+		require.JSONEq(t, `{"level": "info"}`, w.Body.String())
+	})
+
+	t.Run("invalid requests", func(t *testing.T) {
+		code, _ := setLevel(t, handler, `{"level": "verbose"}`)
+		require.Equal(t, http.StatusBadRequest, code)
+
+		code, _ = setLevel(t, handler, `{"level": "debug", "ttl": "-1m"}`)
+		require.Equal(t, http.StatusBadRequest, code)
+
+		code, _ = setLevel(t, handler, `level=debug`)
+		require.Equal(t, http.StatusBadRequest, code)
+
+		w := httptest.NewRecorder()
+		handler.ServeHTTP(w, httptest.NewRequest(http.MethodPost, "/log/level", nil))
+		require.Equal(t, http.StatusMethodNotAllowed, w.Code)
+
+		require.Equal(t, zapcore.InfoLevel, level.Level())
+		require.Equal(t, 0, logs.Len())
+	})
+
+	t.Run("set level with ttl", func(t *testing.T) {
+		logs.TakeAll()
+
+		code, response := setLevel(t, handler, `{"level": "debug", "ttl": "50ms"}`)
+		require.Equal(t, http.StatusOK, code)
+		require.Equal(t, "debug", response.Level)
+		require.Equal(t, "info", response.RevertTo)
+		require.NotNil(t, response.RevertAt)
+		require.Equal(t, zapcore.DebugLevel, level.Level())
+
+		require.Eventually(t, func() bool { return level.Level() == zapcore.InfoLevel }, time.Second, 10*time.Millisecond)
+
+		entries := logs.FilterMessage("Log level changed").AllUntimed()
+		require.Len(t, entries, 2)
+		require.Equal(t, "debug", entries[0].ContextMap()["level"])
+		require.Equal(t, "info", entries[0].ContextMap()["previousLevel"])
+		require.Equal(t, true, entries[0].ContextMap()["audit"])
+		require.Equal(t, "info", entries[1].ContextMap()["level"])
+		require.Equal(t, "ttl expired", entries[1].ContextMap()["reason"])
+	})
+
+	t.Run("change without ttl cancels revert", func(t *testing.T) {
+		code, _ := setLevel(t, handler, `{"level": "debug", "ttl": "20ms"}`)
+		require.Equal(t, http.StatusOK, code)
+
+		code, response := setLevel(t, handler, `{"level": "warn"}`)
+		require.Equal(t, http.StatusOK, code)
+		require.Equal(t, levelResponse{Level: "warn"}, response)
+
+		time.Sleep(50 * time.Millisecond)
+		require.Equal(t, zapcore.WarnLevel, level.Level())
+	})
+
+	t.Run("audit event is not dropped", func(t *testing.T) {
+		logs.TakeAll()
+
+		code, _ := setLevel(t, handler, `{"level": "error"}`)
+		require.Equal(t, http.StatusOK, code)
+
+		code, _ = setLevel(t, handler, `{"level": "warn"}`)
+		require.Equal(t, http.StatusOK, code)
+
+		entries := logs.FilterMessage("Log level changed").AllUntimed()
+		require.Len(t, entries, 2)
+		require.Equal(t, zapcore.WarnLevel, entries[0].Level)
+		require.Equal(t, zapcore.WarnLevel, entries[1].Level)
+	})
+}
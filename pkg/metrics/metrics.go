@@ -2,8 +2,10 @@ package metrics
 
 import (
 	"context"
+	"crypto/subtle"
 	"net/http"
 	"os"
+	"strings"
 	"time"
 
 	"github.com/ricoberger/harbor-snyk-scanner/pkg/log"
@@ -15,21 +17,29 @@ import (
 )
 
 var (
-	address string
+	address       string
+	logLevelToken string
 )
 
-// init is used to define all flags, which are needed for the metrics server. Currently this is only the address, where
-// the metrics server should listen on.
+// init is used to define all flags, which are needed for the metrics server. This is the address, where the metrics
+// server should listen on and the token for the log level endpoint.
 func init() {
 	defaultAddress := ":8081"
 	if os.Getenv("METRICS_ADDRESS") != "" {
 		defaultAddress = os.Getenv("METRICS_ADDRESS")
 	}
 
+	defaultLogLevelToken := ""
+	if os.Getenv("METRICS_LOG_LEVEL_TOKEN") != "" {
+		defaultLogLevelToken = os.Getenv("METRICS_LOG_LEVEL_TOKEN")
+	}
+
 	flag.StringVar(&address, "metrics.address", defaultAddress, "The address, where the Prometheus metrics are served.")
+	flag.StringVar(&logLevelToken, "metrics.log-level-token", defaultLogLevelToken, "The token, which must be sent as bearer token in the Authorization header to read and change the log level via the \"/log/level\" endpoint. If the value is empty, the endpoint is disabled.")
 }
 
-// Server implements the metrics server. The metrics server is used to serve Prometheus metrics.
+// Server implements the metrics server. The metrics server is used to serve Prometheus metrics and to read and change
+// the log level at runtime.
 type Server struct {
 	*http.Server
 }
@@ -58,11 +68,19 @@ func (s *Server) Stop() {
 	}
 }
 
-// New return a new metrics server.
-func New() *Server {
+// New return a new metrics server. The given level must be the level of the global logger, so that it can be changed
+// via the "/log/level" endpoint. The endpoint is only available, when a token is configured, because the metrics server
+// is usually reachable by everyone who can scrape the metrics.
+func New(logLevel zap.AtomicLevel) *Server {
 	router := chi.NewRouter()
 	router.Handle("/metrics", promhttp.Handler())
 
+	if logLevelToken != "" {
+		levelHandler := requireToken(logLevelToken, log.NewLevelHandler(logLevel))
+		router.Method(http.MethodGet, "/log/level", levelHandler)
+		router.Method(http.MethodPut, "/log/level", levelHandler)
+	}
+
 	return &Server{
 		&http.Server{
 			Addr:    address,
@@ -70,3 +88,18 @@ func New() *Server {
 		},
 	}
 }
+
+// requireToken returns a handler, which only calls the given handler, when the request contains the given token as
+// bearer token in the Authorization header. All other requests are rejected with a 401 status code.
+func requireToken(token string, next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		authorization := r.Header.Get("Authorization")
+		if !strings.HasPrefix(authorization, "Bearer ") || subtle.ConstantTimeCompare([]byte(strings.TrimPrefix(authorization, "Bearer ")), []byte(token)) != 1 {
+			w.Header().Set("WWW-Authenticate", "Bearer")
+			http.Error(w, "Unauthorized", http.StatusUnauthorized)
+			return
+		}
+
+		next.ServeHTTP(w, r)
+	})
+}
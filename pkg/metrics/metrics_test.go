@@ -0,0 +1,50 @@
+package metrics
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+
+	"github.com/stretchr/testify/require"
+	"go.uber.org/zap"
+	"go.uber.org/zap/zapcore"
+)
+
+func TestLogLevelEndpoint(t *testing.T) {
+	defer func(previous string) { logLevelToken = previous }(logLevelToken)
+
+	level := zap.NewAtomicLevelAt(zapcore.InfoLevel)
+
+	setLevel := func(server *Server, authorization string) int {
+		req := httptest.NewRequest(http.MethodPut, "/log/level", strings.NewReader(`{"level": "debug"}`))
+		if authorization != "" {
+			req.Header.Set("Authorization", authorization)
+		}
+
+		w := httptest.NewRecorder()
+		server.Handler.ServeHTTP(w, req)
+		return w.Code
+	}
+
+	t.Run("disabled without token", func(t *testing.T) {
+		logLevelToken = ""
+		server := New(level)
+
+		require.Equal(t, http.StatusNotFound, setLevel(server, "Bearer "))
+		require.Equal(t, zapcore.InfoLevel, level.Level())
+	})
+
+	t.Run("token required", func(t *testing.T) {
+		logLevelToken = "secret"
+		server := New(level)
+
+		require.Equal(t, http.StatusUnauthorized, setLevel(server, ""))
+		require.Equal(t, http.StatusUnauthorized, setLevel(server, "Bearer wrong"))
+		require.Equal(t, http.StatusUnauthorized, setLevel(server, "secret"))
+		require.Equal(t, zapcore.InfoLevel, level.Level())
+
+		require.Equal(t, http.StatusOK, setLevel(server, "Bearer secret"))
+		require.Equal(t, zapcore.DebugLevel, level.Level())
+	})
+}
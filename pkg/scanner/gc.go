@@ -0,0 +1,210 @@
+package scanner
+
+import (
+	"context"
+	"errors"
+	"sort"
+	"strings"
+	"time"
+
+	"github.com/ricoberger/harbor-snyk-scanner/pkg/log"
+	"github.com/ricoberger/harbor-snyk-scanner/pkg/snyk"
+
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/prometheus/client_golang/prometheus/promauto"
+	"go.uber.org/zap"
+)
+
+var (
+	gcRunsMetric = promauto.NewCounterVec(prometheus.CounterOpts{
+		Namespace: "harbor_snyk_scanner",
+		Name:      "gc_runs_total",
+		Help:      "Number of garbage collector runs, partitioned by result.",
+	}, []string{"result"})
+
+	gcProjectsCheckedMetric = promauto.NewCounter(prometheus.CounterOpts{
+		Namespace: "harbor_snyk_scanner",
+		Name:      "gc_projects_checked_total",
+		Help:      "Number of Snyk projects, for which the garbage collector checked the artifact in Harbor.",
+	})
+
+	gcProjectsDeletedMetric = promauto.NewCounter(prometheus.CounterOpts{
+		Namespace: "harbor_snyk_scanner",
+		Name:      "gc_projects_deleted_total",
+		Help:      "Number of Snyk projects, which were deleted by the garbage collector, because the artifact was deleted in Harbor.",
+	})
+
+	gcErrorsMetric = promauto.NewCounterVec(prometheus.CounterOpts{
+		Namespace: "harbor_snyk_scanner",
+		Name:      "gc_errors_total",
+		Help:      "Number of errors in the garbage collector, partitioned by the API which returned the error.",
+	}, []string{"api"})
+
+	gcLimitReachedMetric = promauto.NewCounter(prometheus.CounterOpts{
+		Namespace: "harbor_snyk_scanner",
+		Name:      "gc_max_deletions_reached_total",
+		Help:      "Number of garbage collector runs, which were stopped because the maximum number of deletions was reached.",
+	})
+
+	gcLastRunMetric = promauto.NewGauge(prometheus.GaugeOpts{
+		Namespace: "harbor_snyk_scanner",
+		Name:      "gc_last_run_timestamp_seconds",
+		Help:      "Unix timestamp of the last finished garbage collector run.",
+	})
+)
+
+// runGarbageCollector deletes the projects of deleted artifacts from Snyk in the configured interval until the scanner
+// is stopped. When the garbage collector is disabled, the function returns immediately.
+func (s *Server) runGarbageCollector() {
+	defer s.wg.Done()
+
+	if gcInterval <= 0 {
+		return
+	}
+
+	ticker := time.NewTicker(gcInterval)
+	defer ticker.Stop()
+
+	for {
+		select {
+		case <-s.ctx.Done():
+			return
+		case <-ticker.C:
+			s.collectGarbage(s.ctx)
+		}
+	}
+}
+
+// collectGarbage lists the projects of all Snyk organisations and checks for each project if the artifact still exists
+// in Harbor. When the artifact was deleted, the project is deleted from Snyk. A run is stopped after the configured
+// maximum number of deletions, so that a misconfiguration (e.g. a wrong Harbor url) can not delete all projects at
+// once. Only projects with the tag, which is added to all imported projects, are deleted. Projects which can not be
+// checked are kept.
+func (s *Server) collectGarbage(ctx context.Context) {
+	log.Info(ctx, "Garbage collector started", zap.Int("maxDeletions", gcMaxDeletions), zap.Bool("dryRun", gcDryRun))
+
+	// The projects of all organisations are checked, the default organisation is always checked first. Routes can use the
+	// same organisation, so that we have to skip projects, which were already checked.
+	routes := make([]string, 0, len(s.clients))
+	for route := range s.clients {
+		routes = append(routes, route)
+	}
+	sort.Strings(routes)
+
+	seen := make(map[string]bool)
+	deleted := 0
+	failed := false
+
+	defer func() {
+		if failed {
+			gcRunsMetric.WithLabelValues("error").Inc()
+		} else {
+			gcRunsMetric.WithLabelValues("success").Inc()
+		}
+		gcLastRunMetric.SetToCurrentTime()
+
+		log.Info(ctx, "Garbage collector finished", zap.Int("deleted", deleted), zap.Bool("failed", failed))
+	}()
+
+	for _, route := range routes {
+		snykClient := s.clients[route]
+
+		projects, err := snykClient.ListProjects(ctx, gcOrigins)
+		if err != nil {
+			log.Error(ctx, "Could not list Snyk projects", zap.Error(err), zap.String("route", route))
+			gcErrorsMetric.WithLabelValues("snyk").Inc()
+			failed = true
+			continue
+		}
+
+		for _, project := range projects {
+			if seen[project.ID] {
+				continue
+			}
+			seen[project.ID] = true
+
+			fields := []zap.Field{zap.String("route", route), zap.String("projectID", project.ID), zap.String("projectName", project.Name)}
+
+			if !hasTag(project.Tags, managedProjectTag) {
+				log.Debug(ctx, "Project was not imported by the scanner, skip project", fields...)
+				continue
+			}
+
+			harborProject, repository, reference, ok := parseProjectName(project.Name, s.harborClient.Host())
+			if !ok {
+				log.Debug(ctx, "Project name is not an artifact in Harbor, skip project", fields...)
+				continue
+			}
+
+			gcProjectsCheckedMetric.Inc()
+
+			exists, err := s.harborClient.ArtifactExists(ctx, harborProject, repository, reference)
+			if err != nil {
+				log.Error(ctx, "Could not check artifact in Harbor", append(fields, zap.Error(err))...)
+				gcErrorsMetric.WithLabelValues("harbor").Inc()
+				failed = true
+				continue
+			}
+
+			if exists {
+				continue
+			}
+
+			if deleted >= gcMaxDeletions {
+				log.Warn(ctx, "Maximum number of deletions reached, remaining projects are deleted in the next run", zap.Int("maxDeletions", gcMaxDeletions))
+				gcLimitReachedMetric.Inc()
+				return
+			}
+
+			deleted = deleted + 1
+
+			if gcDryRun {
+				log.Info(ctx, "Dry run, project would be deleted from Snyk", fields...)
+				continue
+			}
+
+			if err := snykClient.DeleteProject(ctx, project.ID); err != nil && !errors.Is(err, snyk.ErrNotFound) {
+				log.Error(ctx, "Could not delete project from Snyk", append(fields, zap.Error(err))...)
+				gcErrorsMetric.WithLabelValues("snyk").Inc()
+				failed = true
+				continue
+			}
+
+			log.Info(ctx, "Artifact was deleted in Harbor, project was deleted from Snyk", fields...)
+			gcProjectsDeletedMetric.Inc()
+		}
+	}
+}
+
+// parseProjectName returns the Harbor project, the repository and the reference of the artifact for the name of a Snyk
+// project. Application projects within an image are named after the image followed by the path of the target file,
+// e.g. "library/nginx:latest:/app/package.json". The name can start with the host of Harbor, when it is part of the
+// image template. If the name starts with the host of another registry or doesn't contain a tag or digest, false is
+// returned.
+func parseProjectName(name, host string) (string, string, string, bool) {
+	if index := strings.Index(name, ":/"); index >= 0 {
+		name = name[:index]
+	}
+
+	name = strings.TrimPrefix(name, host+"/")
+
+	repository, tag, digest := snyk.SplitImage(name)
+
+	reference := digest
+	if reference == "" {
+		reference = tag
+	}
+
+	parts := strings.SplitN(repository, "/", 2)
+	if len(parts) != 2 || parts[0] == "" || parts[1] == "" || reference == "" {
+		return "", "", "", false
+	}
+
+	// A first part with a colon or a dot is most likely the host of another registry. We skip these projects, because
+	// deleting them could remove projects, which were not created for Harbor.
+	if strings.ContainsAny(parts[0], ":.") || parts[0] == "localhost" {
+		return "", "", "", false
+	}
+
+	return parts[0], parts[1], reference, true
+}
@@ -0,0 +1,150 @@
+package scanner
+
+import (
+	"context"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/ricoberger/harbor-snyk-scanner/pkg/harbor"
+	"github.com/ricoberger/harbor-snyk-scanner/pkg/snyk"
+
+	"github.com/prometheus/client_golang/prometheus/testutil"
+	flag "github.com/spf13/pflag"
+	"github.com/stretchr/testify/require"
+)
+
+// newHarborServer returns a stand-in for the Harbor API, where only the given artifacts exist. The artifacts are the
+// escaped paths below "/api/v2.0/projects/".
+func newHarborServer(t *testing.T, artifacts ...string) *harbor.Client {
+	ts := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		path := strings.TrimPrefix(r.URL.EscapedPath(), "/api/v2.0/projects/")
+
+		if path == "library/repositories/broken/artifacts/latest" {
+			w.WriteHeader(http.StatusInternalServerError)
+			return
+		}
+
+		for _, artifact := range artifacts {
+			if path == artifact {
+				w.WriteHeader(http.StatusOK)
+				return
+			}
+		}
+
+		w.WriteHeader(http.StatusNotFound)
+	}))
+	t.Cleanup(ts.Close)
+
+	previous := flag.Lookup("harbor.url").Value.String()
+	t.Cleanup(func() { flag.Set("harbor.url", previous) })
+	require.NoError(t, flag.Set("harbor.url", ts.URL))
+
+	harborClient, err := harbor.NewClient()
+	require.NoError(t, err)
+
+	return harborClient
+}
+
+func TestCollectGarbage(t *testing.T) {
+	defer func(previousMaxDeletions int, previousDryRun bool) {
+		gcMaxDeletions = previousMaxDeletions
+		gcDryRun = previousDryRun
+	}(gcMaxDeletions, gcDryRun)
+
+	harborClient := newHarborServer(t, "library/repositories/nginx/artifacts/latest", "team/repositories/apps%252Fapi/artifacts/sha256:1234")
+
+	client := &fakeClient{orgProjects: []snyk.Project{
+		{ID: "project1", Name: "library/nginx:latest", Tags: []snyk.Tag{managedProjectTag}},
+		{ID: "project2", Name: "library/nginx:old", Tags: []snyk.Tag{managedProjectTag}},
+		{ID: "project3", Name: "library/nginx:old:/app/package.json", Tags: []snyk.Tag{{Key: "team", Value: "platform"}, managedProjectTag}},
+		{ID: "project4", Name: "team/apps/api@sha256:1234", Tags: []snyk.Tag{managedProjectTag}},
+		{ID: "project5", Name: "docker.io/library/redis:6", Tags: []snyk.Tag{managedProjectTag}},
+		{ID: "project6", Name: harborClient.Host() + "/library/redis:deleted", Tags: []snyk.Tag{managedProjectTag}},
+		{ID: "project7", Name: "library/broken:latest", Tags: []snyk.Tag{managedProjectTag}},
+		{ID: "project8", Name: "library/redis:deleted"},
+		{ID: "project9", Name: "library/redis:deleted", Tags: []snyk.Tag{{Key: "managed-by", Value: "someone-else"}}},
+	}}
+
+	// The route uses the same organisation, so that all projects are returned twice and must only be deleted once.
+	server := &Server{
+		clients:      map[string]snyk.Client{"": client, "route": client.WithOrganisation("org", "integration", "")},
+		harborClient: harborClient,
+	}
+
+	t.Run("max deletions", func(t *testing.T) {
+		gcMaxDeletions = 2
+		gcDryRun = false
+		client.deleted = nil
+		limitReached := testutil.ToFloat64(gcLimitReachedMetric)
+
+		server.collectGarbage(context.Background())
+		require.Equal(t, []string{"project2", "project3"}, client.deleted)
+		require.Equal(t, limitReached+1, testutil.ToFloat64(gcLimitReachedMetric))
+	})
+
+	t.Run("delete projects", func(t *testing.T) {
+		gcMaxDeletions = 10
+		gcDryRun = false
+		client.deleted = nil
+		deleted := testutil.ToFloat64(gcProjectsDeletedMetric)
+		harborErrors := testutil.ToFloat64(gcErrorsMetric.WithLabelValues("harbor"))
+		failedRuns := testutil.ToFloat64(gcRunsMetric.WithLabelValues("error"))
+
+		server.collectGarbage(context.Background())
+		require.Equal(t, []string{"project2", "project3", "project6"}, client.deleted)
+		require.Equal(t, deleted+3, testutil.ToFloat64(gcProjectsDeletedMetric))
+		require.Equal(t, harborErrors+1, testutil.ToFloat64(gcErrorsMetric.WithLabelValues("harbor")))
+		require.Equal(t, failedRuns+1, testutil.ToFloat64(gcRunsMetric.WithLabelValues("error")))
+	})
+
+	t.Run("dry run", func(t *testing.T) {
+		gcMaxDeletions = 10
+		gcDryRun = true
+		client.deleted = nil
+
+		server.collectGarbage(context.Background())
+		require.Empty(t, client.deleted)
+	})
+}
+
+func TestParseProjectName(t *testing.T) {
+	for _, tt := range []struct {
+		name       string
+		project    string
+		repository string
+		reference  string
+		ok         bool
+	}{
+		{name: "library/nginx:latest", project: "library", repository: "nginx", reference: "latest", ok: true},
+		{name: "library/nginx@sha256:1234", project: "library", repository: "nginx", reference: "sha256:1234", ok: true},
+		{name: "team/apps/api:1.0.0:/app/package.json", project: "team", repository: "apps/api", reference: "1.0.0", ok: true},
+		{name: "harbor.example.com/library/nginx:latest", project: "library", repository: "nginx", reference: "latest", ok: true},
+		{name: "docker.io/library/nginx:latest"},
+		{name: "localhost:5000/library/nginx:latest"},
+		{name: "library/nginx"},
+		{name: "nginx:latest"},
+	} {
+		t.Run(tt.name, func(t *testing.T) {
+			project, repository, reference, ok := parseProjectName(tt.name, "harbor.example.com")
+			require.Equal(t, tt.ok, ok)
+			require.Equal(t, tt.project, project)
+			require.Equal(t, tt.repository, repository)
+			require.Equal(t, tt.reference, reference)
+		})
+	}
+}
+
+func TestNewGarbageCollector(t *testing.T) {
+	defer func(previous time.Duration) { gcInterval = previous }(gcInterval)
+
+	previous := flag.Lookup("harbor.url").Value.String()
+	defer flag.Set("harbor.url", previous)
+	require.NoError(t, flag.Set("harbor.url", ""))
+
+	gcInterval = time.Hour
+	_, err := New(&fakeClient{})
+	require.EqualError(t, err, "could not create Harbor client: invalid Harbor url \"\"")
+}
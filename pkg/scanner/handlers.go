@@ -3,7 +3,9 @@ package scanner
 import (
 	"encoding/json"
 	"fmt"
+	"math"
 	"net/http"
+	"strconv"
 	"time"
 
 	"github.com/ricoberger/harbor-snyk-scanner/pkg/harbor"
@@ -12,6 +14,8 @@ import (
 	"github.com/ricoberger/harbor-snyk-scanner/pkg/version"
 
 	"github.com/go-chi/chi/v5"
+	"go.opentelemetry.io/otel/attribute"
+	"go.opentelemetry.io/otel/trace"
 	"go.uber.org/zap"
 )
 
@@ -35,6 +39,9 @@ func (s *Server) acceptScanRequest(w http.ResponseWriter, r *http.Request) {
 		return
 	}
 
+	span := trace.SpanFromContext(r.Context())
+	span.SetAttributes(attribute.String("artifact.repository", data.Artifact.Repository), attribute.String("artifact.digest", data.Artifact.Digest))
+
 	if data.Artifact.Repository == "" {
 		log.Error(r.Context(), "Repository field for artifact is missing in request data")
 		render.JSON(w, r, http.StatusUnprocessableEntity, harbor.SCANNER_ADAPTER_ERROR, harbor.Error{
@@ -43,33 +50,108 @@ func (s *Server) acceptScanRequest(w http.ResponseWriter, r *http.Request) {
 		return
 	}
 
-	if data.Artifact.Tag == "" {
-		log.Error(r.Context(), "Tag field for artifact is missing in request data")
+	if data.Artifact.Tag == "" && data.Artifact.Digest == "" {
+		log.Error(r.Context(), "Tag or digest field for artifact is missing in request data")
 		render.JSON(w, r, http.StatusUnprocessableEntity, harbor.SCANNER_ADAPTER_ERROR, harbor.Error{
-			Message: "Tag field for artifact is missing in request data",
+			Message: "Tag or digest field for artifact is missing in request data",
 		})
 		return
 	}
 
-	// To import the image from Harbor into Snyk we just have to provide the repository and tag as image.
-	image := fmt.Sprintf("%s:%s", data.Artifact.Repository, data.Artifact.Tag)
+	// The image, which is imported into Snyk, is rendered via the configured template. The same image is also used to
+	// find the projects for the image in the import job.
+	image, err := getImage(s.imageTemplate, data.Registry, data.Artifact)
+	if err != nil {
+		log.Error(r.Context(), "Could not render image", zap.Error(err), zap.Any("artifact", data.Artifact))
+		render.JSON(w, r, http.StatusInternalServerError, harbor.SCANNER_ADAPTER_ERROR, harbor.Error{
+			Message: fmt.Sprintf("Could not render image: %s", err.Error()),
+		})
+		return
+	}
+
+	// The route selects the Snyk organisation and integration, which is used to import the image and to get the issues
+	// for the image. When no route matches, the client for the organisation from the Snyk flags is used.
+	var routeName string
+	if route := matchRoute(s.routes, data.Registry.URL, data.Artifact.Repository); route != nil {
+		routeName = route.Name
+	}
 
-	location, err := s.snykClient.ImportProject(r.Context(), image)
+	snykClient, err := s.getSnykClient(routeName)
 	if err != nil {
-		log.Error(r.Context(), "Could not import image into Snyk", zap.Error(err), zap.String("image", image))
+		log.Error(r.Context(), "Could not get Snyk client", zap.Error(err), zap.String("route", routeName))
 		render.JSON(w, r, http.StatusInternalServerError, harbor.SCANNER_ADAPTER_ERROR, harbor.Error{
-			Message: fmt.Sprintf("Could not import image into Snyk: %#v", err),
+			Message: fmt.Sprintf("Could not get Snyk client: %s", err.Error()),
 		})
 		return
 	}
 
-	// To identify the image in Snyk we create a base64 encoded id with the artifact, the current timestamp and the
-	// returned location from the Snyk API which can be used to check if the import is finished.
-	// The current timestamp is needed, so that we can abort the getScanReport request, when the project was import x
-	// hours ago and we still get not result from Snyk.
-	scanRequestID, err := createScanRequestID(data.Artifact, location)
+	// When there is already a pending scan job for the same artifact, we return the id of the existing job instead of
+	// importing the image again.
+	job, err := s.findPendingScanJob(r.Context(), data.Artifact, image, routeName)
 	if err != nil {
-		log.Error(r.Context(), "Could not create scan request id", zap.Error(err), zap.Any("artifact", data.Artifact))
+		log.Error(r.Context(), "Could not list scan jobs", zap.Error(err))
+		render.JSON(w, r, http.StatusInternalServerError, harbor.SCANNER_ADAPTER_ERROR, harbor.Error{
+			Message: fmt.Sprintf("Could not list scan jobs: %#v", err),
+		})
+		return
+	}
+
+	if job == nil {
+		// When the reuse of projects is enabled, we check if there are already projects for the image in Snyk, which
+		// were tested recently. In this case we can skip the import and get the issues for these projects directly.
+		var location string
+		var projectIDs []string
+
+		if reuseMaxAge > 0 {
+			projectIDs, err = snykClient.FindProjects(r.Context(), image, data.Artifact.Digest, reuseMaxAge)
+			if err != nil {
+				log.Warn(r.Context(), "Could not find existing projects in Snyk", zap.Error(err), zap.String("image", image))
+			}
+		}
+
+		if len(projectIDs) > 0 {
+			log.Info(r.Context(), "Reuse existing projects from Snyk", zap.String("image", image), zap.String("route", routeName), zap.Strings("projectIDs", projectIDs))
+		} else {
+			projectIDs = nil
+
+			location, err = snykClient.ImportProject(r.Context(), image)
+			if err != nil {
+				status, retryAfter := getImportErrorResponse(err)
+				if retryAfter > 0 {
+					w.Header().Set("Retry-After", strconv.Itoa(int(math.Ceil(retryAfter.Seconds()))))
+				}
+
+				log.Error(r.Context(), "Could not import image into Snyk", zap.Error(err), zap.String("image", image), zap.Int("status", status))
+				render.JSON(w, r, status, harbor.SCANNER_ADAPTER_ERROR, harbor.Error{
+					Message: fmt.Sprintf("Could not import image into Snyk: %s", err.Error()),
+				})
+				return
+			}
+		}
+
+		// The scan job contains the artifact, the current timestamp and the returned location from the Snyk API which
+		// can be used to check if the import is finished or the ids of the reused projects.
+		// The current timestamp is needed, so that we can abort the getScanReport request, when the project was import x
+		// hours ago and we still get not result from Snyk.
+		job, err = s.createScanJob(r.Context(), data, image, routeName, location, projectIDs)
+		if err != nil {
+			log.Error(r.Context(), "Could not create scan job", zap.Error(err), zap.Any("artifact", data.Artifact))
+			render.JSON(w, r, http.StatusInternalServerError, harbor.SCANNER_ADAPTER_ERROR, harbor.Error{
+				Message: fmt.Sprintf("Could not create scan job: %#v", err),
+			})
+			return
+		}
+
+		// Start polling Snyk for the result of the import job right away, so that the report is hopefully ready when
+		// Harbor requests it the first time.
+		s.enqueueScanJob(job.ID, 0)
+	}
+
+	span.SetAttributes(attribute.String("scan.id", job.ID))
+
+	scanRequestID, err := createScanRequestID(s.ids, job.ID)
+	if err != nil {
+		log.Error(r.Context(), "Could not create scan request id", zap.Error(err), zap.String("scanJobID", job.ID))
 		render.JSON(w, r, http.StatusInternalServerError, harbor.SCANNER_ADAPTER_ERROR, harbor.Error{
 			Message: fmt.Sprintf("Could not create scan request id: %#v", err),
 		})
@@ -84,42 +166,81 @@ func (s *Server) acceptScanRequest(w http.ResponseWriter, r *http.Request) {
 func (s *Server) getScanReport(w http.ResponseWriter, r *http.Request) {
 	scanRequestID := chi.URLParam(r, "scan_request_id")
 
-	// The scan request id contains our base64 encoded data. So we have to decode the id to get the artifact and
-	// timestamp.
-	scanRequestIDData, err := getScanRequestID(scanRequestID)
+	// The scan request id contains the signed id of our scan job. So we have to verify the id and load the scan job
+	// from the store. Only ids created by us are accepted.
+	// An id which was not created by us can not belong to a scan job, so we return the same status code as for an
+	// unknown scan job.
+	scanJobID, err := getScanJobID(s.ids, scanRequestID)
 	if err != nil {
-		log.Error(r.Context(), "Invalid scan request id", zap.Error(err), zap.String("scanRequestID", scanRequestID), zap.Any("scanRequestIDData", scanRequestIDData))
-		render.JSON(w, r, http.StatusBadRequest, "", harbor.Error{
-			Message: fmt.Sprintf("Invalid scan request id: %#v", err),
+		log.Error(r.Context(), "Invalid scan request id", zap.Error(err), zap.String("scanRequestID", scanRequestID))
+		render.JSON(w, r, http.StatusNotFound, harbor.SCANNER_ADAPTER_ERROR, harbor.Error{
+			Message: fmt.Sprintf("Invalid scan request id: %s", err.Error()),
 		})
 		return
 	}
 
-	scanRequestTime := time.Unix(scanRequestIDData.Timestamp, 0)
-	if time.Now().After(scanRequestTime.Add(1 * time.Hour)) {
-		log.Error(r.Context(), "Scan request time is older then an hour, do not retry anymore", zap.Time("now", time.Now()), zap.Time("scanRequestTime", scanRequestTime))
+	job, err := s.store.Get(r.Context(), scanJobID)
+	if err != nil {
+		if err == ErrScanJobNotFound {
+			log.Error(r.Context(), "Scan job was not found", zap.String("scanJobID", scanJobID))
+			render.JSON(w, r, http.StatusNotFound, harbor.SCANNER_ADAPTER_ERROR, harbor.Error{
+				Message: "Scan job was not found",
+			})
+			return
+		}
+
+		log.Error(r.Context(), "Could not get scan job", zap.Error(err), zap.String("scanJobID", scanJobID))
 		render.JSON(w, r, http.StatusInternalServerError, harbor.SCANNER_ADAPTER_ERROR, harbor.Error{
-			Message: "Scan request time is older then an hour, do not retry anymore",
+			Message: fmt.Sprintf("Could not get scan job: %#v", err),
 		})
 		return
 	}
 
-	// We try to get the aggregated issues from Snyk. When this returns an error why say Harbor that it should retry the
-	// request after 5 minutes. We do not return an error, because the error would be returned after 1 hour when each
-	// retry fails.
-	// NOTE: Maybe we can built an exponential backoff to retry after 1 minute, 2 minutes, 4 minutes, ...
-	image := fmt.Sprintf("%s:%s", scanRequestIDData.Artifact.Repository, scanRequestIDData.Artifact.Tag)
+	trace.SpanFromContext(r.Context()).SetAttributes(scanJobAttributes(job)...)
 
-	issues, err := s.snykClient.GetAggregatedIssues(r.Context(), image, scanRequestIDData.Location)
-	if err != nil {
-		log.Error(r.Context(), "Could not get aggregated issues from Snyk", zap.Error(err), zap.String("image", image), zap.String("location", scanRequestIDData.Location))
-		w.Header().Set("Refresh-After", "60")
-		w.WriteHeader(http.StatusFound)
-		return
+	// When our workers are busy, a pending job may not be marked as failed yet, so we also check the deadline here. The
+	// job is only reported as failed, but not saved, because only the workers are allowed to change the status of a job.
+	if job.Status == ScanJobStatusPending && isExpiredScanJob(job) {
+		job.Status = ScanJobStatusFailed
+		job.Error = expiredScanJobMessage()
 	}
 
-	scanReport := createScanReportFromIssues(scannerData, scanRequestIDData.Artifact, issues)
-	render.JSON(w, r, http.StatusOK, harbor.SCANNER_ADAPTER_VULN_REPORT, scanReport)
+	switch job.Status {
+	case ScanJobStatusCompleted:
+		// The report is delivered to Harbor, so that the projects can be removed from Snyk after the grace period. Harbor
+		// can request the report concurrently, so that the store decides which request delivered the report first and
+		// only this request records the metric.
+		if job.ReportedAt.IsZero() {
+			scheduleCleanup(job)
+			job.ReportedAt = time.Now()
+
+			reported, err := s.store.MarkReported(r.Context(), job.ID, job.ReportedAt, job.CleanupAt)
+			if err != nil {
+				log.Error(r.Context(), "Could not update scan job", zap.Error(err), zap.String("scanJobID", job.ID))
+			} else if reported {
+				s.recordScanReported(job)
+			}
+		}
+
+		render.JSON(w, r, http.StatusOK, harbor.SCANNER_ADAPTER_VULN_REPORT, job.Report)
+	case ScanJobStatusFailed:
+		log.Error(r.Context(), "Scan job failed", zap.String("scanJobID", job.ID), zap.String("error", job.Error))
+		render.JSON(w, r, http.StatusInternalServerError, harbor.SCANNER_ADAPTER_ERROR, harbor.Error{
+			Message: job.Error,
+		})
+	default:
+		// The report is not ready yet, because our workers are still waiting for the results from Snyk. So we say
+		// Harbor that it should retry the request later. The longer a scan is pending, the less often Harbor should
+		// retry the request. When we are rate limited by Snyk, Harbor should not retry the request before our next
+		// retry.
+		refreshAfter := getRefreshAfter(time.Since(job.CreatedAt), refreshInitial, refreshMax, refreshFactor)
+		if retryAfter := time.Until(job.RetryAt); retryAfter > refreshAfter {
+			refreshAfter = retryAfter
+		}
+
+		w.Header().Set("Refresh-After", strconv.Itoa(int(math.Ceil(refreshAfter.Seconds()))))
+		w.WriteHeader(http.StatusFound)
+	}
 }
 
 func (s *Server) getMetadata(w http.ResponseWriter, r *http.Request) {
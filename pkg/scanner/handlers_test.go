@@ -0,0 +1,692 @@
+package scanner
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"fmt"
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"path/filepath"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/ricoberger/harbor-snyk-scanner/pkg/harbor"
+	"github.com/ricoberger/harbor-snyk-scanner/pkg/snyk"
+	"github.com/ricoberger/harbor-snyk-scanner/pkg/snyk/snyktest"
+
+	flag "github.com/spf13/pflag"
+	"github.com/stretchr/testify/require"
+)
+
+type fakeClient struct {
+	mu              sync.Mutex
+	imports         int
+	images          []string
+	pendingAttempts int
+	projectIDsErr   error
+	projects        []string
+	issues          []snyk.Issue
+	organisations   []string
+	orgProjects     []snyk.Project
+	deleted         []string
+	tags            map[string][]snyk.Tag
+}
+
+func (c *fakeClient) ImportProject(ctx context.Context, image string) (string, error) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	c.imports = c.imports + 1
+	c.images = append(c.images, image)
+	return "https://snyk.io/api/v1/org/org/integrations/integration/import/job", nil
+}
+
+func (c *fakeClient) GetProjectIDs(ctx context.Context, image, location string) ([]string, error) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	if c.projectIDsErr != nil {
+		return nil, c.projectIDsErr
+	}
+
+	if c.pendingAttempts > 0 {
+		c.pendingAttempts = c.pendingAttempts - 1
+		return nil, snyk.ErrImportPending
+	}
+
+	return []string{"project"}, nil
+}
+
+func (c *fakeClient) GetAggregatedIssues(ctx context.Context, projectIDs []string) ([]snyk.Issue, error) {
+	return c.issues, nil
+}
+
+func (c *fakeClient) FindProjects(ctx context.Context, image, digest string, maxAge time.Duration) ([]string, error) {
+	return c.projects, nil
+}
+
+func (c *fakeClient) DeleteProject(ctx context.Context, projectID string) error {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	c.deleted = append(c.deleted, projectID)
+	return nil
+}
+
+func (c *fakeClient) DeactivateProject(ctx context.Context, projectID string) error {
+	return nil
+}
+
+func (c *fakeClient) ListProjects(ctx context.Context, origins []string) ([]snyk.Project, error) {
+	return c.orgProjects, nil
+}
+
+func (c *fakeClient) GetProjectTags(ctx context.Context, projectID string) ([]snyk.Tag, error) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	return append([]snyk.Tag{}, c.tags[projectID]...), nil
+}
+
+func (c *fakeClient) AddProjectTag(ctx context.Context, projectID string, tag snyk.Tag) error {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	if c.tags == nil {
+		c.tags = make(map[string][]snyk.Tag)
+	}
+
+	c.tags[projectID] = append(c.tags[projectID], tag)
+	return nil
+}
+
+func (c *fakeClient) RemoveProjectTag(ctx context.Context, projectID string, tag snyk.Tag) error {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	var tags []snyk.Tag
+	for _, existing := range c.tags[projectID] {
+		if existing != tag {
+			tags = append(tags, existing)
+		}
+	}
+
+	c.tags[projectID] = tags
+	return nil
+}
+
+func (c *fakeClient) SetProjectAttributes(ctx context.Context, projectID string, attributes snyk.ProjectAttributes) error {
+	return nil
+}
+
+func (c *fakeClient) WithOrganisation(organisationID, integrationID, apiKey string) snyk.Client {
+	return &routedClient{fakeClient: c, organisationID: organisationID}
+}
+
+// routedClient is returned by the WithOrganisation method of the fakeClient. It records the organisation for all
+// imports and requests for the issues, so that we can check that the correct route was used.
+type routedClient struct {
+	*fakeClient
+	organisationID string
+}
+
+func (c *routedClient) ImportProject(ctx context.Context, image string) (string, error) {
+	c.record()
+	return c.fakeClient.ImportProject(ctx, image)
+}
+
+func (c *routedClient) GetAggregatedIssues(ctx context.Context, projectIDs []string) ([]snyk.Issue, error) {
+	c.record()
+	return c.fakeClient.GetAggregatedIssues(ctx, projectIDs)
+}
+
+func (c *routedClient) record() {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	c.organisations = append(c.organisations, c.organisationID)
+}
+
+func newTestServer(t *testing.T, client snyk.Client) *Server {
+	// The previous poll interval is restored after the workers are stopped, because the cleanup functions are called in
+	// the reverse order.
+	previousPollInterval := pollInterval
+	t.Cleanup(func() { pollInterval = previousPollInterval })
+	pollInterval = 10 * time.Millisecond
+
+	server, err := New(client)
+	require.NoError(t, err)
+
+	server.startWorkers()
+	t.Cleanup(func() {
+		server.cancel()
+		server.wg.Wait()
+		server.store.Close()
+	})
+
+	return server
+}
+
+func scan(t *testing.T, server *Server, scanRequest harbor.ScanRequest) *httptest.ResponseRecorder {
+	body, err := json.Marshal(scanRequest)
+	require.NoError(t, err)
+
+	w := httptest.NewRecorder()
+	server.server.Handler.ServeHTTP(w, httptest.NewRequest(http.MethodPost, "/api/scan", bytes.NewReader(body)))
+	return w
+}
+
+func getReport(t *testing.T, server *Server, scanRequestID string) *httptest.ResponseRecorder {
+	w := httptest.NewRecorder()
+	server.server.Handler.ServeHTTP(w, httptest.NewRequest(http.MethodGet, fmt.Sprintf("/api/scan/%s/report", scanRequestID), nil))
+	return w
+}
+
+func TestScan(t *testing.T) {
+	issue := snyk.Issue{ID: "SNYK-1", PkgName: "openssl"}
+	issue.IssueData.Severity = "high"
+
+	server := newTestServer(t, &fakeClient{pendingAttempts: 2, issues: []snyk.Issue{issue}})
+
+	w := scan(t, server, harbor.ScanRequest{Artifact: harbor.Artifact{Repository: "library/nginx", Tag: "latest"}})
+	require.Equal(t, http.StatusAccepted, w.Code)
+
+	var scanResponse harbor.ScanResponse
+	require.NoError(t, json.NewDecoder(w.Body).Decode(&scanResponse))
+
+	require.Eventually(t, func() bool {
+		return getReport(t, server, scanResponse.ID).Code == http.StatusOK
+	}, 5*time.Second, 10*time.Millisecond)
+
+	w = getReport(t, server, scanResponse.ID)
+	require.Equal(t, harbor.SCANNER_ADAPTER_VULN_REPORT, w.Header().Get("Content-Type"))
+
+	var scanReport harbor.ScanReport
+	require.NoError(t, json.NewDecoder(w.Body).Decode(&scanReport))
+	require.Equal(t, "High", scanReport.Severity)
+	require.Len(t, scanReport.Vulnerabilities, 1)
+	require.Equal(t, "SNYK-1", scanReport.Vulnerabilities[0].ID)
+}
+
+func TestScanReuseProjects(t *testing.T) {
+	reuseMaxAge = 1 * time.Hour
+	defer func() {
+		reuseMaxAge = 0
+	}()
+
+	client := &fakeClient{pendingAttempts: 1000, projects: []string{"project"}}
+	server := newTestServer(t, client)
+
+	w := scan(t, server, harbor.ScanRequest{Artifact: harbor.Artifact{Repository: "library/nginx", Tag: "latest"}})
+	require.Equal(t, http.StatusAccepted, w.Code)
+
+	var scanResponse harbor.ScanResponse
+	require.NoError(t, json.NewDecoder(w.Body).Decode(&scanResponse))
+
+	require.Eventually(t, func() bool {
+		return getReport(t, server, scanResponse.ID).Code == http.StatusOK
+	}, 5*time.Second, 10*time.Millisecond)
+	require.Equal(t, 0, client.imports)
+}
+
+func TestScanRoutes(t *testing.T) {
+	file := filepath.Join(t.TempDir(), "routes.yaml")
+	require.NoError(t, ioutil.WriteFile(file, []byte(`routes:
+  - name: team-a
+    repository:
+      prefix: team-a/
+    organisationID: org-a
+    integrationID: integration-a
+  - name: team-b
+    registry:
+      regex: ^https://harbor-b\.example\.com
+    organisationID: org-b
+    integrationID: integration-b
+`), 0600))
+
+	routesFile = file
+	defer func() {
+		routesFile = ""
+	}()
+
+	client := &fakeClient{}
+	server := newTestServer(t, client)
+
+	for _, scanRequest := range []harbor.ScanRequest{
+		{Registry: harbor.Registry{URL: "https://harbor.example.com"}, Artifact: harbor.Artifact{Repository: "team-a/app", Tag: "latest"}},
+		{Registry: harbor.Registry{URL: "https://harbor-b.example.com"}, Artifact: harbor.Artifact{Repository: "team-b/app", Tag: "latest"}},
+		{Registry: harbor.Registry{URL: "https://harbor.example.com"}, Artifact: harbor.Artifact{Repository: "team-c/app", Tag: "latest"}},
+	} {
+		w := scan(t, server, scanRequest)
+		require.Equal(t, http.StatusAccepted, w.Code)
+
+		var scanResponse harbor.ScanResponse
+		require.NoError(t, json.NewDecoder(w.Body).Decode(&scanResponse))
+
+		require.Eventually(t, func() bool {
+			return getReport(t, server, scanResponse.ID).Code == http.StatusOK
+		}, 5*time.Second, 10*time.Millisecond)
+	}
+
+	require.Equal(t, 3, client.imports)
+	require.Equal(t, []string{"org-a", "org-a", "org-b", "org-b"}, client.organisations)
+
+	jobs, err := server.store.List(context.Background())
+	require.NoError(t, err)
+	require.Len(t, jobs, 3)
+	require.Equal(t, "team-a", jobs[0].Route)
+	require.Equal(t, "team-b", jobs[1].Route)
+	require.Equal(t, "", jobs[2].Route)
+}
+
+func TestScanByDigest(t *testing.T) {
+	client := &fakeClient{}
+	server := newTestServer(t, client)
+
+	w := scan(t, server, harbor.ScanRequest{Artifact: harbor.Artifact{Repository: "library/nginx", Digest: "sha256:1234"}})
+	require.Equal(t, http.StatusAccepted, w.Code)
+
+	w = scan(t, server, harbor.ScanRequest{Artifact: harbor.Artifact{Repository: "library/nginx", Tag: "latest", Digest: "sha256:5678"}})
+	require.Equal(t, http.StatusAccepted, w.Code)
+
+	w = scan(t, server, harbor.ScanRequest{Artifact: harbor.Artifact{Repository: "library/nginx"}})
+	require.Equal(t, http.StatusUnprocessableEntity, w.Code)
+
+	require.Equal(t, []string{"library/nginx@sha256:1234", "library/nginx@sha256:5678"}, client.images)
+}
+
+func TestScanPending(t *testing.T) {
+	server := newTestServer(t, &fakeClient{pendingAttempts: 1000})
+
+	w := scan(t, server, harbor.ScanRequest{Artifact: harbor.Artifact{Repository: "library/nginx", Tag: "latest"}})
+	require.Equal(t, http.StatusAccepted, w.Code)
+
+	var scanResponse harbor.ScanResponse
+	require.NoError(t, json.NewDecoder(w.Body).Decode(&scanResponse))
+
+	w = getReport(t, server, scanResponse.ID)
+	require.Equal(t, http.StatusFound, w.Code)
+	require.Equal(t, "60", w.Header().Get("Refresh-After"))
+}
+
+func TestScanPendingExpired(t *testing.T) {
+	server := newTestServer(t, &fakeClient{})
+
+	// The job is not enqueued, so that it stays pending like a job, which is not processed because the workers are busy.
+	job := &ScanJob{ID: "expired", Status: ScanJobStatusPending, CreatedAt: time.Now().Add(-2 * deadline)}
+	require.NoError(t, server.store.Create(context.Background(), job))
+
+	scanRequestID, err := createScanRequestID(server.ids, job.ID)
+	require.NoError(t, err)
+
+	w := getReport(t, server, scanRequestID)
+	require.Equal(t, http.StatusInternalServerError, w.Code)
+	require.Contains(t, w.Body.String(), "Scan job is older than")
+
+	// Only the workers are allowed to change the status of a job, so that the job must still be pending in the store.
+	job, err = server.store.Get(context.Background(), job.ID)
+	require.NoError(t, err)
+	require.Equal(t, ScanJobStatusPending, job.Status)
+}
+
+func TestScanImportFailed(t *testing.T) {
+	server := newTestServer(t, &fakeClient{projectIDsErr: &snyk.ImportFailedError{Status: "failed", Messages: []string{"Image not found"}}})
+
+	w := scan(t, server, harbor.ScanRequest{Artifact: harbor.Artifact{Repository: "library/nginx", Tag: "latest"}})
+	require.Equal(t, http.StatusAccepted, w.Code)
+
+	var scanResponse harbor.ScanResponse
+	require.NoError(t, json.NewDecoder(w.Body).Decode(&scanResponse))
+
+	require.Eventually(t, func() bool {
+		return getReport(t, server, scanResponse.ID).Code == http.StatusInternalServerError
+	}, 5*time.Second, 10*time.Millisecond)
+
+	w = getReport(t, server, scanResponse.ID)
+	require.Equal(t, harbor.SCANNER_ADAPTER_ERROR, w.Header().Get("Content-Type"))
+
+	var scanError harbor.Error
+	require.NoError(t, json.NewDecoder(w.Body).Decode(&scanError))
+	require.Equal(t, "Import of the image into Snyk failed: import job failed: Image not found", scanError.Message)
+}
+
+func TestScanSnykErrors(t *testing.T) {
+	for _, tt := range []struct {
+		name                 string
+		err                  error
+		expectedStatus       int
+		expectedRefreshAfter string
+	}{
+		{name: "unauthorized", err: &snyk.APIError{StatusCode: http.StatusUnauthorized}, expectedStatus: http.StatusInternalServerError},
+		{name: "not found", err: &snyk.APIError{StatusCode: http.StatusNotFound}, expectedStatus: http.StatusInternalServerError},
+		{name: "rate limited", err: &snyk.RateLimitError{RetryAfter: 5 * time.Minute, Err: &snyk.APIError{StatusCode: http.StatusTooManyRequests}}, expectedStatus: http.StatusFound, expectedRefreshAfter: "300"},
+		{name: "unavailable", err: &snyk.APIError{StatusCode: http.StatusBadGateway}, expectedStatus: http.StatusFound, expectedRefreshAfter: "60"},
+	} {
+		t.Run(tt.name, func(t *testing.T) {
+			server := newTestServer(t, &fakeClient{projectIDsErr: tt.err})
+
+			w := scan(t, server, harbor.ScanRequest{Artifact: harbor.Artifact{Repository: "library/nginx", Tag: "latest"}})
+			require.Equal(t, http.StatusAccepted, w.Code)
+
+			var scanResponse harbor.ScanResponse
+			require.NoError(t, json.NewDecoder(w.Body).Decode(&scanResponse))
+
+			require.Eventually(t, func() bool {
+				jobs, err := server.store.List(context.Background())
+				return err == nil && len(jobs) == 1 && jobs[0].Attempts > 0
+			}, 5*time.Second, 10*time.Millisecond)
+
+			w = getReport(t, server, scanResponse.ID)
+			require.Equal(t, tt.expectedStatus, w.Code)
+			if tt.expectedRefreshAfter != "" {
+				require.Equal(t, tt.expectedRefreshAfter, w.Header().Get("Refresh-After"))
+			}
+		})
+	}
+}
+
+func TestGetScanReportInvalidID(t *testing.T) {
+	server := newTestServer(t, &fakeClient{})
+
+	require.Equal(t, http.StatusNotFound, getReport(t, server, "invalid").Code)
+
+	scanRequestID, err := createScanRequestID(server.ids, "unknown")
+	require.NoError(t, err)
+	require.Equal(t, http.StatusNotFound, getReport(t, server, scanRequestID).Code)
+}
+
+func TestNewInvalidImageTemplate(t *testing.T) {
+	defaultImageTemplate := imageTemplate
+	imageTemplate = "{{ .Repository }"
+	defer func() {
+		imageTemplate = defaultImageTemplate
+	}()
+
+	_, err := New(&fakeClient{})
+	require.Error(t, err)
+	require.Contains(t, err.Error(), "invalid image template")
+}
+
+func TestNewBoltStoreWithoutSecret(t *testing.T) {
+	defer func(previousSecrets []string, previousStoreType string) {
+		secrets = previousSecrets
+		storeType = previousStoreType
+	}(secrets, storeType)
+
+	secrets = nil
+	storeType = "bolt"
+
+	_, err := New(&fakeClient{})
+	require.EqualError(t, err, "at least one secret for the scan request ids is required, when the \"bolt\" store is used")
+}
+
+// newSnykServer returns a fake Snyk API with the fixtures from the snyktest package and a Snyk client, which uses the
+// fake API.
+func newSnykServer(t *testing.T) (*snyktest.Server, snyk.Client) {
+	snykServer := snyktest.NewServer("apikey", "org", "integration")
+	t.Cleanup(snykServer.Close)
+
+	require.NoError(t, snykServer.LoadFixtures("../snyk/snyktest/testdata/fixtures.json"))
+
+	for _, name := range []string{"snyk.base-url", "snyk.api-key", "snyk.organisation-id", "snyk.integration-id", "snyk.retry-wait-min", "snyk.retry-wait-max"} {
+		name, previous := name, flag.Lookup(name).Value.String()
+		t.Cleanup(func() { flag.Set(name, previous) })
+	}
+
+	require.NoError(t, snykServer.SetFlags())
+	require.NoError(t, flag.Set("snyk.retry-wait-min", "1ms"))
+	require.NoError(t, flag.Set("snyk.retry-wait-max", "10ms"))
+
+	client, err := snyk.NewClient()
+	require.NoError(t, err)
+
+	return snykServer, client
+}
+
+func TestEndToEndScan(t *testing.T) {
+	_, client := newSnykServer(t)
+	server := newTestServer(t, client)
+
+	w := scan(t, server, harbor.ScanRequest{Artifact: harbor.Artifact{Repository: "library/nginx", Tag: "latest"}})
+	require.Equal(t, http.StatusAccepted, w.Code)
+
+	var scanResponse harbor.ScanResponse
+	require.NoError(t, json.NewDecoder(w.Body).Decode(&scanResponse))
+
+	require.Eventually(t, func() bool {
+		return getReport(t, server, scanResponse.ID).Code == http.StatusOK
+	}, 5*time.Second, 10*time.Millisecond)
+
+	var scanReport harbor.ScanReport
+	require.NoError(t, json.NewDecoder(getReport(t, server, scanResponse.ID).Body).Decode(&scanReport))
+	require.Equal(t, "High", scanReport.Severity)
+	require.Len(t, scanReport.Vulnerabilities, 2)
+	require.Equal(t, "SNYK-DEBIAN11-OPENSSL-2388380", scanReport.Vulnerabilities[0].ID)
+	require.Equal(t, "1.1.1n-0+deb11u1", scanReport.Vulnerabilities[0].FixVersion)
+	require.Equal(t, 7.5, *scanReport.Vulnerabilities[0].PreferredCVSS.ScoreV3)
+	require.Equal(t, "Low", scanReport.Vulnerabilities[1].Severity)
+}
+
+func TestEndToEndScanProjectMetadata(t *testing.T) {
+	defer func(previousTags, previousAttributes map[string]string) {
+		projectTags = previousTags
+		projectAttributes = previousAttributes
+	}(projectTags, projectAttributes)
+
+	projectTags = map[string]string{"harbor-project": `{{ index (split .Artifact.Repository "/") 0 }}`, "digest": "{{ .Artifact.Digest }}"}
+	projectAttributes = map[string]string{"lifecycle": "production"}
+
+	snykServer, client := newSnykServer(t)
+	server := newTestServer(t, client)
+
+	w := scan(t, server, harbor.ScanRequest{Artifact: harbor.Artifact{Repository: "library/nginx", Tag: "latest"}})
+	require.Equal(t, http.StatusAccepted, w.Code)
+
+	var scanResponse harbor.ScanResponse
+	require.NoError(t, json.NewDecoder(w.Body).Decode(&scanResponse))
+
+	require.Eventually(t, func() bool {
+		return getReport(t, server, scanResponse.ID).Code == http.StatusOK
+	}, 5*time.Second, 10*time.Millisecond)
+
+	require.Equal(t, []snyk.Tag{managedProjectTag, {Key: "harbor-project", Value: "library"}}, snykServer.ProjectTags("nginx-os"))
+	require.Equal(t, snyk.ProjectAttributes{Lifecycle: []string{"production"}}, snykServer.ProjectAttributes("nginx-os"))
+}
+
+func TestEndToEndScanImportFailed(t *testing.T) {
+	_, client := newSnykServer(t)
+	server := newTestServer(t, client)
+
+	w := scan(t, server, harbor.ScanRequest{Artifact: harbor.Artifact{Repository: "library/broken", Tag: "latest"}})
+	require.Equal(t, http.StatusAccepted, w.Code)
+
+	var scanResponse harbor.ScanResponse
+	require.NoError(t, json.NewDecoder(w.Body).Decode(&scanResponse))
+
+	require.Eventually(t, func() bool {
+		return getReport(t, server, scanResponse.ID).Code == http.StatusInternalServerError
+	}, 5*time.Second, 10*time.Millisecond)
+
+	var scanError harbor.Error
+	require.NoError(t, json.NewDecoder(getReport(t, server, scanResponse.ID).Body).Decode(&scanError))
+	require.Equal(t, "Import of the image into Snyk failed: import job failed: Image not found", scanError.Message)
+}
+
+func TestEndToEndScanRateLimited(t *testing.T) {
+	snykServer, client := newSnykServer(t)
+	snykServer.AddFault(snyktest.Fault{Method: http.MethodPost, Path: "/api/v1/org/org/integrations/", StatusCode: http.StatusTooManyRequests, Header: http.Header{"Retry-After": []string{"120"}}, Body: `{"code": 429, "message": "Too many requests"}`})
+	server := newTestServer(t, client)
+
+	w := scan(t, server, harbor.ScanRequest{Artifact: harbor.Artifact{Repository: "library/nginx", Tag: "latest"}})
+	require.Equal(t, http.StatusServiceUnavailable, w.Code)
+	require.Equal(t, "120", w.Header().Get("Retry-After"))
+}
+
+func TestEndToEndScanServerErrors(t *testing.T) {
+	snykServer, client := newSnykServer(t)
+	snykServer.AddFault(snyktest.Fault{Method: http.MethodGet, StatusCode: http.StatusBadGateway, Body: "<html>Bad Gateway</html>", Count: 10})
+	snykServer.AddFault(snyktest.Fault{Path: "/api/v1/org/org/project/", Latency: 20 * time.Millisecond})
+	server := newTestServer(t, client)
+
+	w := scan(t, server, harbor.ScanRequest{Artifact: harbor.Artifact{Repository: "library/nginx", Tag: "latest"}})
+	require.Equal(t, http.StatusAccepted, w.Code)
+
+	var scanResponse harbor.ScanResponse
+	require.NoError(t, json.NewDecoder(w.Body).Decode(&scanResponse))
+
+	require.Eventually(t, func() bool {
+		return getReport(t, server, scanResponse.ID).Code == http.StatusOK
+	}, 5*time.Second, 10*time.Millisecond)
+}
+
+func TestEndToEndEphemeral(t *testing.T) {
+	defer func() {
+		ephemeral = ""
+		ephemeralGracePeriod = 10 * time.Minute
+		ephemeralDryRun = false
+	}()
+
+	for _, tt := range []struct {
+		name                string
+		mode                string
+		dryRun              bool
+		expectedDeleted     []string
+		expectedDeactivated []string
+	}{
+		{name: "delete", mode: "delete", expectedDeleted: []string{"nginx-os"}},
+		{name: "deactivate", mode: "deactivate", expectedDeactivated: []string{"nginx-os"}},
+		{name: "dry run", mode: "delete", dryRun: true},
+	} {
+		t.Run(tt.name, func(t *testing.T) {
+			ephemeral = tt.mode
+			ephemeralGracePeriod = 0
+			ephemeralDryRun = tt.dryRun
+
+			snykServer, client := newSnykServer(t)
+			server := newTestServer(t, client)
+
+			w := scan(t, server, harbor.ScanRequest{Artifact: harbor.Artifact{Repository: "library/nginx", Tag: "latest"}})
+			require.Equal(t, http.StatusAccepted, w.Code)
+
+			var scanResponse harbor.ScanResponse
+			require.NoError(t, json.NewDecoder(w.Body).Decode(&scanResponse))
+
+			// The projects must not be removed before the report was delivered to Harbor.
+			require.Eventually(t, func() bool {
+				jobs, err := server.store.List(context.Background())
+				return err == nil && len(jobs) == 1 && jobs[0].Status == ScanJobStatusCompleted
+			}, 5*time.Second, 10*time.Millisecond)
+
+			server.cleanupScanJobs(context.Background())
+			require.Empty(t, snykServer.DeletedProjects())
+			require.Empty(t, snykServer.DeactivatedProjects())
+
+			require.Equal(t, http.StatusOK, getReport(t, server, scanResponse.ID).Code)
+			server.cleanupScanJobs(context.Background())
+			server.cleanupScanJobs(context.Background())
+
+			require.Equal(t, tt.expectedDeleted, nilIfEmpty(snykServer.DeletedProjects()))
+			require.Equal(t, tt.expectedDeactivated, nilIfEmpty(snykServer.DeactivatedProjects()))
+
+			jobs, err := server.store.List(context.Background())
+			require.NoError(t, err)
+			require.True(t, jobs[0].CleanedUp)
+		})
+	}
+}
+
+func nilIfEmpty(values []string) []string {
+	if len(values) == 0 {
+		return nil
+	}
+
+	return values
+}
+
+func TestCleanupSharedProjects(t *testing.T) {
+	defer func() {
+		ephemeral = ""
+	}()
+
+	ephemeral = "delete"
+
+	store, err := NewStore("memory", "")
+	require.NoError(t, err)
+	defer store.Close()
+
+	client := &fakeClient{}
+	server := &Server{store: store, clients: map[string]snyk.Client{"": client}}
+
+	ctx := context.Background()
+	now := time.Now()
+	location := "https://snyk.io/api/v1/org/org/integrations/integration/import/job"
+
+	// Snyk refreshes the project, when the image is imported again, so that both jobs contain the same project. The
+	// report of the second job was not delivered yet.
+	oldJob := &ScanJob{ID: "job1", Image: "library/nginx:latest", Location: location, ProjectIDs: []string{"project", "old-project"}, Status: ScanJobStatusCompleted, CreatedAt: now, CleanupAt: now.Add(-1 * time.Minute)}
+	newJob := &ScanJob{ID: "job2", Image: "library/nginx:latest", Location: location, ProjectIDs: []string{"project"}, Status: ScanJobStatusCompleted, CreatedAt: now}
+	require.NoError(t, store.Create(ctx, oldJob))
+	require.NoError(t, store.Create(ctx, newJob))
+
+	server.cleanupScanJobs(ctx)
+	require.Equal(t, []string{"old-project"}, client.deleted)
+
+	job, err := store.Get(ctx, "job1")
+	require.NoError(t, err)
+	require.False(t, job.CleanedUp)
+
+	// A pending job for the same image, which doesn't know its projects yet, blocks the cleanup of all projects.
+	newJob.CleanupAt = now.Add(-1 * time.Minute)
+	require.NoError(t, store.Update(ctx, newJob))
+	require.NoError(t, store.Create(ctx, &ScanJob{ID: "job3", Image: "library/nginx:latest", Location: location, Status: ScanJobStatusPending, CreatedAt: now}))
+
+	server.cleanupScanJobs(ctx)
+	require.Equal(t, []string{"old-project"}, client.deleted)
+
+	// When no other job needs the project anymore, it is removed only once for both jobs. The retry of the first job
+	// removes its other project again, the not found error of Snyk is ignored.
+	require.NoError(t, store.Delete(ctx, "job3"))
+
+	server.cleanupScanJobs(ctx)
+	require.ElementsMatch(t, []string{"old-project", "old-project", "project"}, client.deleted)
+
+	jobs, err := store.List(ctx)
+	require.NoError(t, err)
+	for _, job := range jobs {
+		require.True(t, job.CleanedUp)
+	}
+}
+
+func TestNewEphemeralWithReuse(t *testing.T) {
+	defer func(previousEphemeral string, previousReuseMaxAge time.Duration) {
+		ephemeral = previousEphemeral
+		reuseMaxAge = previousReuseMaxAge
+	}(ephemeral, reuseMaxAge)
+
+	ephemeral = "delete"
+	reuseMaxAge = time.Hour
+
+	_, err := New(&fakeClient{})
+	require.EqualError(t, err, "the ephemeral mode can not be used together with the reuse of existing projects")
+}
+
+func TestScheduleCleanup(t *testing.T) {
+	defer func() {
+		ephemeral = ""
+	}()
+
+	job := &ScanJob{Location: "https://snyk.io/api/v1/org/org/integrations/integration/import/job"}
+	require.False(t, scheduleCleanup(job))
+	require.True(t, job.CleanupAt.IsZero())
+
+	ephemeral = "delete"
+	require.True(t, scheduleCleanup(job))
+	require.False(t, job.CleanupAt.IsZero())
+	require.False(t, scheduleCleanup(job))
+
+	// Reused projects were not created by us, so that they must never be removed.
+	require.False(t, scheduleCleanup(&ScanJob{ProjectIDs: []string{"project"}}))
+}
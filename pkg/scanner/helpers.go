@@ -1,53 +1,135 @@
 package scanner
 
 import (
-	"encoding/base64"
-	"encoding/json"
+	"bytes"
+	"errors"
+	"fmt"
+	"net/http"
+	"net/url"
 	"strings"
+	"text/template"
 	"time"
 
 	"github.com/ricoberger/harbor-snyk-scanner/pkg/harbor"
 	"github.com/ricoberger/harbor-snyk-scanner/pkg/snyk"
 )
 
-type ScanRequestID struct {
-	Timestamp int64           `json:"timestamp"`
-	Location  string          `json:"location"`
-	Artifact  harbor.Artifact `json:"artifact"`
+// createScanRequestID returns the scan request id for the given scan job id. The scan request id is returned to Harbor
+// and must be passed to the getScanReport handler to get the report for the scan job.
+func createScanRequestID(codec *idCodec, scanJobID string) (string, error) {
+	return codec.encode([]byte(scanJobID))
 }
 
-func createScanRequestID(artifact harbor.Artifact, location string) (string, error) {
-	data, err := json.Marshal(ScanRequestID{Timestamp: time.Now().Unix(), Location: location, Artifact: artifact})
+// getScanJobID returns the scan job id for the given scan request id. An error is returned when the scan request id
+// was not created by us.
+func getScanJobID(codec *idCodec, scanRequestID string) (string, error) {
+	data, err := codec.decode(scanRequestID)
 	if err != nil {
 		return "", err
 	}
 
-	return base64.StdEncoding.EncodeToString(data), nil
+	return string(data), nil
 }
 
-func getScanRequestID(id string) (*ScanRequestID, error) {
-	data, err := base64.StdEncoding.DecodeString(id)
+// imageTemplateData is the data, which is passed to the image template. Registry is the host of the registry url, so
+// that the image can be prefixed with the registry, when this is required by the Snyk integration.
+type imageTemplateData struct {
+	Registry    string
+	RegistryURL string
+	Repository  string
+	Tag         string
+	Digest      string
+}
+
+// parseImageTemplate parses the given template for the image. The template is executed with some example data, so that
+// we also detect errors which are only returned during the execution, e.g. when an unknown field is used.
+func parseImageTemplate(text string) (*template.Template, error) {
+	tmpl, err := template.New("image").Option("missingkey=error").Parse(text)
 	if err != nil {
 		return nil, err
 	}
 
-	var scanRequestID ScanRequestID
-	err = json.Unmarshal(data, &scanRequestID)
+	image, err := getImage(tmpl, harbor.Registry{URL: "https://harbor.example.com"}, harbor.Artifact{Repository: "library/nginx", Tag: "latest", Digest: "sha256:0000000000000000000000000000000000000000000000000000000000000000"})
 	if err != nil {
 		return nil, err
 	}
 
-	return &scanRequestID, nil
+	if image == "" {
+		return nil, fmt.Errorf("template returns an empty image")
+	}
+
+	return tmpl, nil
+}
+
+// getImage returns the image, which is imported into Snyk for the given artifact. The image is rendered via the
+// configured template. By default the image is imported by its digest when Harbor provides one, so that we get the
+// report for the requested artifact, even when the tag was pushed again before Snyk pulled the image. The scanned digest
+// is not validated afterwards, so that a template without the digest loses this guarantee.
+func getImage(tmpl *template.Template, registry harbor.Registry, artifact harbor.Artifact) (string, error) {
+	data := imageTemplateData{
+		Registry:    registry.URL,
+		RegistryURL: registry.URL,
+		Repository:  artifact.Repository,
+		Tag:         artifact.Tag,
+		Digest:      artifact.Digest,
+	}
+
+	if u, err := url.Parse(registry.URL); err == nil && u.Host != "" {
+		data.Registry = u.Host
+	}
+
+	var image bytes.Buffer
+	if err := tmpl.Execute(&image, data); err != nil {
+		return "", err
+	}
+
+	return strings.TrimSpace(image.String()), nil
+}
+
+// getRefreshAfter returns the interval after which Harbor should retry to get the report for a scan job with the given
+// age. The interval starts with the initial value and is multiplied by the factor each time it elapsed, until the max
+// value is reached. This is the schedule Harbor follows, when it retries the request after the returned interval.
+func getRefreshAfter(age, initial, max time.Duration, factor float64) time.Duration {
+	interval := initial
+
+	for elapsed := interval; elapsed <= age && interval < max; elapsed = elapsed + interval {
+		interval = time.Duration(float64(interval) * factor)
+		if interval > max {
+			interval = max
+		}
+	}
+
+	return interval
+}
+
+// getImportErrorResponse returns the status code for the response to Harbor, when the import of an image into Snyk
+// failed. When we are rate limited or Snyk is not available we return a 503 status code, so that Harbor knows that the
+// request can be retried. For rate limits we also return the duration after which the request can be retried. All
+// other errors are returned with a 500 status code.
+func getImportErrorResponse(err error) (int, time.Duration) {
+	var rateLimitErr *snyk.RateLimitError
+	if errors.As(err, &rateLimitErr) {
+		return http.StatusServiceUnavailable, rateLimitErr.RetryAfter
+	}
+
+	if errors.Is(err, snyk.ErrUnavailable) {
+		return http.StatusServiceUnavailable, 0
+	}
+
+	return http.StatusInternalServerError, 0
 }
 
-func createScanReportFromIssues(scanner harbor.Scanner, artifact harbor.Artifact, issues []snyk.Issue) harbor.ScanReport {
+// createScanReportFromIssues creates the scan report for Harbor from the given issues. License issues are reported as
+// vulnerabilities, because Harbor doesn't know another kind of finding. They are marked via the issue type and the id
+// of the license in the vendor attributes and their severity can be changed via the given mapping.
+func createScanReportFromIssues(scanner harbor.Scanner, artifact harbor.Artifact, issues []snyk.Issue, licenseSeverities map[string]string) harbor.ScanReport {
 	var vulnerabilities []harbor.Vulnerability
 	severity := "unknown"
 
 	for _, issue := range issues {
-		severity = getSeverity(severity, issue.IssueData.Severity)
+		issue := issue
 
-		vulnerabilities = append(vulnerabilities, harbor.Vulnerability{
+		vulnerability := harbor.Vulnerability{
 			ID:          issue.ID,
 			Pkg:         issue.PkgName,
 			Version:     strings.Join(issue.PkgVersions, ", "),
@@ -61,7 +143,26 @@ func createScanReportFromIssues(scanner harbor.Scanner, artifact harbor.Artifact
 			},
 			CweIDs:           issue.IssueData.Identifiers.Cwe,
 			VendorAttributes: map[string]interface{}{},
-		})
+		}
+
+		issueSeverity := issue.IssueData.Severity
+
+		if issue.IssueType == snyk.IssueTypeLicense {
+			if mappedSeverity, ok := licenseSeverities[issueSeverity]; ok {
+				issueSeverity = mappedSeverity
+			}
+
+			vulnerability.Severity = formatSeverity(issueSeverity)
+			vulnerability.PreferredCVSS = nil
+			vulnerability.VendorAttributes["issueType"] = snyk.IssueTypeLicense
+			vulnerability.VendorAttributes["license"] = getLicenseID(issue)
+			if vulnerability.Description == "" {
+				vulnerability.Description = issue.IssueData.Title
+			}
+		}
+
+		severity = getSeverity(severity, issueSeverity)
+		vulnerabilities = append(vulnerabilities, vulnerability)
 	}
 
 	return harbor.ScanReport{
@@ -73,6 +174,30 @@ func createScanReportFromIssues(scanner harbor.Scanner, artifact harbor.Artifact
 	}
 }
 
+// getLicenseID returns the id of the license for the given license issue. Snyk uses ids like
+// "snyk:lic:deb:gcc-8:GPL-3.0" for license issues, where the last part is the id of the license.
+func getLicenseID(issue snyk.Issue) string {
+	id := issue.IssueData.ID
+	if id == "" {
+		id = issue.ID
+	}
+
+	return id[strings.LastIndex(id, ":")+1:]
+}
+
+// validateSeverityMapping returns an error, when the given mapping contains an unknown severity.
+func validateSeverityMapping(mapping map[string]string) error {
+	for from, to := range mapping {
+		for _, severity := range []string{from, to} {
+			if formatSeverity(severity) == "Unknown" {
+				return fmt.Errorf("unknown severity %q, must be critical, high, medium or low", severity)
+			}
+		}
+	}
+
+	return nil
+}
+
 func getSeverity(currentSeverity, newSeverity string) string {
 	if currentSeverity == "critical" {
 		return currentSeverity
@@ -1,7 +1,13 @@
 package scanner
 
 import (
+	"fmt"
+	"net/http"
 	"testing"
+	"time"
+
+	"github.com/ricoberger/harbor-snyk-scanner/pkg/harbor"
+	"github.com/ricoberger/harbor-snyk-scanner/pkg/snyk"
 
 	"github.com/stretchr/testify/require"
 )
@@ -22,3 +28,134 @@ func TestGetSeverity(t *testing.T) {
 
 	require.Equal(t, "high", getSeverity("unknown", "high"))
 }
+
+func TestScanRequestID(t *testing.T) {
+	for _, encrypt := range []bool{false, true} {
+		codec, err := newIDCodec([]string{"secret"}, encrypt)
+		require.NoError(t, err)
+
+		id, err := createScanRequestID(codec, "scan-job-id")
+		require.NoError(t, err)
+		require.NotContains(t, id, "/")
+		require.NotContains(t, id, "+")
+
+		scanJobID, err := getScanJobID(codec, id)
+		require.NoError(t, err)
+		require.Equal(t, "scan-job-id", scanJobID)
+
+		_, err = getScanJobID(codec, "x"+id)
+		require.ErrorIs(t, err, ErrInvalidScanRequestID)
+
+		otherCodec, err := newIDCodec([]string{"other"}, encrypt)
+		require.NoError(t, err)
+		_, err = getScanJobID(otherCodec, id)
+		require.ErrorIs(t, err, ErrInvalidScanRequestID)
+
+		rotatedCodec, err := newIDCodec([]string{"new", "secret"}, encrypt)
+		require.NoError(t, err)
+		scanJobID, err = getScanJobID(rotatedCodec, id)
+		require.NoError(t, err)
+		require.Equal(t, "scan-job-id", scanJobID)
+	}
+}
+
+func TestGetImage(t *testing.T) {
+	tmpl, err := parseImageTemplate(imageTemplate)
+	require.NoError(t, err)
+
+	registry := harbor.Registry{URL: "https://harbor.example.com"}
+
+	image, err := getImage(tmpl, registry, harbor.Artifact{Repository: "library/nginx", Tag: "latest"})
+	require.NoError(t, err)
+	require.Equal(t, "library/nginx:latest", image)
+
+	image, err = getImage(tmpl, registry, harbor.Artifact{Repository: "library/nginx", Tag: "latest", Digest: "sha256:1234"})
+	require.NoError(t, err)
+	require.Equal(t, "library/nginx@sha256:1234", image)
+
+	tmpl, err = parseImageTemplate("{{ .Registry }}/{{ .Repository }}:{{ .Tag }}")
+	require.NoError(t, err)
+
+	image, err = getImage(tmpl, registry, harbor.Artifact{Repository: "library/nginx", Tag: "latest", Digest: "sha256:1234"})
+	require.NoError(t, err)
+	require.Equal(t, "harbor.example.com/library/nginx:latest", image)
+}
+
+func TestParseImageTemplate(t *testing.T) {
+	_, err := parseImageTemplate("{{ .Repository }")
+	require.Error(t, err)
+
+	_, err = parseImageTemplate("{{ .Repo }}:{{ .Tag }}")
+	require.Error(t, err)
+
+	_, err = parseImageTemplate("")
+	require.EqualError(t, err, "template returns an empty image")
+}
+
+func TestGetRefreshAfter(t *testing.T) {
+	require.Equal(t, 1*time.Minute, getRefreshAfter(0, 1*time.Minute, 10*time.Minute, 2))
+	require.Equal(t, 1*time.Minute, getRefreshAfter(59*time.Second, 1*time.Minute, 10*time.Minute, 2))
+	require.Equal(t, 2*time.Minute, getRefreshAfter(1*time.Minute, 1*time.Minute, 10*time.Minute, 2))
+	require.Equal(t, 4*time.Minute, getRefreshAfter(3*time.Minute, 1*time.Minute, 10*time.Minute, 2))
+	require.Equal(t, 8*time.Minute, getRefreshAfter(7*time.Minute, 1*time.Minute, 10*time.Minute, 2))
+	require.Equal(t, 10*time.Minute, getRefreshAfter(15*time.Minute, 1*time.Minute, 10*time.Minute, 2))
+	require.Equal(t, 10*time.Minute, getRefreshAfter(10*time.Hour, 1*time.Minute, 10*time.Minute, 2))
+	require.Equal(t, 1*time.Minute, getRefreshAfter(10*time.Hour, 1*time.Minute, 10*time.Minute, 1))
+}
+
+func TestGetImportErrorResponse(t *testing.T) {
+	status, retryAfter := getImportErrorResponse(&snyk.RateLimitError{RetryAfter: 30 * time.Second, Err: &snyk.APIError{StatusCode: http.StatusTooManyRequests}})
+	require.Equal(t, http.StatusServiceUnavailable, status)
+	require.Equal(t, 30*time.Second, retryAfter)
+
+	status, retryAfter = getImportErrorResponse(&snyk.NetworkError{Err: fmt.Errorf("connection refused")})
+	require.Equal(t, http.StatusServiceUnavailable, status)
+	require.Equal(t, time.Duration(0), retryAfter)
+
+	status, _ = getImportErrorResponse(&snyk.APIError{StatusCode: http.StatusUnauthorized})
+	require.Equal(t, http.StatusInternalServerError, status)
+}
+
+func TestCreateScanReportFromIssues(t *testing.T) {
+	var vuln snyk.Issue
+	vuln.ID = "SNYK-DEBIAN10-OPENSSL-1569403"
+	vuln.IssueType = snyk.IssueTypeVuln
+	vuln.PkgName = "openssl"
+	vuln.IssueData.Severity = "medium"
+	vuln.IssueData.CvssScore = 5.9
+
+	var license snyk.Issue
+	license.ID = "snyk:lic:deb:gcc-8:GPL-3.0"
+	license.IssueType = snyk.IssueTypeLicense
+	license.PkgName = "gcc-8"
+	license.IssueData.ID = "snyk:lic:deb:gcc-8:GPL-3.0"
+	license.IssueData.Title = "GPL-3.0 license"
+	license.IssueData.Severity = "medium"
+
+	t.Run("without mapping", func(t *testing.T) {
+		report := createScanReportFromIssues(scannerData, harbor.Artifact{}, []snyk.Issue{vuln, license}, nil)
+		require.Equal(t, "Medium", report.Severity)
+		require.Len(t, report.Vulnerabilities, 2)
+
+		require.Equal(t, 5.9, *report.Vulnerabilities[0].PreferredCVSS.ScoreV3)
+		require.Empty(t, report.Vulnerabilities[0].VendorAttributes)
+
+		require.Equal(t, "Medium", report.Vulnerabilities[1].Severity)
+		require.Equal(t, "GPL-3.0 license", report.Vulnerabilities[1].Description)
+		require.Nil(t, report.Vulnerabilities[1].PreferredCVSS)
+		require.Equal(t, map[string]interface{}{"issueType": "license", "license": "GPL-3.0"}, report.Vulnerabilities[1].VendorAttributes)
+	})
+
+	t.Run("with mapping", func(t *testing.T) {
+		report := createScanReportFromIssues(scannerData, harbor.Artifact{}, []snyk.Issue{vuln, license}, map[string]string{"medium": "critical"})
+		require.Equal(t, "Critical", report.Severity)
+		require.Equal(t, "Medium", report.Vulnerabilities[0].Severity)
+		require.Equal(t, "Critical", report.Vulnerabilities[1].Severity)
+	})
+}
+
+func TestValidateSeverityMapping(t *testing.T) {
+	require.NoError(t, validateSeverityMapping(nil))
+	require.NoError(t, validateSeverityMapping(map[string]string{"high": "critical", "low": "medium"}))
+	require.EqualError(t, validateSeverityMapping(map[string]string{"high": "severe"}), "unknown severity \"severe\", must be critical, high, medium or low")
+}
@@ -0,0 +1,152 @@
+package scanner
+
+import (
+	"crypto/aes"
+	"crypto/cipher"
+	"crypto/hmac"
+	"crypto/rand"
+	"crypto/sha256"
+	"encoding/base64"
+	"fmt"
+	"io"
+	"strings"
+)
+
+const (
+	// signatureSize is the number of bytes of the HMAC, which are used as signature for an id.
+	signatureSize = 16
+)
+
+var (
+	// ErrInvalidScanRequestID is returned when a scan request id could not be decoded or when the signature of the id
+	// doesn't match any of the configured secrets.
+	ErrInvalidScanRequestID = fmt.Errorf("invalid scan request id")
+)
+
+// idKey contains the keys which are derived from a single configured secret. The signing key is used to create the
+// HMAC of an id and the aead is used to encrypt the payload of an id, when encryption is enabled.
+type idKey struct {
+	sign []byte
+	aead cipher.AEAD
+}
+
+// idCodec is used to create and verify the scan request ids, which are returned to Harbor. An id has the format
+// "<payload>.<signature>", where both parts are encoded via the URL safe base64 encoding without padding, so that the
+// id can be used as path segment without escaping. The signature is the truncated HMAC-SHA256 of the payload.
+// The first key is always used to create new ids, while all keys are accepted to verify an id. This allows us to rotate
+// the secret without invalidating the ids of running scans.
+type idCodec struct {
+	keys    []idKey
+	encrypt bool
+}
+
+// encode signs the given data and returns the id for it. If encryption is enabled the data is encrypted before it is
+// signed.
+func (c *idCodec) encode(data []byte) (string, error) {
+	key := c.keys[0]
+
+	if c.encrypt {
+		nonce := make([]byte, key.aead.NonceSize())
+		if _, err := io.ReadFull(rand.Reader, nonce); err != nil {
+			return "", err
+		}
+
+		data = key.aead.Seal(nonce, nonce, data, nil)
+	}
+
+	payload := base64.RawURLEncoding.EncodeToString(data)
+	return payload + "." + base64.RawURLEncoding.EncodeToString(sign(key.sign, payload)[:signatureSize]), nil
+}
+
+// decode verifies the signature of the given id and returns the contained data. If encryption is enabled the data is
+// decrypted with the key, which was used to create the valid signature.
+func (c *idCodec) decode(id string) ([]byte, error) {
+	parts := strings.Split(id, ".")
+	if len(parts) != 2 {
+		return nil, ErrInvalidScanRequestID
+	}
+
+	signature, err := base64.RawURLEncoding.DecodeString(parts[1])
+	if err != nil {
+		return nil, ErrInvalidScanRequestID
+	}
+
+	for _, key := range c.keys {
+		if !hmac.Equal(signature, sign(key.sign, parts[0])[:signatureSize]) {
+			continue
+		}
+
+		data, err := base64.RawURLEncoding.DecodeString(parts[0])
+		if err != nil {
+			return nil, ErrInvalidScanRequestID
+		}
+
+		if !c.encrypt {
+			return data, nil
+		}
+
+		if len(data) < key.aead.NonceSize() {
+			return nil, ErrInvalidScanRequestID
+		}
+
+		data, err = key.aead.Open(nil, data[:key.aead.NonceSize()], data[key.aead.NonceSize():], nil)
+		if err != nil {
+			return nil, ErrInvalidScanRequestID
+		}
+
+		return data, nil
+	}
+
+	return nil, ErrInvalidScanRequestID
+}
+
+// sign returns the HMAC-SHA256 of the given payload.
+func sign(key []byte, payload string) []byte {
+	mac := hmac.New(sha256.New, key)
+	mac.Write([]byte(payload))
+	return mac.Sum(nil)
+}
+
+// newIDCodec returns a new codec for the scan request ids. The signing and encryption keys are derived from the given
+// secrets, so that a secret is never used for two different purposes. If no secret is provided, we generate a random
+// one. In this case the ids can not be verified anymore after a restart of the scanner.
+func newIDCodec(secrets []string, encrypt bool) (*idCodec, error) {
+	if len(secrets) == 0 {
+		secret := make([]byte, 32)
+		if _, err := io.ReadFull(rand.Reader, secret); err != nil {
+			return nil, err
+		}
+
+		secrets = []string{string(secret)}
+	}
+
+	var keys []idKey
+
+	for _, secret := range secrets {
+		if secret == "" {
+			return nil, fmt.Errorf("secret for scan request ids can not be empty")
+		}
+
+		encryptionKey := sha256.Sum256(sign([]byte(secret), "encrypt"))
+
+		block, err := aes.NewCipher(encryptionKey[:])
+		if err != nil {
+			return nil, err
+		}
+
+		aead, err := cipher.NewGCM(block)
+		if err != nil {
+			return nil, err
+		}
+
+		keys = append(keys, idKey{
+			sign: sign([]byte(secret), "sign"),
+			aead: aead,
+		})
+	}
+
+	return &idCodec{
+		keys:    keys,
+		encrypt: encrypt,
+	}, nil
+}
@@ -0,0 +1,139 @@
+package scanner
+
+import (
+	"context"
+	"fmt"
+	"time"
+
+	"github.com/ricoberger/harbor-snyk-scanner/pkg/harbor"
+	"github.com/ricoberger/harbor-snyk-scanner/pkg/log"
+	"github.com/ricoberger/harbor-snyk-scanner/pkg/snyk"
+
+	"go.uber.org/zap"
+)
+
+// createScanJob creates a new pending scan job for the artifact of the given scan request and saves it in the store.
+// The location of the import job is empty, when existing projects are reused. In this case the ids of these projects
+// must be passed to the function. The tags and attributes are only rendered for imported projects, because reused
+// projects were not created by us.
+func (s *Server) createScanJob(ctx context.Context, request harbor.ScanRequest, image, route, location string, projectIDs []string) (*ScanJob, error) {
+	id, err := newScanJobID()
+	if err != nil {
+		return nil, err
+	}
+
+	now := time.Now()
+
+	job := &ScanJob{
+		ID:         id,
+		Artifact:   request.Artifact,
+		Image:      image,
+		Route:      route,
+		Location:   location,
+		ProjectIDs: projectIDs,
+		Status:     ScanJobStatusPending,
+		CreatedAt:  now,
+		UpdatedAt:  now,
+	}
+
+	injectTraceContext(ctx, job)
+
+	if location != "" {
+		if s.metadata != nil {
+			job.Tags, job.Attributes, err = s.metadata.render(request)
+			if err != nil {
+				log.Warn(ctx, "Could not render tags and attributes for the Snyk projects", zap.Error(err), zap.String("image", image))
+			}
+		}
+
+		job.Tags = append([]snyk.Tag{managedProjectTag}, job.Tags...)
+	}
+
+	if err := s.store.Create(ctx, job); err != nil {
+		return nil, err
+	}
+
+	s.recordScanStarted(job)
+	return job, nil
+}
+
+// findPendingScanJob returns a pending scan job for the given artifact, image and route. If there is no pending scan
+// job, nil is returned. The digest of the artifact must be set, so that we do not return a job for a tag which was
+// pushed again.
+func (s *Server) findPendingScanJob(ctx context.Context, artifact harbor.Artifact, image, route string) (*ScanJob, error) {
+	if artifact.Digest == "" {
+		return nil, nil
+	}
+
+	jobs, err := s.store.List(ctx)
+	if err != nil {
+		return nil, err
+	}
+
+	for _, job := range jobs {
+		if job.Status == ScanJobStatusPending && job.Image == image && job.Route == route && job.Artifact.Digest == artifact.Digest {
+			return job, nil
+		}
+	}
+
+	return nil, nil
+}
+
+// isExpiredScanJob returns true, when the given scan job is older than the configured deadline.
+func isExpiredScanJob(job *ScanJob) bool {
+	return time.Since(job.CreatedAt) > deadline
+}
+
+// expiredScanJobMessage returns the error message for a scan job, which is older than the configured deadline.
+func expiredScanJobMessage() string {
+	return fmt.Sprintf("Scan job is older than %s, do not retry anymore", deadline)
+}
+
+// failExpiredScanJob marks the given scan job as failed, when it is older than the configured deadline. It returns true
+// when the job was marked as failed. The function must only be called by the workers, because only the workers are
+// allowed to change the status of a job. Otherwise a worker could overwrite the status of the job, which was already
+// reported to Harbor.
+func (s *Server) failExpiredScanJob(ctx context.Context, job *ScanJob) bool {
+	if !isExpiredScanJob(job) {
+		return false
+	}
+
+	log.Error(ctx, "Scan job is older than the deadline, do not retry anymore", zap.Time("now", time.Now()), zap.Time("scanRequestTime", job.CreatedAt), zap.Duration("deadline", deadline))
+	s.updateScanJob(ctx, job, ScanJobStatusFailed, expiredScanJobMessage())
+	return true
+}
+
+// updateScanJob sets the status and error of the given scan job and saves it in the store. Errors are only logged,
+// because the job is also updated within the next request.
+func (s *Server) updateScanJob(ctx context.Context, job *ScanJob, status ScanJobStatus, message string) {
+	finished := job.Status == ScanJobStatusPending && status != ScanJobStatusPending
+
+	job.Status = status
+	job.Error = message
+	job.UpdatedAt = time.Now()
+
+	// When the scan gave up, Harbor will never get a report for the job, so that we can remove the projects from Snyk.
+	if status == ScanJobStatusFailed {
+		scheduleCleanup(job)
+	}
+
+	if err := s.store.Update(ctx, job); err != nil {
+		log.Error(ctx, "Could not update scan job", zap.Error(err), zap.String("scanJobID", job.ID))
+	}
+
+	if finished {
+		s.recordScanFinished(job)
+	}
+}
+
+// scheduleCleanup sets the time after which the projects of the given scan job are removed from Snyk, when the
+// ephemeral mode is enabled. We only remove projects, which were created by the import job of the scan job. Reused
+// projects are never removed, because they were not created by us.
+func scheduleCleanup(job *ScanJob) bool {
+	if ephemeral == "" || job.Location == "" || !job.CleanupAt.IsZero() {
+		return false
+	}
+
+	job.CleanupAt = time.Now().Add(ephemeralGracePeriod)
+	return true
+}
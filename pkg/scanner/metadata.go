@@ -0,0 +1,225 @@
+package scanner
+
+import (
+	"bytes"
+	"context"
+	"fmt"
+	"regexp"
+	"sort"
+	"strings"
+	"text/template"
+
+	"github.com/ricoberger/harbor-snyk-scanner/pkg/harbor"
+	"github.com/ricoberger/harbor-snyk-scanner/pkg/log"
+	"github.com/ricoberger/harbor-snyk-scanner/pkg/snyk"
+
+	"go.uber.org/zap"
+)
+
+var (
+	tagKeyRegex   = regexp.MustCompile(`^[a-zA-Z0-9_-]{1,30}$`)
+	tagValueRegex = regexp.MustCompile(`^[a-zA-Z0-9_\-/:?#@&+=%~.]{1,256}$`)
+
+	// managedProjectTag is added to all projects, which were imported by the scanner. The garbage collector only deletes
+	// projects with this tag, so that projects which were created by someone else are never deleted.
+	managedProjectTag = snyk.Tag{Key: "managed-by", Value: "harbor-snyk-scanner"}
+
+	// metadataTemplateFuncs are the functions, which can be used in the templates for the tags and attributes, e.g.
+	// `{{ index (split .Artifact.Repository "/") 0 }}` returns the Harbor project of the artifact.
+	metadataTemplateFuncs = template.FuncMap{
+		"split":   strings.Split,
+		"join":    strings.Join,
+		"lower":   strings.ToLower,
+		"upper":   strings.ToUpper,
+		"replace": strings.ReplaceAll,
+	}
+)
+
+// metadataTemplate is a parsed template for a single tag or attribute.
+type metadataTemplate struct {
+	key      string
+	template *template.Template
+}
+
+// metadataTemplates contains the parsed templates for the tags and attributes, which are set for the projects of an
+// import job. The templates are sorted by their key, so that the tags are always added in the same order.
+type metadataTemplates struct {
+	tags       []metadataTemplate
+	attributes []metadataTemplate
+}
+
+// parseMetadataTemplates parses the templates for the tags and attributes of the imported projects. The key of the map
+// is the key of the tag or the name of the attribute and the value is the template. The templates are executed with an
+// example scan request, so that we also detect errors which are only returned during the execution.
+func parseMetadataTemplates(tags, attributes map[string]string) (*metadataTemplates, error) {
+	templates := &metadataTemplates{}
+
+	for _, key := range sortedKeys(tags) {
+		if !tagKeyRegex.MatchString(key) {
+			return nil, fmt.Errorf("invalid tag key %q, must only contain alphanumeric characters, \"-\" and \"_\" and must not be longer than 30 characters", key)
+		}
+
+		if key == managedProjectTag.Key {
+			return nil, fmt.Errorf("invalid tag key %q, the key is reserved for the scanner", key)
+		}
+
+		tmpl, err := template.New(key).Funcs(metadataTemplateFuncs).Option("missingkey=error").Parse(tags[key])
+		if err != nil {
+			return nil, fmt.Errorf("tag %s: %w", key, err)
+		}
+
+		templates.tags = append(templates.tags, metadataTemplate{key: key, template: tmpl})
+	}
+
+	for _, key := range sortedKeys(attributes) {
+		if key != "criticality" && key != "environment" && key != "lifecycle" {
+			return nil, fmt.Errorf("invalid attribute %q, must be \"criticality\", \"environment\" or \"lifecycle\"", key)
+		}
+
+		tmpl, err := template.New(key).Funcs(metadataTemplateFuncs).Option("missingkey=error").Parse(attributes[key])
+		if err != nil {
+			return nil, fmt.Errorf("attribute %s: %w", key, err)
+		}
+
+		templates.attributes = append(templates.attributes, metadataTemplate{key: key, template: tmpl})
+	}
+
+	if _, _, err := templates.render(harbor.ScanRequest{Registry: harbor.Registry{URL: "https://harbor.example.com"}, Artifact: harbor.Artifact{Repository: "library/nginx", Tag: "latest", Digest: "sha256:0000000000000000000000000000000000000000000000000000000000000000"}}); err != nil {
+		return nil, err
+	}
+
+	return templates, nil
+}
+
+// render returns the tags and attributes for the given scan request. Tags with an empty value are skipped, so that a
+// template can decide to not set a tag. The value of an attribute can contain multiple values separated by whitespace.
+// The authorization for the registry is removed from the scan request, so that it can not be leaked into Snyk.
+func (t *metadataTemplates) render(request harbor.ScanRequest) ([]snyk.Tag, *snyk.ProjectAttributes, error) {
+	request.Registry.Authorization = ""
+
+	var tags []snyk.Tag
+
+	for _, tag := range t.tags {
+		value, err := executeMetadataTemplate(tag.template, request)
+		if err != nil {
+			return nil, nil, fmt.Errorf("tag %s: %w", tag.key, err)
+		}
+
+		if value == "" {
+			continue
+		}
+
+		if !tagValueRegex.MatchString(value) {
+			return nil, nil, fmt.Errorf("tag %s: invalid value %q", tag.key, value)
+		}
+
+		tags = append(tags, snyk.Tag{Key: tag.key, Value: value})
+	}
+
+	if len(t.attributes) == 0 {
+		return tags, nil, nil
+	}
+
+	attributes := &snyk.ProjectAttributes{}
+
+	for _, attribute := range t.attributes {
+		value, err := executeMetadataTemplate(attribute.template, request)
+		if err != nil {
+			return nil, nil, fmt.Errorf("attribute %s: %w", attribute.key, err)
+		}
+
+		switch attribute.key {
+		case "criticality":
+			attributes.Criticality = strings.Fields(value)
+		case "environment":
+			attributes.Environment = strings.Fields(value)
+		case "lifecycle":
+			attributes.Lifecycle = strings.Fields(value)
+		}
+	}
+
+	if err := snyk.ValidateProjectAttributes(*attributes); err != nil {
+		return nil, nil, err
+	}
+
+	return tags, attributes, nil
+}
+
+func executeMetadataTemplate(tmpl *template.Template, request harbor.ScanRequest) (string, error) {
+	var value bytes.Buffer
+	if err := tmpl.Execute(&value, request); err != nil {
+		return "", err
+	}
+
+	return strings.TrimSpace(value.String()), nil
+}
+
+// tagProjects adds the tags and sets the attributes of the given scan job for all projects of the job. Snyk refreshes
+// the existing projects, when an image is imported again, so that the projects can already contain tags from a previous
+// scan. Tags which are already present are skipped and tags with the same key but another value are removed, so that
+// the projects do not contain stale values, e.g. the digest of a previous scan. Errors are only logged, because the
+// metadata is not required for the scan report and the job should not fail because of it.
+func tagProjects(ctx context.Context, snykClient snyk.Client, job *ScanJob) {
+	for _, projectID := range job.ProjectIDs {
+		if len(job.Tags) > 0 {
+			tagProject(ctx, snykClient, projectID, job.Tags)
+		}
+
+		if job.Attributes != nil {
+			if err := snykClient.SetProjectAttributes(ctx, projectID, *job.Attributes); err != nil {
+				log.Warn(ctx, "Could not set attributes of Snyk project", zap.Error(err), zap.String("projectID", projectID))
+			}
+		}
+	}
+}
+
+// tagProject replaces the tags of the project with the given id with the given tags, where only the tags with the same
+// keys are replaced. When the current tags of the project could not be fetched, the tags are not changed.
+func tagProject(ctx context.Context, snykClient snyk.Client, projectID string, tags []snyk.Tag) {
+	existingTags, err := snykClient.GetProjectTags(ctx, projectID)
+	if err != nil {
+		log.Warn(ctx, "Could not get tags of Snyk project", zap.Error(err), zap.String("projectID", projectID))
+		return
+	}
+
+	for _, tag := range tags {
+		if hasTag(existingTags, tag) {
+			continue
+		}
+
+		for _, existingTag := range existingTags {
+			if existingTag.Key != tag.Key {
+				continue
+			}
+
+			if err := snykClient.RemoveProjectTag(ctx, projectID, existingTag); err != nil {
+				log.Warn(ctx, "Could not remove tag from Snyk project", zap.Error(err), zap.String("projectID", projectID), zap.String("key", existingTag.Key), zap.String("value", existingTag.Value))
+			}
+		}
+
+		if err := snykClient.AddProjectTag(ctx, projectID, tag); err != nil {
+			log.Warn(ctx, "Could not add tag to Snyk project", zap.Error(err), zap.String("projectID", projectID), zap.String("key", tag.Key), zap.String("value", tag.Value))
+		}
+	}
+}
+
+// hasTag returns true, when the given tags contain the tag.
+func hasTag(tags []snyk.Tag, tag snyk.Tag) bool {
+	for _, t := range tags {
+		if t == tag {
+			return true
+		}
+	}
+
+	return false
+}
+
+func sortedKeys(m map[string]string) []string {
+	keys := make([]string, 0, len(m))
+	for key := range m {
+		keys = append(keys, key)
+	}
+	sort.Strings(keys)
+
+	return keys
+}
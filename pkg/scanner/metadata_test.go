@@ -0,0 +1,82 @@
+package scanner
+
+import (
+	"context"
+	"testing"
+
+	"github.com/ricoberger/harbor-snyk-scanner/pkg/harbor"
+	"github.com/ricoberger/harbor-snyk-scanner/pkg/snyk"
+
+	"github.com/stretchr/testify/require"
+)
+
+func TestParseMetadataTemplates(t *testing.T) {
+	for _, tt := range []struct {
+		name       string
+		tags       map[string]string
+		attributes map[string]string
+		err        string
+	}{
+		{name: "valid", tags: map[string]string{"harbor-project": `{{ index (split .Artifact.Repository "/") 0 }}`}, attributes: map[string]string{"environment": "backend internal"}},
+		{name: "invalid tag key", tags: map[string]string{"harbor project": "{{ .Artifact.Repository }}"}, err: "invalid tag key \"harbor project\", must only contain alphanumeric characters, \"-\" and \"_\" and must not be longer than 30 characters"},
+		{name: "reserved tag key", tags: map[string]string{"managed-by": "{{ .Artifact.Repository }}"}, err: "invalid tag key \"managed-by\", the key is reserved for the scanner"},
+		{name: "invalid attribute", attributes: map[string]string{"owner": "security"}, err: "invalid attribute \"owner\", must be \"criticality\", \"environment\" or \"lifecycle\""},
+		{name: "invalid template", tags: map[string]string{"repository": "{{ .Artifact.Repository"}, err: "tag repository: template: repository:1: unclosed action"},
+		{name: "unknown field", tags: map[string]string{"repository": "{{ .Artifact.Name }}"}, err: "tag repository: template: repository:1:12: executing \"repository\" at <.Artifact.Name>: can't evaluate field Name in type harbor.Artifact"},
+		{name: "invalid attribute value", attributes: map[string]string{"lifecycle": "staging"}, err: "invalid value \"staging\" for lifecycle attribute, must be one of production, development, sandbox"},
+	} {
+		t.Run(tt.name, func(t *testing.T) {
+			_, err := parseMetadataTemplates(tt.tags, tt.attributes)
+			if tt.err == "" {
+				require.NoError(t, err)
+			} else {
+				require.EqualError(t, err, tt.err)
+			}
+		})
+	}
+}
+
+func TestRenderMetadata(t *testing.T) {
+	templates, err := parseMetadataTemplates(map[string]string{
+		"harbor-project": `{{ index (split .Artifact.Repository "/") 0 }}`,
+		"repository":     "{{ .Artifact.Repository }}",
+		"digest":         "{{ .Artifact.Digest }}",
+		"authorization":  "{{ .Registry.Authorization }}",
+		"environment":    `{{ if eq (index (split .Artifact.Repository "/") 0) "prod" }}production{{ else }}development{{ end }}`,
+	}, map[string]string{
+		"environment": "backend internal",
+		"lifecycle":   `{{ if eq (index (split .Artifact.Repository "/") 0) "prod" }}production{{ else }}development{{ end }}`,
+	})
+	require.NoError(t, err)
+
+	tags, attributes, err := templates.render(harbor.ScanRequest{
+		Registry: harbor.Registry{URL: "https://harbor.example.com", Authorization: "Basic c2VjcmV0"},
+		Artifact: harbor.Artifact{Repository: "prod/apps/api", Tag: "1.0.0"},
+	})
+	require.NoError(t, err)
+	require.Equal(t, []snyk.Tag{
+		{Key: "environment", Value: "production"},
+		{Key: "harbor-project", Value: "prod"},
+		{Key: "repository", Value: "prod/apps/api"},
+	}, tags)
+	require.Equal(t, &snyk.ProjectAttributes{Environment: []string{"backend", "internal"}, Lifecycle: []string{"production"}}, attributes)
+
+	_, _, err = templates.render(harbor.ScanRequest{Artifact: harbor.Artifact{Repository: "prod/apps/api", Digest: "sha256:1234 5678"}})
+	require.EqualError(t, err, "tag digest: invalid value \"sha256:1234 5678\"")
+}
+
+func TestTagProjects(t *testing.T) {
+	client := &fakeClient{tags: map[string][]snyk.Tag{
+		"project1": {managedProjectTag, {Key: "digest", Value: "sha256:1234"}, {Key: "team", Value: "platform"}},
+	}}
+
+	// The first project was refreshed by the import, so that the digest of the previous scan must be replaced and the
+	// existing tags must not be added again.
+	tagProjects(context.Background(), client, &ScanJob{
+		ProjectIDs: []string{"project1", "project2"},
+		Tags:       []snyk.Tag{managedProjectTag, {Key: "digest", Value: "sha256:5678"}},
+	})
+
+	require.Equal(t, []snyk.Tag{managedProjectTag, {Key: "team", Value: "platform"}, {Key: "digest", Value: "sha256:5678"}}, client.tags["project1"])
+	require.Equal(t, []snyk.Tag{managedProjectTag, {Key: "digest", Value: "sha256:5678"}}, client.tags["project2"])
+}
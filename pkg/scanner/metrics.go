@@ -0,0 +1,108 @@
+package scanner
+
+import (
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/prometheus/client_golang/prometheus/promauto"
+)
+
+var (
+	scanDurationMetric = promauto.NewHistogramVec(prometheus.HistogramOpts{
+		Namespace: "harbor_snyk_scanner",
+		Name:      "scan_duration_seconds",
+		Help:      "Time from the scan request of Harbor until the report was delivered to Harbor for the first time, partitioned by Harbor project.",
+		Buckets:   []float64{1, 5, 10, 30, 60, 120, 300, 600, 1200, 1800, 3600},
+	}, []string{"project"})
+
+	scansMetric = promauto.NewCounterVec(prometheus.CounterOpts{
+		Namespace: "harbor_snyk_scanner",
+		Name:      "scans_total",
+		Help:      "Number of finished scans, partitioned by outcome (completed, failed, timed_out) and Harbor project.",
+	}, []string{"outcome", "project"})
+
+	scansInFlightMetric = promauto.NewGaugeVec(prometheus.GaugeOpts{
+		Namespace: "harbor_snyk_scanner",
+		Name:      "scans_in_flight",
+		Help:      "Number of pending scans, partitioned by Harbor project.",
+	}, []string{"project"})
+
+	vulnerabilitiesMetric = promauto.NewCounterVec(prometheus.CounterOpts{
+		Namespace: "harbor_snyk_scanner",
+		Name:      "vulnerabilities_reported_total",
+		Help:      "Number of vulnerabilities in the created scan reports, partitioned by severity and Harbor project.",
+	}, []string{"severity", "project"})
+)
+
+// projectLabels returns the value for the project label of the scan metrics. When the label is disabled, the value is
+// always empty. Otherwise the value is the Harbor project of the artifact. To guard against a high cardinality, only
+// the first max projects get their own label value, all other projects are reported as "other".
+type projectLabels struct {
+	mu      sync.Mutex
+	enabled bool
+	max     int
+	values  map[string]bool
+}
+
+func newProjectLabels(enabled bool, max int) *projectLabels {
+	return &projectLabels{
+		enabled: enabled,
+		max:     max,
+		values:  make(map[string]bool),
+	}
+}
+
+func (l *projectLabels) get(repository string) string {
+	if l == nil || !l.enabled {
+		return ""
+	}
+
+	project := strings.SplitN(repository, "/", 2)[0]
+
+	l.mu.Lock()
+	defer l.mu.Unlock()
+
+	if l.values[project] {
+		return project
+	}
+
+	if len(l.values) >= l.max {
+		return "other"
+	}
+
+	l.values[project] = true
+	return project
+}
+
+// recordScanStarted increases the number of in flight scans for the given pending scan job.
+func (s *Server) recordScanStarted(job *ScanJob) {
+	scansInFlightMetric.WithLabelValues(s.projectLabels.get(job.Artifact.Repository)).Inc()
+}
+
+// recordScanFinished records the outcome of the given scan job, when it is not pending anymore. A failed job is
+// reported as timed out, when it failed because it was older than the deadline. For completed jobs we also count the
+// vulnerabilities in the report.
+func (s *Server) recordScanFinished(job *ScanJob) {
+	project := s.projectLabels.get(job.Artifact.Repository)
+	scansInFlightMetric.WithLabelValues(project).Dec()
+
+	outcome := string(job.Status)
+	if job.Status == ScanJobStatusFailed && time.Since(job.CreatedAt) > deadline {
+		outcome = "timed_out"
+	}
+	scansMetric.WithLabelValues(outcome, project).Inc()
+
+	if job.Status == ScanJobStatusCompleted && job.Report != nil {
+		for _, vulnerability := range job.Report.Vulnerabilities {
+			vulnerabilitiesMetric.WithLabelValues(vulnerability.Severity, project).Inc()
+		}
+	}
+}
+
+// recordScanReported records the duration from the scan request until the report was delivered. It must only be
+// called by the request, which delivered the report of the given scan job for the first time.
+func (s *Server) recordScanReported(job *ScanJob) {
+	scanDurationMetric.WithLabelValues(s.projectLabels.get(job.Artifact.Repository)).Observe(job.ReportedAt.Sub(job.CreatedAt).Seconds())
+}
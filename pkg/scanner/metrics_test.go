@@ -0,0 +1,120 @@
+package scanner
+
+import (
+	"encoding/json"
+	"net/http"
+	"testing"
+	"time"
+
+	"github.com/ricoberger/harbor-snyk-scanner/pkg/harbor"
+	"github.com/ricoberger/harbor-snyk-scanner/pkg/snyk"
+
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/prometheus/client_golang/prometheus/testutil"
+	dto "github.com/prometheus/client_model/go"
+	"github.com/stretchr/testify/require"
+)
+
+func getScanDurationCount(t *testing.T, project string) uint64 {
+	var metric dto.Metric
+	require.NoError(t, scanDurationMetric.WithLabelValues(project).(prometheus.Metric).Write(&metric))
+	return metric.GetHistogram().GetSampleCount()
+}
+
+func TestProjectLabels(t *testing.T) {
+	require.Equal(t, "", newProjectLabels(false, 2).get("library/nginx"))
+
+	labels := newProjectLabels(true, 2)
+	require.Equal(t, "library", labels.get("library/nginx"))
+	require.Equal(t, "team", labels.get("team/apps/api"))
+	require.Equal(t, "other", labels.get("another/nginx"))
+	require.Equal(t, "library", labels.get("library/redis"))
+}
+
+func TestScanMetrics(t *testing.T) {
+	defer func(previous bool) { metricsProjectLabel = previous }(metricsProjectLabel)
+	metricsProjectLabel = true
+
+	issue := snyk.Issue{ID: "SNYK-1", PkgName: "openssl"}
+	issue.IssueData.Severity = "high"
+
+	t.Run("completed", func(t *testing.T) {
+		completed := testutil.ToFloat64(scansMetric.WithLabelValues("completed", "metrics-completed"))
+		vulnerabilities := testutil.ToFloat64(vulnerabilitiesMetric.WithLabelValues("High", "metrics-completed"))
+		reports := getScanDurationCount(t, "metrics-completed")
+
+		server := newTestServer(t, &fakeClient{pendingAttempts: 1, issues: []snyk.Issue{issue}})
+
+		w := scan(t, server, harbor.ScanRequest{Artifact: harbor.Artifact{Repository: "metrics-completed/nginx", Tag: "latest"}})
+		require.Equal(t, http.StatusAccepted, w.Code)
+		require.Equal(t, 1.0, testutil.ToFloat64(scansInFlightMetric.WithLabelValues("metrics-completed")))
+
+		var scanResponse harbor.ScanResponse
+		require.NoError(t, json.NewDecoder(w.Body).Decode(&scanResponse))
+
+		require.Eventually(t, func() bool {
+			return getReport(t, server, scanResponse.ID).Code == http.StatusOK
+		}, 5*time.Second, 10*time.Millisecond)
+		require.Equal(t, http.StatusOK, getReport(t, server, scanResponse.ID).Code)
+
+		require.Equal(t, 0.0, testutil.ToFloat64(scansInFlightMetric.WithLabelValues("metrics-completed")))
+		require.Equal(t, completed+1, testutil.ToFloat64(scansMetric.WithLabelValues("completed", "metrics-completed")))
+		require.Equal(t, vulnerabilities+1, testutil.ToFloat64(vulnerabilitiesMetric.WithLabelValues("High", "metrics-completed")))
+		require.Equal(t, reports+1, getScanDurationCount(t, "metrics-completed"))
+	})
+
+	t.Run("reported once", func(t *testing.T) {
+		reports := getScanDurationCount(t, "metrics-reported")
+
+		server := newTestServer(t, &fakeClient{})
+
+		w := scan(t, server, harbor.ScanRequest{Artifact: harbor.Artifact{Repository: "metrics-reported/nginx", Tag: "latest"}})
+		require.Equal(t, http.StatusAccepted, w.Code)
+
+		var scanResponse harbor.ScanResponse
+		require.NoError(t, json.NewDecoder(w.Body).Decode(&scanResponse))
+
+		require.Eventually(t, func() bool {
+			jobs, err := server.store.List(server.ctx)
+			return err == nil && len(jobs) == 1 && jobs[0].Status == ScanJobStatusCompleted
+		}, 5*time.Second, 10*time.Millisecond)
+
+		// Harbor can request the report concurrently, but the duration must only be recorded for the first delivery.
+		codes := make(chan int, 10)
+		for i := 0; i < cap(codes); i++ {
+			go func() {
+				codes <- getReport(t, server, scanResponse.ID).Code
+			}()
+		}
+		for i := 0; i < cap(codes); i++ {
+			require.Equal(t, http.StatusOK, <-codes)
+		}
+
+		require.Equal(t, reports+1, getScanDurationCount(t, "metrics-reported"))
+	})
+
+	t.Run("failed", func(t *testing.T) {
+		failed := testutil.ToFloat64(scansMetric.WithLabelValues("failed", "metrics-failed"))
+		server := newTestServer(t, &fakeClient{projectIDsErr: &snyk.ImportFailedError{Status: "failed", Messages: []string{"Image not found"}}})
+
+		w := scan(t, server, harbor.ScanRequest{Artifact: harbor.Artifact{Repository: "metrics-failed/nginx", Tag: "latest"}})
+		require.Equal(t, http.StatusAccepted, w.Code)
+
+		require.Eventually(t, func() bool {
+			return testutil.ToFloat64(scansMetric.WithLabelValues("failed", "metrics-failed")) == failed+1
+		}, 5*time.Second, 10*time.Millisecond)
+		require.Equal(t, 0.0, testutil.ToFloat64(scansInFlightMetric.WithLabelValues("metrics-failed")))
+	})
+
+	t.Run("timed out", func(t *testing.T) {
+		timedOut := testutil.ToFloat64(scansMetric.WithLabelValues("timed_out", "metrics-timed-out"))
+		server := newTestServer(t, &fakeClient{})
+		job, err := server.createScanJob(server.ctx, harbor.ScanRequest{Artifact: harbor.Artifact{Repository: "metrics-timed-out/nginx", Tag: "latest"}}, "metrics-timed-out/nginx:latest", "", "location", nil)
+		require.NoError(t, err)
+
+		job.CreatedAt = time.Now().Add(-2 * deadline)
+		require.True(t, server.failExpiredScanJob(server.ctx, job))
+		require.Equal(t, timedOut+1, testutil.ToFloat64(scansMetric.WithLabelValues("timed_out", "metrics-timed-out")))
+		require.Equal(t, 0.0, testutil.ToFloat64(scansInFlightMetric.WithLabelValues("metrics-timed-out")))
+	})
+}
@@ -2,6 +2,8 @@ package metrics
 
 import (
 	"net/http"
+	"os"
+	"sort"
 	"strconv"
 	"strings"
 	"time"
@@ -10,39 +12,146 @@ import (
 	"github.com/go-chi/chi/v5/middleware"
 	"github.com/prometheus/client_golang/prometheus"
 	"github.com/prometheus/client_golang/prometheus/promauto"
+	flag "github.com/spf13/pflag"
 )
 
+// unmatchedRoute is the value of the request_path label for all requests, which didn't match a route. This ensures
+// that requests for random paths, e.g. from a scanner bot, do not create new label values.
+const unmatchedRoute = "unmatched"
+
 var (
+	buckets []float64
+
 	reqMetric = promauto.NewCounterVec(prometheus.CounterOpts{
 		Namespace: "harbor_snyk_scanner",
 		Name:      "chi_requests_total",
-		Help:      "Number of HTTP requests processed, partitioned by status code, method and path.",
+		Help:      "Number of HTTP requests processed, partitioned by status code, method and route pattern.",
 	}, []string{"response_code", "request_method", "request_path"})
 
-	sumMetric = promauto.NewSummaryVec(prometheus.SummaryOpts{
-		Namespace:  "harbor_snyk_scanner",
-		Name:       "chi_request_duration_milliseconds",
-		Help:       "Latency of HTTP requests processed, partitioned by status code, method and path.",
-		Objectives: map[float64]float64{0.5: 0.05, 0.9: 0.01, 0.95: 0.005, 0.99: 0.001},
-	}, []string{"response_code", "request_method", "request_path"})
+	inFlightMetric = promauto.NewGauge(prometheus.GaugeOpts{
+		Namespace: "harbor_snyk_scanner",
+		Name:      "chi_requests_in_flight",
+		Help:      "Number of HTTP requests, which are currently processed.",
+	})
+
 )
 
-// Metrics is a middleware that handles the Prometheus metrics for the scanner and chi.
-func Metrics(next http.Handler) http.Handler {
-	fn := func(w http.ResponseWriter, r *http.Request) {
-		start := time.Now()
-		wrw := middleware.NewWrapResponseWriter(w, r.ProtoMajor)
-		next.ServeHTTP(wrw, r)
-
-		scanRequestID := chi.URLParam(r, "scan_request_id")
-		path := r.URL.Path
-		if scanRequestID != "" {
-			path = strings.ReplaceAll(path, scanRequestID, "{scan_request_id}")
+// init is used to define the flags for the metrics middleware. Currently this is only the buckets of the histogram for
+// the request duration.
+func init() {
+	defaultBuckets := prometheus.DefBuckets
+	if os.Getenv("SCANNER_METRICS_BUCKETS") != "" {
+		var parsedBuckets []float64
+		for _, value := range strings.Split(os.Getenv("SCANNER_METRICS_BUCKETS"), ",") {
+			bucket, err := strconv.ParseFloat(value, 64)
+			if err != nil {
+				parsedBuckets = nil
+				break
+			}
+			parsedBuckets = append(parsedBuckets, bucket)
 		}
 
-		reqMetric.WithLabelValues(strconv.Itoa(wrw.Status()), r.Method, path).Inc()
-		sumMetric.WithLabelValues(strconv.Itoa(wrw.Status()), r.Method, path).Observe(float64(time.Since(start).Nanoseconds()) / 1000000)
+		if len(parsedBuckets) > 0 {
+			defaultBuckets = parsedBuckets
+		}
 	}
 
-	return http.HandlerFunc(fn)
+	flag.Float64SliceVar(&buckets, "scanner.metrics-buckets", defaultBuckets, "The buckets in seconds of the histogram for the duration of the HTTP requests.")
+}
+
+// getBuckets returns the sorted buckets without duplicates, because the histogram requires strictly increasing buckets.
+// When no bucket is configured, the default buckets of Prometheus are used.
+func getBuckets(values []float64) []float64 {
+	sorted := append([]float64{}, values...)
+	sort.Float64s(sorted)
+
+	var buckets []float64
+	for i, bucket := range sorted {
+		if i == 0 || bucket != sorted[i-1] {
+			buckets = append(buckets, bucket)
+		}
+	}
+
+	if len(buckets) == 0 {
+		return prometheus.DefBuckets
+	}
+
+	return buckets
+}
+
+// newDurationMetric returns the histogram for the duration of the requests. The histogram is created when the middleware
+// is created, because the buckets are configured via a flag, which is not parsed when the package is initialized. When
+// the histogram was already registered by another middleware, the registered histogram is returned.
+func newDurationMetric() *prometheus.HistogramVec {
+	durationMetric := prometheus.NewHistogramVec(prometheus.HistogramOpts{
+		Namespace: "harbor_snyk_scanner",
+		Name:      "chi_request_duration_seconds",
+		Help:      "Latency of HTTP requests processed, partitioned by status code, method and route pattern.",
+		Buckets:   getBuckets(buckets),
+	}, []string{"response_code", "request_method", "request_path"})
+
+	if err := prometheus.Register(durationMetric); err != nil {
+		if alreadyRegisteredErr, ok := err.(prometheus.AlreadyRegisteredError); ok {
+			return alreadyRegisteredErr.ExistingCollector.(*prometheus.HistogramVec)
+		}
+
+		panic(err)
+	}
+
+	return durationMetric
+}
+
+// getRoutePattern returns the route pattern, which was matched by chi for the given request. When no route was
+// matched, the unmatchedRoute value is returned. Mounted routers end with a "/*" pattern, when no route within the
+// router was matched.
+func getRoutePattern(r *http.Request) string {
+	rctx := chi.RouteContext(r.Context())
+	if rctx == nil {
+		return unmatchedRoute
+	}
+
+	pattern := rctx.RoutePattern()
+	if pattern == "" || strings.HasSuffix(pattern, "/*") {
+		return unmatchedRoute
+	}
+
+	return pattern
+}
+
+// getMethod returns the method of the given request. Unknown methods are returned as "other", so that the number of
+// label values is bounded.
+func getMethod(r *http.Request) string {
+	switch r.Method {
+	case http.MethodGet, http.MethodHead, http.MethodPost, http.MethodPut, http.MethodPatch, http.MethodDelete, http.MethodConnect, http.MethodOptions, http.MethodTrace:
+		return r.Method
+	default:
+		return "other"
+	}
+}
+
+// New returns a middleware that handles the Prometheus metrics for the scanner and chi. The requests are labeled with
+// the route pattern instead of the path and unknown methods are collapsed, so that the number of label values is
+// bounded. The middleware must be created after the flags were parsed.
+func New() func(next http.Handler) http.Handler {
+	durationMetric := newDurationMetric()
+
+	return func(next http.Handler) http.Handler {
+		fn := func(w http.ResponseWriter, r *http.Request) {
+			inFlightMetric.Inc()
+			defer inFlightMetric.Dec()
+
+			start := time.Now()
+			wrw := middleware.NewWrapResponseWriter(w, r.ProtoMajor)
+			next.ServeHTTP(wrw, r)
+
+			status := strconv.Itoa(wrw.Status())
+			method := getMethod(r)
+			path := getRoutePattern(r)
+
+			reqMetric.WithLabelValues(status, method, path).Inc()
+			durationMetric.WithLabelValues(status, method, path).Observe(time.Since(start).Seconds())
+		}
+
+		return http.HandlerFunc(fn)
+	}
 }
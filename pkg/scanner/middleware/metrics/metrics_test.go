@@ -0,0 +1,60 @@
+package metrics
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	"github.com/go-chi/chi/v5"
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/prometheus/client_golang/prometheus/testutil"
+	"github.com/stretchr/testify/require"
+)
+
+func TestMetrics(t *testing.T) {
+	router := chi.NewRouter()
+	router.Route("/api", func(r chi.Router) {
+		r.Use(New())
+		r.Get("/scan/{scan_request_id}/report", func(w http.ResponseWriter, r *http.Request) {
+			require.Equal(t, 1.0, testutil.ToFloat64(inFlightMetric))
+			w.WriteHeader(http.StatusFound)
+		})
+	})
+
+	for _, tt := range []struct {
+		method string
+		path   string
+		status string
+		label  string
+	}{
+		{method: http.MethodGet, path: "/api/scan/abc/report", status: "302", label: "/api/scan/{scan_request_id}/report"},
+		{method: http.MethodGet, path: "/api/scan/def/report", status: "302", label: "/api/scan/{scan_request_id}/report"},
+		{method: http.MethodGet, path: "/api/wp-login.php", status: "404", label: unmatchedRoute},
+		{method: http.MethodGet, path: "/api/scan/abc/report/../../../etc/passwd", status: "404", label: unmatchedRoute},
+	} {
+		before := testutil.ToFloat64(reqMetric.WithLabelValues(tt.status, tt.method, tt.label))
+
+		w := httptest.NewRecorder()
+		router.ServeHTTP(w, httptest.NewRequest(tt.method, tt.path, nil))
+
+		require.Equal(t, before+1, testutil.ToFloat64(reqMetric.WithLabelValues(tt.status, tt.method, tt.label)), tt.path)
+	}
+
+	require.Equal(t, 0.0, testutil.ToFloat64(inFlightMetric))
+}
+
+func TestNewDurationMetric(t *testing.T) {
+	// The middleware is created for each scanner, so that the histogram must only be registered once.
+	durationMetric := newDurationMetric()
+	require.Same(t, durationMetric, newDurationMetric())
+}
+
+func TestGetBuckets(t *testing.T) {
+	require.Equal(t, []float64{0.1, 0.5, 1}, getBuckets([]float64{1, 0.1, 0.5, 1}))
+	require.Equal(t, prometheus.DefBuckets, getBuckets(nil))
+}
+
+func TestGetMethod(t *testing.T) {
+	require.Equal(t, http.MethodPost, getMethod(httptest.NewRequest(http.MethodPost, "/api/scan", nil)))
+	require.Equal(t, "other", getMethod(httptest.NewRequest("FOO", "/api/scan", nil)))
+}
@@ -0,0 +1,67 @@
+package tracing
+
+import (
+	"net/http"
+	"strings"
+
+	"github.com/go-chi/chi/v5"
+	"github.com/go-chi/chi/v5/middleware"
+	"go.opentelemetry.io/otel"
+	"go.opentelemetry.io/otel/propagation"
+	semconv "go.opentelemetry.io/otel/semconv/v1.7.0"
+	"go.opentelemetry.io/otel/trace"
+)
+
+const instrumentationName = "github.com/ricoberger/harbor-snyk-scanner/pkg/scanner/middleware/tracing"
+
+// getRoutePattern returns the route pattern, which was matched by chi for the given request. When no route was
+// matched, an empty string is returned. Mounted routers end with a "/*" pattern, when no route within the router was
+// matched.
+func getRoutePattern(r *http.Request) string {
+	rctx := chi.RouteContext(r.Context())
+	if rctx == nil {
+		return ""
+	}
+
+	pattern := rctx.RoutePattern()
+	if strings.HasSuffix(pattern, "/*") {
+		return ""
+	}
+
+	return pattern
+}
+
+// Tracing is a middleware that starts a server span for each request. The trace context from the traceparent header of
+// the request is used as parent of the span. The span is named after the matched route pattern, so that the name does
+// not contain ids like the scan request id. The handlers can add their own attributes via trace.SpanFromContext.
+func Tracing(next http.Handler) http.Handler {
+	fn := func(w http.ResponseWriter, r *http.Request) {
+		ctx := otel.GetTextMapPropagator().Extract(r.Context(), propagation.HeaderCarrier(r.Header))
+
+		ctx, span := otel.Tracer(instrumentationName).Start(ctx, "HTTP "+r.Method,
+			trace.WithSpanKind(trace.SpanKindServer),
+			trace.WithAttributes(semconv.NetAttributesFromHTTPRequest("tcp", r)...),
+			trace.WithAttributes(semconv.HTTPServerAttributesFromHTTPRequest("", "", r)...),
+		)
+		defer span.End()
+
+		wrw := middleware.NewWrapResponseWriter(w, r.ProtoMajor)
+		next.ServeHTTP(wrw, r.WithContext(ctx))
+
+		if route := getRoutePattern(r); route != "" {
+			span.SetName(route)
+			span.SetAttributes(semconv.HTTPRouteKey.String(route))
+		}
+
+		// When the handler didn't write anything, the status code is not set, but the server responds with 200.
+		status := wrw.Status()
+		if status == 0 {
+			status = http.StatusOK
+		}
+
+		span.SetAttributes(semconv.HTTPAttributesFromHTTPStatusCode(status)...)
+		span.SetStatus(semconv.SpanStatusFromHTTPStatusCodeAndSpanKind(status, trace.SpanKindServer))
+	}
+
+	return http.HandlerFunc(fn)
+}
@@ -0,0 +1,75 @@
+package tracing
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	"github.com/go-chi/chi/v5"
+	"github.com/stretchr/testify/require"
+	"go.opentelemetry.io/otel"
+	"go.opentelemetry.io/otel/attribute"
+	"go.opentelemetry.io/otel/codes"
+	"go.opentelemetry.io/otel/propagation"
+	sdktrace "go.opentelemetry.io/otel/sdk/trace"
+	"go.opentelemetry.io/otel/sdk/trace/tracetest"
+	semconv "go.opentelemetry.io/otel/semconv/v1.7.0"
+	"go.opentelemetry.io/otel/trace"
+)
+
+func TestTracing(t *testing.T) {
+	exporter := tracetest.NewInMemoryExporter()
+
+	defer func(previousProvider trace.TracerProvider, previousPropagator propagation.TextMapPropagator) {
+		otel.SetTracerProvider(previousProvider)
+		otel.SetTextMapPropagator(previousPropagator)
+	}(otel.GetTracerProvider(), otel.GetTextMapPropagator())
+
+	otel.SetTracerProvider(sdktrace.NewTracerProvider(sdktrace.WithSyncer(exporter)))
+	otel.SetTextMapPropagator(propagation.TraceContext{})
+
+	router := chi.NewRouter()
+	router.Route("/api", func(r chi.Router) {
+		r.Use(Tracing)
+		r.Get("/scan/{scan_request_id}/report", func(w http.ResponseWriter, r *http.Request) {
+			require.True(t, trace.SpanFromContext(r.Context()).IsRecording())
+			w.WriteHeader(http.StatusFound)
+		})
+		r.Get("/error", func(w http.ResponseWriter, r *http.Request) {
+			w.WriteHeader(http.StatusInternalServerError)
+		})
+	})
+
+	for _, tt := range []struct {
+		path   string
+		name   string
+		status int
+		code   codes.Code
+	}{
+		{path: "/api/scan/abc/report", name: "/api/scan/{scan_request_id}/report", status: http.StatusFound, code: codes.Unset},
+		{path: "/api/wp-login.php", name: "HTTP GET", status: http.StatusNotFound, code: codes.Unset},
+		{path: "/api/error", name: "/api/error", status: http.StatusInternalServerError, code: codes.Error},
+	} {
+		t.Run(tt.path, func(t *testing.T) {
+			exporter.Reset()
+
+			req := httptest.NewRequest(http.MethodGet, tt.path, nil)
+			req.Header.Set("traceparent", "00-4bf92f3577b34da6a3ce929d0e0e4736-00f067aa0ba902b7-01")
+
+			w := httptest.NewRecorder()
+			router.ServeHTTP(w, req)
+			require.Equal(t, tt.status, w.Code)
+
+			spans := exporter.GetSpans()
+			require.Len(t, spans, 1)
+			require.Equal(t, tt.name, spans[0].Name)
+			require.Equal(t, trace.SpanKindServer, spans[0].SpanKind)
+			require.Equal(t, "4bf92f3577b34da6a3ce929d0e0e4736", spans[0].SpanContext.TraceID().String())
+			require.Equal(t, "00f067aa0ba902b7", spans[0].Parent.SpanID().String())
+			require.True(t, spans[0].Parent.IsRemote())
+			require.Equal(t, tt.code, spans[0].Status.Code)
+			require.Contains(t, spans[0].Attributes, semconv.HTTPStatusCodeKey.Int(tt.status))
+			require.Contains(t, spans[0].Attributes, attribute.String("http.target", tt.path))
+		})
+	}
+}
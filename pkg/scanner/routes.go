@@ -0,0 +1,146 @@
+package scanner
+
+import (
+	"fmt"
+	"io/ioutil"
+	"os"
+	"path"
+	"regexp"
+	"strings"
+
+	"gopkg.in/yaml.v3"
+)
+
+// Routes is the content of the routes file. It contains an ordered list of routes, which are used to select the Snyk
+// organisation and integration for a scan request. The first route which matches the request is used. When no route
+// matches, the organisation and integration from the Snyk flags are used.
+type Routes struct {
+	Routes []*Route `yaml:"routes"`
+}
+
+// Route selects the Snyk organisation, integration and API key for all scan requests, where the url of the registry and
+// the repository of the artifact are matching the configured matchers. The API key is optional, when it is not set the
+// API key from the Snyk flags is used. Environment variables in the API key are expanded, so that the key must not be
+// saved in the routes file.
+type Route struct {
+	Name           string  `yaml:"name"`
+	Registry       Matcher `yaml:"registry"`
+	Repository     Matcher `yaml:"repository"`
+	OrganisationID string  `yaml:"organisationID"`
+	IntegrationID  string  `yaml:"integrationID"`
+	APIKey         string  `yaml:"apiKey"`
+}
+
+// Matcher matches a value by a prefix, a glob pattern or a regular expression. Only one of them can be set. If none of
+// them is set, the matcher matches all values. The glob pattern uses the syntax of path.Match, so that "*" doesn't
+// match a "/" in the repository.
+type Matcher struct {
+	Prefix string `yaml:"prefix"`
+	Glob   string `yaml:"glob"`
+	Regex  string `yaml:"regex"`
+
+	regex *regexp.Regexp
+}
+
+func (m *Matcher) compile() error {
+	count := 0
+	for _, value := range []string{m.Prefix, m.Glob, m.Regex} {
+		if value != "" {
+			count = count + 1
+		}
+	}
+
+	if count > 1 {
+		return fmt.Errorf("only one of prefix, glob and regex can be set")
+	}
+
+	if m.Glob != "" {
+		if _, err := path.Match(m.Glob, ""); err != nil {
+			return fmt.Errorf("invalid glob %q: %w", m.Glob, err)
+		}
+	}
+
+	if m.Regex != "" {
+		regex, err := regexp.Compile(m.Regex)
+		if err != nil {
+			return fmt.Errorf("invalid regex %q: %w", m.Regex, err)
+		}
+
+		m.regex = regex
+	}
+
+	return nil
+}
+
+func (m *Matcher) match(value string) bool {
+	switch {
+	case m.Prefix != "":
+		return strings.HasPrefix(value, m.Prefix)
+	case m.Glob != "":
+		matched, _ := path.Match(m.Glob, value)
+		return matched
+	case m.regex != nil:
+		return m.regex.MatchString(value)
+	default:
+		return true
+	}
+}
+
+// loadRoutes loads the routes from the given file. If the path is empty, no routes are returned, so that the
+// organisation and integration from the Snyk flags are used for all scan requests.
+func loadRoutes(file string) ([]*Route, error) {
+	if file == "" {
+		return nil, nil
+	}
+
+	data, err := ioutil.ReadFile(file)
+	if err != nil {
+		return nil, err
+	}
+
+	var routes Routes
+	if err := yaml.Unmarshal(data, &routes); err != nil {
+		return nil, err
+	}
+
+	names := make(map[string]bool)
+
+	for i, route := range routes.Routes {
+		if route.Name == "" {
+			return nil, fmt.Errorf("route %d: name is required", i)
+		}
+
+		if names[route.Name] {
+			return nil, fmt.Errorf("route %s: name must be unique", route.Name)
+		}
+		names[route.Name] = true
+
+		if route.OrganisationID == "" || route.IntegrationID == "" {
+			return nil, fmt.Errorf("route %s: organisationID and integrationID are required", route.Name)
+		}
+
+		if err := route.Registry.compile(); err != nil {
+			return nil, fmt.Errorf("route %s: registry: %w", route.Name, err)
+		}
+
+		if err := route.Repository.compile(); err != nil {
+			return nil, fmt.Errorf("route %s: repository: %w", route.Name, err)
+		}
+
+		route.APIKey = os.ExpandEnv(route.APIKey)
+	}
+
+	return routes.Routes, nil
+}
+
+// matchRoute returns the first route, which matches the given registry url and repository. If no route matches, nil is
+// returned.
+func matchRoute(routes []*Route, registryURL, repository string) *Route {
+	for _, route := range routes {
+		if route.Registry.match(registryURL) && route.Repository.match(repository) {
+			return route
+		}
+	}
+
+	return nil
+}
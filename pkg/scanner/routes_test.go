@@ -0,0 +1,78 @@
+package scanner
+
+import (
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/stretchr/testify/require"
+)
+
+func TestLoadRoutes(t *testing.T) {
+	writeRoutes := func(t *testing.T, content string) string {
+		file := filepath.Join(t.TempDir(), "routes.yaml")
+		require.NoError(t, ioutil.WriteFile(file, []byte(content), 0600))
+		return file
+	}
+
+	t.Run("no file", func(t *testing.T) {
+		routes, err := loadRoutes("")
+		require.NoError(t, err)
+		require.Nil(t, routes)
+	})
+
+	t.Run("valid routes", func(t *testing.T) {
+		os.Setenv("TEST_ROUTES_API_KEY", "secret")
+		defer os.Unsetenv("TEST_ROUTES_API_KEY")
+
+		routes, err := loadRoutes(writeRoutes(t, `routes:
+  - name: team-a
+    repository:
+      glob: team-a/*
+    organisationID: org-a
+    integrationID: integration-a
+    apiKey: ${TEST_ROUTES_API_KEY}
+`))
+		require.NoError(t, err)
+		require.Len(t, routes, 1)
+		require.Equal(t, "secret", routes[0].APIKey)
+	})
+
+	for _, tt := range []struct {
+		name    string
+		content string
+		err     string
+	}{
+		{name: "missing name", content: "routes:\n  - organisationID: org\n    integrationID: integration\n", err: "route 0: name is required"},
+		{name: "duplicated name", content: "routes:\n  - name: a\n    organisationID: org\n    integrationID: integration\n  - name: a\n    organisationID: org\n    integrationID: integration\n", err: "route a: name must be unique"},
+		{name: "missing organisation", content: "routes:\n  - name: a\n    integrationID: integration\n", err: "route a: organisationID and integrationID are required"},
+		{name: "multiple matchers", content: "routes:\n  - name: a\n    repository:\n      prefix: a/\n      glob: a/*\n    organisationID: org\n    integrationID: integration\n", err: "route a: repository: only one of prefix, glob and regex can be set"},
+		{name: "invalid regex", content: "routes:\n  - name: a\n    registry:\n      regex: \"[\"\n    organisationID: org\n    integrationID: integration\n", err: "route a: registry: invalid regex \"[\": error parsing regexp: missing closing ]: `[`"},
+	} {
+		t.Run(tt.name, func(t *testing.T) {
+			_, err := loadRoutes(writeRoutes(t, tt.content))
+			require.EqualError(t, err, tt.err)
+		})
+	}
+}
+
+func TestMatchRoute(t *testing.T) {
+	routes := []*Route{
+		{Name: "prefix", Repository: Matcher{Prefix: "team-a/"}},
+		{Name: "glob", Repository: Matcher{Glob: "team-b/*"}},
+		{Name: "regex", Registry: Matcher{Regex: `^https://harbor-c\.example\.com$`}, Repository: Matcher{Regex: `^team-c/`}},
+	}
+
+	for _, route := range routes {
+		require.NoError(t, route.Registry.compile())
+		require.NoError(t, route.Repository.compile())
+	}
+
+	require.Equal(t, "prefix", matchRoute(routes, "https://harbor.example.com", "team-a/app/backend").Name)
+	require.Equal(t, "glob", matchRoute(routes, "https://harbor.example.com", "team-b/app").Name)
+	require.Nil(t, matchRoute(routes, "https://harbor.example.com", "team-b/app/backend"))
+	require.Equal(t, "regex", matchRoute(routes, "https://harbor-c.example.com", "team-c/app").Name)
+	require.Nil(t, matchRoute(routes, "https://harbor.example.com", "team-c/app"))
+	require.Nil(t, matchRoute(nil, "https://harbor.example.com", "team-a/app"))
+}
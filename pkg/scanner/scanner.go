@@ -2,13 +2,20 @@ package scanner
 
 import (
 	"context"
+	"fmt"
 	"net/http"
 	"os"
+	"strconv"
+	"strings"
+	"sync"
+	"text/template"
 	"time"
 
+	"github.com/ricoberger/harbor-snyk-scanner/pkg/harbor"
 	"github.com/ricoberger/harbor-snyk-scanner/pkg/log"
 	"github.com/ricoberger/harbor-snyk-scanner/pkg/scanner/middleware/httplog"
 	"github.com/ricoberger/harbor-snyk-scanner/pkg/scanner/middleware/metrics"
+	"github.com/ricoberger/harbor-snyk-scanner/pkg/scanner/middleware/tracing"
 	"github.com/ricoberger/harbor-snyk-scanner/pkg/snyk"
 
 	"github.com/go-chi/chi/v5"
@@ -19,30 +26,278 @@ import (
 )
 
 var (
-	address string
+	address        string
+	secrets        []string
+	encryptIDs     bool
+	storeType      string
+	storePath      string
+	storeRetention time.Duration
+	workers        int
+	pollInterval   time.Duration
+	deadline       time.Duration
+	refreshInitial time.Duration
+	refreshMax     time.Duration
+	refreshFactor  float64
+	reuseMaxAge    time.Duration
+
+	licenseSeverities map[string]string
+	routesFile        string
+	imageTemplate     string
+	projectTags       map[string]string
+	projectAttributes map[string]string
+
+	ephemeral            string
+	ephemeralGracePeriod time.Duration
+	ephemeralDryRun      bool
+
+	metricsProjectLabel bool
+	metricsMaxProjects  int
+
+	gcInterval     time.Duration
+	gcMaxDeletions int
+	gcOrigins      []string
+	gcDryRun       bool
 )
 
 // init is used to define all flags, which are needed for the scanner server. We have to define the address, where
-// the application server is listen on.
+// the application server is listen on, the secrets which are used to sign the scan request ids, the store for the
+// scan jobs and the workers which are polling Snyk for the results of the scan jobs.
 func init() {
 	defaultAddress := ":8080"
 	if os.Getenv("SCANNER_ADDRESS") != "" {
 		defaultAddress = os.Getenv("SCANNER_ADDRESS")
 	}
 
+	var defaultSecrets []string
+	if os.Getenv("SCANNER_SECRETS") != "" {
+		defaultSecrets = strings.Split(os.Getenv("SCANNER_SECRETS"), ",")
+	}
+
+	defaultEncryptIDs := false
+	if os.Getenv("SCANNER_ENCRYPT_IDS") != "" {
+		defaultEncryptIDs, _ = strconv.ParseBool(os.Getenv("SCANNER_ENCRYPT_IDS"))
+	}
+
+	defaultStoreType := "memory"
+	if os.Getenv("SCANNER_STORE") != "" {
+		defaultStoreType = os.Getenv("SCANNER_STORE")
+	}
+
+	defaultStorePath := "scanner.db"
+	if os.Getenv("SCANNER_STORE_PATH") != "" {
+		defaultStorePath = os.Getenv("SCANNER_STORE_PATH")
+	}
+
+	defaultStoreRetention := 24 * time.Hour
+	if os.Getenv("SCANNER_STORE_RETENTION") != "" {
+		parsedStoreRetention, err := time.ParseDuration(os.Getenv("SCANNER_STORE_RETENTION"))
+		if err == nil {
+			defaultStoreRetention = parsedStoreRetention
+		}
+	}
+
+	defaultWorkers := 5
+	if os.Getenv("SCANNER_WORKERS") != "" {
+		parsedWorkers, err := strconv.Atoi(os.Getenv("SCANNER_WORKERS"))
+		if err == nil {
+			defaultWorkers = parsedWorkers
+		}
+	}
+
+	defaultPollInterval := 30 * time.Second
+	if os.Getenv("SCANNER_POLL_INTERVAL") != "" {
+		parsedPollInterval, err := time.ParseDuration(os.Getenv("SCANNER_POLL_INTERVAL"))
+		if err == nil {
+			defaultPollInterval = parsedPollInterval
+		}
+	}
+
+	defaultDeadline := 1 * time.Hour
+	if os.Getenv("SCANNER_DEADLINE") != "" {
+		parsedDeadline, err := time.ParseDuration(os.Getenv("SCANNER_DEADLINE"))
+		if err == nil {
+			defaultDeadline = parsedDeadline
+		}
+	}
+
+	defaultRefreshInitial := 1 * time.Minute
+	if os.Getenv("SCANNER_REFRESH_AFTER_INITIAL") != "" {
+		parsedRefreshInitial, err := time.ParseDuration(os.Getenv("SCANNER_REFRESH_AFTER_INITIAL"))
+		if err == nil {
+			defaultRefreshInitial = parsedRefreshInitial
+		}
+	}
+
+	defaultRefreshMax := 10 * time.Minute
+	if os.Getenv("SCANNER_REFRESH_AFTER_MAX") != "" {
+		parsedRefreshMax, err := time.ParseDuration(os.Getenv("SCANNER_REFRESH_AFTER_MAX"))
+		if err == nil {
+			defaultRefreshMax = parsedRefreshMax
+		}
+	}
+
+	defaultRefreshFactor := 2.0
+	if os.Getenv("SCANNER_REFRESH_AFTER_FACTOR") != "" {
+		parsedRefreshFactor, err := strconv.ParseFloat(os.Getenv("SCANNER_REFRESH_AFTER_FACTOR"), 64)
+		if err == nil {
+			defaultRefreshFactor = parsedRefreshFactor
+		}
+	}
+
+	defaultReuseMaxAge := time.Duration(0)
+	if os.Getenv("SCANNER_REUSE_MAX_AGE") != "" {
+		parsedReuseMaxAge, err := time.ParseDuration(os.Getenv("SCANNER_REUSE_MAX_AGE"))
+		if err == nil {
+			defaultReuseMaxAge = parsedReuseMaxAge
+		}
+	}
+
+	defaultLicenseSeverities := map[string]string{}
+	if os.Getenv("SCANNER_LICENSE_SEVERITIES") != "" {
+		for _, mapping := range strings.Split(os.Getenv("SCANNER_LICENSE_SEVERITIES"), ",") {
+			if parts := strings.SplitN(mapping, "=", 2); len(parts) == 2 {
+				defaultLicenseSeverities[parts[0]] = parts[1]
+			}
+		}
+	}
+
+	defaultRoutesFile := ""
+	if os.Getenv("SCANNER_ROUTES_FILE") != "" {
+		defaultRoutesFile = os.Getenv("SCANNER_ROUTES_FILE")
+	}
+
+	defaultImageTemplate := "{{ .Repository }}{{ if .Digest }}@{{ .Digest }}{{ else }}:{{ .Tag }}{{ end }}"
+	if os.Getenv("SCANNER_IMAGE_TEMPLATE") != "" {
+		defaultImageTemplate = os.Getenv("SCANNER_IMAGE_TEMPLATE")
+	}
+
+	defaultEphemeral := ""
+	if os.Getenv("SCANNER_EPHEMERAL") != "" {
+		defaultEphemeral = os.Getenv("SCANNER_EPHEMERAL")
+	}
+
+	defaultEphemeralGracePeriod := 10 * time.Minute
+	if os.Getenv("SCANNER_EPHEMERAL_GRACE_PERIOD") != "" {
+		parsedEphemeralGracePeriod, err := time.ParseDuration(os.Getenv("SCANNER_EPHEMERAL_GRACE_PERIOD"))
+		if err == nil {
+			defaultEphemeralGracePeriod = parsedEphemeralGracePeriod
+		}
+	}
+
+	defaultEphemeralDryRun := false
+	if os.Getenv("SCANNER_EPHEMERAL_DRY_RUN") != "" {
+		defaultEphemeralDryRun, _ = strconv.ParseBool(os.Getenv("SCANNER_EPHEMERAL_DRY_RUN"))
+	}
+
+	defaultProjectTags := make(map[string]string)
+	if os.Getenv("SCANNER_PROJECT_TAGS") != "" {
+		for _, tag := range strings.Split(os.Getenv("SCANNER_PROJECT_TAGS"), ",") {
+			if parts := strings.SplitN(tag, "=", 2); len(parts) == 2 {
+				defaultProjectTags[parts[0]] = parts[1]
+			}
+		}
+	}
+
+	defaultProjectAttributes := make(map[string]string)
+	if os.Getenv("SCANNER_PROJECT_ATTRIBUTES") != "" {
+		for _, attribute := range strings.Split(os.Getenv("SCANNER_PROJECT_ATTRIBUTES"), ",") {
+			if parts := strings.SplitN(attribute, "=", 2); len(parts) == 2 {
+				defaultProjectAttributes[parts[0]] = parts[1]
+			}
+		}
+	}
+
+	defaultMetricsProjectLabel := false
+	if os.Getenv("SCANNER_METRICS_PROJECT_LABEL") != "" {
+		defaultMetricsProjectLabel, _ = strconv.ParseBool(os.Getenv("SCANNER_METRICS_PROJECT_LABEL"))
+	}
+
+	defaultMetricsMaxProjects := 100
+	if os.Getenv("SCANNER_METRICS_MAX_PROJECTS") != "" {
+		parsedMetricsMaxProjects, err := strconv.Atoi(os.Getenv("SCANNER_METRICS_MAX_PROJECTS"))
+		if err == nil {
+			defaultMetricsMaxProjects = parsedMetricsMaxProjects
+		}
+	}
+
+	defaultGCInterval := time.Duration(0)
+	if os.Getenv("SCANNER_GC_INTERVAL") != "" {
+		parsedGCInterval, err := time.ParseDuration(os.Getenv("SCANNER_GC_INTERVAL"))
+		if err == nil {
+			defaultGCInterval = parsedGCInterval
+		}
+	}
+
+	defaultGCMaxDeletions := 50
+	if os.Getenv("SCANNER_GC_MAX_DELETIONS") != "" {
+		parsedGCMaxDeletions, err := strconv.Atoi(os.Getenv("SCANNER_GC_MAX_DELETIONS"))
+		if err == nil {
+			defaultGCMaxDeletions = parsedGCMaxDeletions
+		}
+	}
+
+	defaultGCOrigins := []string{"harbor-cr"}
+	if os.Getenv("SCANNER_GC_ORIGINS") != "" {
+		defaultGCOrigins = strings.Split(os.Getenv("SCANNER_GC_ORIGINS"), ",")
+	}
+
+	defaultGCDryRun := true
+	if os.Getenv("SCANNER_GC_DRY_RUN") != "" {
+		defaultGCDryRun, _ = strconv.ParseBool(os.Getenv("SCANNER_GC_DRY_RUN"))
+	}
+
 	flag.StringVar(&address, "scanner.address", defaultAddress, "The address, where the scanner server is listen on.")
+	flag.StringSliceVar(&secrets, "scanner.secrets", defaultSecrets, "The secrets to sign the scan request ids. The first secret is used for new ids, all secrets are accepted for verification. If no secret is set a random one is generated on startup, which is not allowed for the \"bolt\" store.")
+	flag.BoolVar(&encryptIDs, "scanner.encrypt-ids", defaultEncryptIDs, "Encrypt the scan request ids, so that the contained scan job id is not visible for Harbor.")
+	flag.StringVar(&storeType, "scanner.store", defaultStoreType, "The store for the scan jobs. Must be \"memory\" or \"bolt\".")
+	flag.StringVar(&storePath, "scanner.store-path", defaultStorePath, "The path of the database file, when the \"bolt\" store is used.")
+	flag.DurationVar(&storeRetention, "scanner.store-retention", defaultStoreRetention, "The duration, after which finished and abandoned scan jobs are removed from the store.")
+	flag.IntVar(&workers, "scanner.workers", defaultWorkers, "The number of workers, which are polling Snyk for the results of the scan jobs.")
+	flag.DurationVar(&pollInterval, "scanner.poll-interval", defaultPollInterval, "The interval in which a worker polls Snyk for the result of a pending scan job.")
+	flag.DurationVar(&deadline, "scanner.deadline", defaultDeadline, "The duration after which a pending scan job is marked as failed.")
+	flag.DurationVar(&refreshInitial, "scanner.refresh-after-initial", defaultRefreshInitial, "The initial value of the Refresh-After header, which is returned to Harbor for a pending scan job.")
+	flag.DurationVar(&refreshMax, "scanner.refresh-after-max", defaultRefreshMax, "The maximum value of the Refresh-After header, which is returned to Harbor for a pending scan job.")
+	flag.Float64Var(&refreshFactor, "scanner.refresh-after-factor", defaultRefreshFactor, "The factor by which the Refresh-After header is increased, while a scan job is pending.")
+	flag.DurationVar(&reuseMaxAge, "scanner.reuse-max-age", defaultReuseMaxAge, "Reuse existing projects for an image from Snyk, when they were tested within the given duration, instead of importing the image again. If the value is 0, the image is always imported. Can not be used together with the ephemeral mode.")
+	flag.StringToStringVar(&licenseSeverities, "scanner.license-severities", defaultLicenseSeverities, "Map the severity of license issues from Snyk to another severity in the scan report, e.g. \"high=critical,medium=high\". Severities without a mapping are used as they are.")
+	flag.StringVar(&routesFile, "scanner.routes-file", defaultRoutesFile, "The path to a file with routes, which map Harbor registries and repositories to Snyk organisations and integrations. If no route matches, the Snyk organisation and integration from the flags is used.")
+	flag.StringVar(&ephemeral, "scanner.ephemeral", defaultEphemeral, "Remove the projects, which were created for a scan, from Snyk after the report was delivered or the scan failed. Must be \"delete\" or \"deactivate\". If the value is empty, the projects are kept. Projects, which are still used by another scan job for the same image, are removed with the last job.")
+	flag.DurationVar(&ephemeralGracePeriod, "scanner.ephemeral-grace-period", defaultEphemeralGracePeriod, "The duration after the delivery of the report, after which the projects are removed from Snyk.")
+	flag.BoolVar(&ephemeralDryRun, "scanner.ephemeral-dry-run", defaultEphemeralDryRun, "Only log the projects, which would be removed from Snyk, instead of removing them.")
+	flag.StringToStringVar(&projectTags, "scanner.project-tags", defaultProjectTags, "The tags, which are added to the imported projects in Snyk in addition to the \"managed-by=harbor-snyk-scanner\" tag, e.g. \"repository={{ .Artifact.Repository }},digest={{ .Artifact.Digest }}\". The value of a tag is a Go template for the scan request from Harbor, which can also use the \"split\", \"join\", \"lower\", \"upper\" and \"replace\" functions. Tags with an empty value are skipped.")
+	flag.StringToStringVar(&projectAttributes, "scanner.project-attributes", defaultProjectAttributes, "The attributes, which are set for the imported projects in Snyk. Supported attributes are \"criticality\", \"environment\" and \"lifecycle\". The value is a Go template for the Harbor scan request, multiple values must be separated by whitespace.")
+	flag.BoolVar(&metricsProjectLabel, "scanner.metrics-project-label", defaultMetricsProjectLabel, "Add the Harbor project as label to the scan metrics.")
+	flag.IntVar(&metricsMaxProjects, "scanner.metrics-max-projects", defaultMetricsMaxProjects, "The maximum number of Harbor projects, which are used as label for the scan metrics. All other projects are reported as \"other\".")
+	flag.DurationVar(&gcInterval, "scanner.gc-interval", defaultGCInterval, "The interval in which the projects in Snyk are compared with the artifacts in Harbor, to delete the projects of deleted artifacts. If the value is 0, the garbage collector is disabled.")
+	flag.IntVar(&gcMaxDeletions, "scanner.gc-max-deletions", defaultGCMaxDeletions, "The maximum number of projects, which are deleted by the garbage collector in a single run.")
+	flag.StringSliceVar(&gcOrigins, "scanner.gc-origins", defaultGCOrigins, "The origins of the projects in Snyk, which are checked by the garbage collector. If no origin is set, all projects of the organisation are checked.")
+	flag.BoolVar(&gcDryRun, "scanner.gc-dry-run", defaultGCDryRun, "Only log the projects, which would be deleted by the garbage collector, instead of deleting them. The garbage collector only deletes projects with the \"managed-by=harbor-snyk-scanner\" tag, which is added to all imported projects.")
+	flag.StringVar(&imageTemplate, "scanner.image-template", defaultImageTemplate, "The Go template for the image, which is imported into Snyk. The template can use the \".Registry\" host, the \".RegistryURL\", the \".Repository\", the \".Tag\" and the \".Digest\" of the scanned artifact.")
 }
 
 // Server implements the scanner server. The scanner server is used to receive the scanning requests from Harbor.
 type Server struct {
-	snykClient snyk.Client
-	server     *http.Server
+	imageTemplate *template.Template
+	routes        []*Route
+	metadata      *metadataTemplates
+	clients       map[string]snyk.Client
+	harborClient  *harbor.Client
+	projectLabels *projectLabels
+	ids           *idCodec
+	store         ScanStore
+	queue         chan string
+	server        *http.Server
+	ctx           context.Context
+	cancel        context.CancelFunc
+	wg            sync.WaitGroup
 }
 
 // Start starts serving the scanner server.
 func (s *Server) Start() {
 	log.Info(nil, "Scanner server started", zap.String("address", s.server.Addr))
 
+	s.startWorkers()
+
 	if err := s.server.ListenAndServe(); err != nil {
 		if err != http.ErrServerClosed {
 			log.Error(nil, "Scanner server died unexpected", zap.Error(err))
@@ -61,18 +316,129 @@ func (s *Server) Stop() {
 	if err != nil {
 		log.Error(nil, "Graceful shutdown of the scanner server failed", zap.Error(err))
 	}
+
+	s.cancel()
+	s.wg.Wait()
+
+	err = s.store.Close()
+	if err != nil {
+		log.Error(nil, "Could not close scan job store", zap.Error(err))
+	}
+}
+
+// getSnykClient returns the Snyk client for the route with the given name. The empty name is used for scan jobs, which
+// didn't match any route. An error is returned when the route was removed from the routes file after the scan job was
+// created.
+func (s *Server) getSnykClient(route string) (snyk.Client, error) {
+	client, ok := s.clients[route]
+	if !ok {
+		return nil, fmt.Errorf("route %q does not exist", route)
+	}
+
+	return client, nil
 }
 
 // New return a new scanner server.
-func New(snykClient snyk.Client) *Server {
+func New(snykClient snyk.Client) (*Server, error) {
+	// Without a configured secret a random one is generated on startup, so that all scan request ids are invalid after
+	// a restart. This would defeat the persistent bolt store, so that a secret is required in this case.
+	if len(secrets) == 0 {
+		if storeType == "bolt" {
+			return nil, fmt.Errorf("at least one secret for the scan request ids is required, when the \"bolt\" store is used")
+		}
+
+		log.Warn(nil, "No secret for the scan request ids was provided, running scans can not be continued after a restart")
+	}
+
+	if workers < 1 {
+		return nil, fmt.Errorf("at least one worker is required")
+	}
+
+	if refreshInitial < time.Second || refreshMax < refreshInitial || refreshFactor < 1 {
+		return nil, fmt.Errorf("invalid refresh after configuration: initial must be at least 1s, max must be greater or equal than initial and factor must be greater or equal than 1")
+	}
+
+	if storeRetention <= deadline {
+		return nil, fmt.Errorf("the retention of the store must be greater than the deadline of a scan job")
+	}
+
+	if ephemeral != "" && ephemeral != "delete" && ephemeral != "deactivate" {
+		return nil, fmt.Errorf("invalid ephemeral mode %q, must be \"delete\" or \"deactivate\"", ephemeral)
+	}
+
+	if ephemeral != "" && reuseMaxAge > 0 {
+		return nil, fmt.Errorf("the ephemeral mode can not be used together with the reuse of existing projects")
+	}
+
+	if ephemeral != "" && storeRetention <= deadline+ephemeralGracePeriod {
+		return nil, fmt.Errorf("the retention of the store must be greater than the deadline of a scan job and the grace period of the ephemeral mode")
+	}
+
+	if err := validateSeverityMapping(licenseSeverities); err != nil {
+		return nil, fmt.Errorf("invalid license severities: %w", err)
+	}
+
+	imageTmpl, err := parseImageTemplate(imageTemplate)
+	if err != nil {
+		return nil, fmt.Errorf("invalid image template: %w", err)
+	}
+
+	metadata, err := parseMetadataTemplates(projectTags, projectAttributes)
+	if err != nil {
+		return nil, fmt.Errorf("invalid project metadata: %w", err)
+	}
+
+	routes, err := loadRoutes(routesFile)
+	if err != nil {
+		return nil, fmt.Errorf("could not load routes: %w", err)
+	}
+
+	var harborClient *harbor.Client
+	if gcInterval > 0 {
+		if gcMaxDeletions < 1 {
+			return nil, fmt.Errorf("the garbage collector must be allowed to delete at least one project per run")
+		}
+
+		harborClient, err = harbor.NewClient()
+		if err != nil {
+			return nil, fmt.Errorf("could not create Harbor client: %w", err)
+		}
+	}
+
+	clients := map[string]snyk.Client{"": snykClient}
+	for _, route := range routes {
+		clients[route.Name] = snykClient.WithOrganisation(route.OrganisationID, route.IntegrationID, route.APIKey)
+	}
+
+	ids, err := newIDCodec(secrets, encryptIDs)
+	if err != nil {
+		return nil, err
+	}
+
+	store, err := NewStore(storeType, storePath)
+	if err != nil {
+		return nil, err
+	}
+
 	router := chi.NewRouter()
+	ctx, cancel := context.WithCancel(context.Background())
 
 	server := &Server{
-		snykClient: snykClient,
+		imageTemplate: imageTmpl,
+		routes:        routes,
+		metadata:      metadata,
+		clients:       clients,
+		harborClient:  harborClient,
+		projectLabels: newProjectLabels(metricsProjectLabel, metricsMaxProjects),
+		ids:           ids,
+		store:         store,
+		queue:         make(chan string),
 		server: &http.Server{
 			Addr:    address,
 			Handler: router,
 		},
+		ctx:    ctx,
+		cancel: cancel,
 	}
 
 	router.Get("/health", func(w http.ResponseWriter, r *http.Request) {
@@ -81,9 +447,10 @@ func New(snykClient snyk.Client) *Server {
 
 	router.Route("/api", func(r chi.Router) {
 		r.Use(middleware.RequestID)
+		r.Use(tracing.Tracing)
 		r.Use(middleware.Recoverer)
 		r.Use(middleware.URLFormat)
-		r.Use(metrics.Metrics)
+		r.Use(metrics.New())
 		r.Use(httplog.Logger)
 
 		r.Post("/scan", server.acceptScanRequest)
@@ -91,5 +458,5 @@ func New(snykClient snyk.Client) *Server {
 		r.Get("/metadata", server.getMetadata)
 	})
 
-	return server
+	return server, nil
 }
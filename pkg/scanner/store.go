@@ -0,0 +1,163 @@
+package scanner
+
+import (
+	"context"
+	"crypto/rand"
+	"encoding/base64"
+	"fmt"
+	"io"
+	"time"
+
+	"github.com/ricoberger/harbor-snyk-scanner/pkg/harbor"
+	"github.com/ricoberger/harbor-snyk-scanner/pkg/snyk"
+)
+
+var (
+	// ErrScanJobNotFound is returned by a ScanStore, when no job exists for the given id.
+	ErrScanJobNotFound = fmt.Errorf("scan job not found")
+)
+
+// ScanJobStatus is the status of a scan job.
+type ScanJobStatus string
+
+const (
+	// ScanJobStatusPending is the status of a scan job, for which we do not have a report yet.
+	ScanJobStatusPending ScanJobStatus = "pending"
+	// ScanJobStatusCompleted is the status of a scan job, for which the report was created.
+	ScanJobStatusCompleted ScanJobStatus = "completed"
+	// ScanJobStatusFailed is the status of a scan job, for which we will not create a report.
+	ScanJobStatusFailed ScanJobStatus = "failed"
+)
+
+// ScanJob is the record for a scan request from Harbor. It contains all the information we need to create the report
+// for the scanned artifact, like the location of the Snyk import job and the ids of the imported projects. When the
+// job is completed it also contains the report for the artifact. The route is the name of the route, which was used to
+// select the Snyk organisation for the job. It is empty when no route matched.
+//
+// Tags and Attributes are rendered from the scan request, when the image is imported. They are set for the projects
+// of the import job, as soon as the ids of the projects are known.
+//
+// ReportedAt is the time, when the report was delivered to Harbor for the first time.
+//
+// TraceContext contains the trace context of the scan request, which created the job. It is used as parent for the
+// spans of the workers, so that all spans of a scan are part of the same trace.
+//
+// When the ephemeral mode is enabled, CleanupAt is the time after which the projects of the job are removed from Snyk
+// and CleanedUp is set, when the projects were removed.
+type ScanJob struct {
+	ID           string                  `json:"id"`
+	Artifact     harbor.Artifact         `json:"artifact"`
+	Image        string                  `json:"image"`
+	Route        string                  `json:"route,omitempty"`
+	Location     string                  `json:"location"`
+	ProjectIDs   []string                `json:"projectIDs,omitempty"`
+	Tags         []snyk.Tag              `json:"tags,omitempty"`
+	Attributes   *snyk.ProjectAttributes `json:"attributes,omitempty"`
+	Status       ScanJobStatus           `json:"status"`
+	Error        string                  `json:"error,omitempty"`
+	Attempts     int                     `json:"attempts"`
+	Report       *harbor.ScanReport      `json:"report,omitempty"`
+	RetryAt      time.Time               `json:"retryAt"`
+	ReportedAt   time.Time               `json:"reportedAt"`
+	TraceContext map[string]string       `json:"traceContext,omitempty"`
+	CleanupAt    time.Time               `json:"cleanupAt"`
+	CleanedUp    bool                    `json:"cleanedUp,omitempty"`
+	CreatedAt    time.Time               `json:"createdAt"`
+	UpdatedAt    time.Time               `json:"updatedAt"`
+}
+
+// ScanStore is the interface, which must be implemented by a store for scan jobs. A store must be safe for concurrent
+// use and must return copies of the saved jobs, so that a caller can modify a returned job without affecting the store.
+//
+// MarkReported sets the time, when the report of a job was delivered, and the time, after which the projects of the job
+// are removed, in a single step. It returns false without changing the job, when the report was already delivered, so
+// that only one of multiple concurrent requests for a report handles the first delivery. The cleanup time is only set,
+// when it is not zero and the job doesn't have a cleanup time yet.
+type ScanStore interface {
+	Create(ctx context.Context, job *ScanJob) error
+	Get(ctx context.Context, id string) (*ScanJob, error)
+	Update(ctx context.Context, job *ScanJob) error
+	MarkReported(ctx context.Context, id string, reportedAt, cleanupAt time.Time) (bool, error)
+	Delete(ctx context.Context, id string) error
+	List(ctx context.Context) ([]*ScanJob, error)
+	Close() error
+}
+
+// NewStore returns a new store for the given type. The type must be "memory" or "bolt". For the "bolt" type the path
+// of the database file must be provided.
+func NewStore(storeType, path string) (ScanStore, error) {
+	switch storeType {
+	case "memory":
+		return newMemoryStore(), nil
+	case "bolt":
+		return newBoltStore(path)
+	default:
+		return nil, fmt.Errorf("invalid store type %q", storeType)
+	}
+}
+
+// markReported sets the report and cleanup time of the given job, as described for the MarkReported method of the
+// ScanStore interface. It returns false, when the report of the job was already delivered.
+func markReported(job *ScanJob, reportedAt, cleanupAt time.Time) bool {
+	if !job.ReportedAt.IsZero() {
+		return false
+	}
+
+	job.ReportedAt = reportedAt
+	if job.CleanupAt.IsZero() {
+		job.CleanupAt = cleanupAt
+	}
+
+	return true
+}
+
+// newScanJobID returns a new random id for a scan job.
+func newScanJobID() (string, error) {
+	id := make([]byte, 12)
+	if _, err := io.ReadFull(rand.Reader, id); err != nil {
+		return "", err
+	}
+
+	return base64.RawURLEncoding.EncodeToString(id), nil
+}
+
+// copyScanJob returns a deep copy of the given job.
+func copyScanJob(job *ScanJob) *ScanJob {
+	jobCopy := *job
+	if job.ProjectIDs != nil {
+		jobCopy.ProjectIDs = append([]string{}, job.ProjectIDs...)
+	}
+
+	if job.Tags != nil {
+		jobCopy.Tags = append([]snyk.Tag{}, job.Tags...)
+	}
+
+	if job.Attributes != nil {
+		attributes := snyk.ProjectAttributes{}
+		if job.Attributes.Criticality != nil {
+			attributes.Criticality = append([]string{}, job.Attributes.Criticality...)
+		}
+		if job.Attributes.Environment != nil {
+			attributes.Environment = append([]string{}, job.Attributes.Environment...)
+		}
+		if job.Attributes.Lifecycle != nil {
+			attributes.Lifecycle = append([]string{}, job.Attributes.Lifecycle...)
+		}
+		jobCopy.Attributes = &attributes
+	}
+
+	if job.TraceContext != nil {
+		jobCopy.TraceContext = make(map[string]string, len(job.TraceContext))
+		for key, value := range job.TraceContext {
+			jobCopy.TraceContext[key] = value
+		}
+	}
+
+	if job.Report != nil {
+		report := *job.Report
+		report.Vulnerabilities = append([]harbor.Vulnerability(nil), job.Report.Vulnerabilities...)
+		jobCopy.Report = &report
+	}
+
+	return &jobCopy
+}
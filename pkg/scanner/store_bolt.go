@@ -0,0 +1,164 @@
+package scanner
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"sort"
+	"time"
+
+	bolt "go.etcd.io/bbolt"
+)
+
+var (
+	boltJobsBucket = []byte("jobs")
+)
+
+// boltStore implements the ScanStore interface on top of an embedded bbolt database. The jobs are saved as JSON in the
+// "jobs" bucket, with the id of the job as key, so that they survive a restart of the scanner.
+type boltStore struct {
+	db *bolt.DB
+}
+
+func (s *boltStore) Create(ctx context.Context, job *ScanJob) error {
+	data, err := json.Marshal(job)
+	if err != nil {
+		return err
+	}
+
+	return s.db.Update(func(tx *bolt.Tx) error {
+		bucket := tx.Bucket(boltJobsBucket)
+		if bucket.Get([]byte(job.ID)) != nil {
+			return fmt.Errorf("scan job %q already exists", job.ID)
+		}
+
+		return bucket.Put([]byte(job.ID), data)
+	})
+}
+
+func (s *boltStore) Get(ctx context.Context, id string) (*ScanJob, error) {
+	var job ScanJob
+
+	err := s.db.View(func(tx *bolt.Tx) error {
+		data := tx.Bucket(boltJobsBucket).Get([]byte(id))
+		if data == nil {
+			return ErrScanJobNotFound
+		}
+
+		return json.Unmarshal(data, &job)
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	return &job, nil
+}
+
+func (s *boltStore) Update(ctx context.Context, job *ScanJob) error {
+	data, err := json.Marshal(job)
+	if err != nil {
+		return err
+	}
+
+	return s.db.Update(func(tx *bolt.Tx) error {
+		bucket := tx.Bucket(boltJobsBucket)
+		if bucket.Get([]byte(job.ID)) == nil {
+			return ErrScanJobNotFound
+		}
+
+		return bucket.Put([]byte(job.ID), data)
+	})
+}
+
+func (s *boltStore) MarkReported(ctx context.Context, id string, reportedAt, cleanupAt time.Time) (bool, error) {
+	var marked bool
+
+	err := s.db.Update(func(tx *bolt.Tx) error {
+		bucket := tx.Bucket(boltJobsBucket)
+
+		data := bucket.Get([]byte(id))
+		if data == nil {
+			return ErrScanJobNotFound
+		}
+
+		var job ScanJob
+		if err := json.Unmarshal(data, &job); err != nil {
+			return err
+		}
+
+		if marked = markReported(&job, reportedAt, cleanupAt); !marked {
+			return nil
+		}
+
+		data, err := json.Marshal(&job)
+		if err != nil {
+			return err
+		}
+
+		return bucket.Put([]byte(id), data)
+	})
+	if err != nil {
+		return false, err
+	}
+
+	return marked, nil
+}
+
+func (s *boltStore) Delete(ctx context.Context, id string) error {
+	return s.db.Update(func(tx *bolt.Tx) error {
+		return tx.Bucket(boltJobsBucket).Delete([]byte(id))
+	})
+}
+
+func (s *boltStore) List(ctx context.Context) ([]*ScanJob, error) {
+	var jobs []*ScanJob
+
+	err := s.db.View(func(tx *bolt.Tx) error {
+		return tx.Bucket(boltJobsBucket).ForEach(func(k, v []byte) error {
+			var job ScanJob
+			if err := json.Unmarshal(v, &job); err != nil {
+				return err
+			}
+
+			jobs = append(jobs, &job)
+			return nil
+		})
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	sort.Slice(jobs, func(i, j int) bool {
+		return jobs[i].CreatedAt.Before(jobs[j].CreatedAt)
+	})
+
+	return jobs, nil
+}
+
+func (s *boltStore) Close() error {
+	return s.db.Close()
+}
+
+func newBoltStore(path string) (*boltStore, error) {
+	if path == "" {
+		return nil, fmt.Errorf("path for the bolt store is missing")
+	}
+
+	db, err := bolt.Open(path, 0600, &bolt.Options{Timeout: 10 * time.Second})
+	if err != nil {
+		return nil, err
+	}
+
+	err = db.Update(func(tx *bolt.Tx) error {
+		_, err := tx.CreateBucketIfNotExists(boltJobsBucket)
+		return err
+	})
+	if err != nil {
+		db.Close()
+		return nil, err
+	}
+
+	return &boltStore{
+		db: db,
+	}, nil
+}
@@ -0,0 +1,98 @@
+package scanner
+
+import (
+	"context"
+	"fmt"
+	"sort"
+	"sync"
+	"time"
+)
+
+// memoryStore implements the ScanStore interface and keeps all jobs in memory. All jobs are lost when the scanner is
+// restarted.
+type memoryStore struct {
+	mu   sync.RWMutex
+	jobs map[string]*ScanJob
+}
+
+func (s *memoryStore) Create(ctx context.Context, job *ScanJob) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	if _, ok := s.jobs[job.ID]; ok {
+		return fmt.Errorf("scan job %q already exists", job.ID)
+	}
+
+	s.jobs[job.ID] = copyScanJob(job)
+	return nil
+}
+
+func (s *memoryStore) Get(ctx context.Context, id string) (*ScanJob, error) {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+
+	job, ok := s.jobs[id]
+	if !ok {
+		return nil, ErrScanJobNotFound
+	}
+
+	return copyScanJob(job), nil
+}
+
+func (s *memoryStore) Update(ctx context.Context, job *ScanJob) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	if _, ok := s.jobs[job.ID]; !ok {
+		return ErrScanJobNotFound
+	}
+
+	s.jobs[job.ID] = copyScanJob(job)
+	return nil
+}
+
+func (s *memoryStore) MarkReported(ctx context.Context, id string, reportedAt, cleanupAt time.Time) (bool, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	job, ok := s.jobs[id]
+	if !ok {
+		return false, ErrScanJobNotFound
+	}
+
+	return markReported(job, reportedAt, cleanupAt), nil
+}
+
+func (s *memoryStore) Delete(ctx context.Context, id string) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	delete(s.jobs, id)
+	return nil
+}
+
+func (s *memoryStore) List(ctx context.Context) ([]*ScanJob, error) {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+
+	jobs := make([]*ScanJob, 0, len(s.jobs))
+	for _, job := range s.jobs {
+		jobs = append(jobs, copyScanJob(job))
+	}
+
+	sort.Slice(jobs, func(i, j int) bool {
+		return jobs[i].CreatedAt.Before(jobs[j].CreatedAt)
+	})
+
+	return jobs, nil
+}
+
+func (s *memoryStore) Close() error {
+	return nil
+}
+
+func newMemoryStore() *memoryStore {
+	return &memoryStore{
+		jobs: make(map[string]*ScanJob),
+	}
+}
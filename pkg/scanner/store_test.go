@@ -0,0 +1,139 @@
+package scanner
+
+import (
+	"context"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/ricoberger/harbor-snyk-scanner/pkg/harbor"
+	"github.com/ricoberger/harbor-snyk-scanner/pkg/snyk"
+
+	"github.com/stretchr/testify/require"
+)
+
+func testStore(t *testing.T, store ScanStore) {
+	ctx := context.Background()
+	now := time.Now().UTC().Truncate(time.Second)
+
+	job := &ScanJob{
+		ID:        "job1",
+		Artifact:  harbor.Artifact{Repository: "library/nginx", Tag: "latest", Digest: "sha256:1234"},
+		Image:     "library/nginx:latest",
+		Location:  "https://snyk.io/api/v1/org/org/integrations/integration/import/job",
+		Status:    ScanJobStatusPending,
+		CreatedAt: now,
+		UpdatedAt: now,
+	}
+
+	_, err := store.Get(ctx, "job1")
+	require.ErrorIs(t, err, ErrScanJobNotFound)
+	require.ErrorIs(t, store.Update(ctx, job), ErrScanJobNotFound)
+
+	require.NoError(t, store.Create(ctx, job))
+	require.Error(t, store.Create(ctx, job))
+
+	savedJob, err := store.Get(ctx, "job1")
+	require.NoError(t, err)
+	require.Equal(t, job, savedJob)
+
+	savedJob.ProjectIDs = []string{"project1", "project2"}
+	savedJob.Status = ScanJobStatusCompleted
+	require.NoError(t, store.Update(ctx, savedJob))
+
+	savedJob.ProjectIDs[0] = "modified"
+	updatedJob, err := store.Get(ctx, "job1")
+	require.NoError(t, err)
+	require.Equal(t, []string{"project1", "project2"}, updatedJob.ProjectIDs)
+	require.Equal(t, ScanJobStatusCompleted, updatedJob.Status)
+
+	marked, err := store.MarkReported(ctx, "job1", now.Add(1*time.Minute), now.Add(1*time.Hour))
+	require.NoError(t, err)
+	require.True(t, marked)
+
+	marked, err = store.MarkReported(ctx, "job1", now.Add(2*time.Minute), now.Add(2*time.Hour))
+	require.NoError(t, err)
+	require.False(t, marked)
+
+	reportedJob, err := store.Get(ctx, "job1")
+	require.NoError(t, err)
+	require.True(t, now.Add(1*time.Minute).Equal(reportedJob.ReportedAt))
+	require.True(t, now.Add(1*time.Hour).Equal(reportedJob.CleanupAt))
+
+	_, err = store.MarkReported(ctx, "unknown", now, now)
+	require.ErrorIs(t, err, ErrScanJobNotFound)
+
+	require.NoError(t, store.Create(ctx, &ScanJob{ID: "job2", CreatedAt: now.Add(-1 * time.Minute)}))
+
+	jobs, err := store.List(ctx)
+	require.NoError(t, err)
+	require.Len(t, jobs, 2)
+	require.Equal(t, "job2", jobs[0].ID)
+	require.Equal(t, "job1", jobs[1].ID)
+
+	require.NoError(t, store.Delete(ctx, "job2"))
+	jobs, err = store.List(ctx)
+	require.NoError(t, err)
+	require.Len(t, jobs, 1)
+}
+
+func TestMemoryStore(t *testing.T) {
+	store, err := NewStore("memory", "")
+	require.NoError(t, err)
+	defer store.Close()
+
+	testStore(t, store)
+}
+
+func TestBoltStore(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "scanner.db")
+
+	store, err := NewStore("bolt", path)
+	require.NoError(t, err)
+
+	testStore(t, store)
+	require.NoError(t, store.Close())
+
+	store, err = NewStore("bolt", path)
+	require.NoError(t, err)
+	defer store.Close()
+
+	job, err := store.Get(context.Background(), "job1")
+	require.NoError(t, err)
+	require.Equal(t, []string{"project1", "project2"}, job.ProjectIDs)
+}
+
+func TestCopyScanJob(t *testing.T) {
+	job := &ScanJob{
+		ID:           "job1",
+		ProjectIDs:   []string{"project1"},
+		Tags:         []snyk.Tag{{Key: "harbor-project", Value: "library"}},
+		Attributes:   &snyk.ProjectAttributes{Environment: []string{"backend"}, Lifecycle: []string{"production"}},
+		Report:       &harbor.ScanReport{Vulnerabilities: []harbor.Vulnerability{{ID: "vulnerability1"}}},
+		TraceContext: map[string]string{"traceparent": "00-4bf92f3577b34da6a3ce929d0e0e4736-00f067aa0ba902b7-01"},
+	}
+
+	jobCopy := copyScanJob(job)
+	require.Equal(t, job, jobCopy)
+
+	jobCopy.ProjectIDs[0] = "modified"
+	jobCopy.Tags[0].Value = "modified"
+	jobCopy.Attributes.Environment[0] = "modified"
+	jobCopy.Attributes.Criticality = []string{"high"}
+	jobCopy.Report.Vulnerabilities[0].ID = "modified"
+	jobCopy.TraceContext["traceparent"] = "modified"
+
+	require.Equal(t, []string{"project1"}, job.ProjectIDs)
+	require.Equal(t, []snyk.Tag{{Key: "harbor-project", Value: "library"}}, job.Tags)
+	require.Equal(t, &snyk.ProjectAttributes{Environment: []string{"backend"}, Lifecycle: []string{"production"}}, job.Attributes)
+	require.Equal(t, "vulnerability1", job.Report.Vulnerabilities[0].ID)
+	require.Equal(t, "00-4bf92f3577b34da6a3ce929d0e0e4736-00f067aa0ba902b7-01", job.TraceContext["traceparent"])
+}
+
+func TestNewStore(t *testing.T) {
+	_, err := NewStore("invalid", "")
+	require.Error(t, err)
+
+	_, err = NewStore("bolt", "")
+	require.Error(t, err)
+}
@@ -0,0 +1,56 @@
+package scanner
+
+import (
+	"context"
+
+	"go.opentelemetry.io/otel"
+	"go.opentelemetry.io/otel/attribute"
+	"go.opentelemetry.io/otel/codes"
+	"go.opentelemetry.io/otel/propagation"
+	"go.opentelemetry.io/otel/trace"
+)
+
+const instrumentationName = "github.com/ricoberger/harbor-snyk-scanner/pkg/scanner"
+
+// scanJobAttributes returns the attributes for the spans of the given scan job, so that all spans of a scan can be
+// found by the id of the scan job or by the scanned artifact.
+func scanJobAttributes(job *ScanJob) []attribute.KeyValue {
+	return []attribute.KeyValue{
+		attribute.String("scan.id", job.ID),
+		attribute.String("artifact.repository", job.Artifact.Repository),
+		attribute.String("artifact.digest", job.Artifact.Digest),
+	}
+}
+
+// injectTraceContext saves the trace context of the given context in the scan job, so that the workers can continue
+// the trace of the scan request, which created the job.
+func injectTraceContext(ctx context.Context, job *ScanJob) {
+	carrier := propagation.MapCarrier{}
+	otel.GetTextMapPropagator().Inject(ctx, carrier)
+
+	if len(carrier) > 0 {
+		job.TraceContext = carrier
+	}
+}
+
+// startScanJobSpan starts the span for a single attempt of a worker to process the given scan job. The span is a child
+// of the span of the scan request, which created the job. This way the import, the polling of the import job and the
+// fetching of the issues are part of the same trace and the time between the spans is the time we waited for Snyk.
+func startScanJobSpan(ctx context.Context, job *ScanJob) (context.Context, trace.Span) {
+	ctx = otel.GetTextMapPropagator().Extract(ctx, propagation.MapCarrier(job.TraceContext))
+
+	return otel.Tracer(instrumentationName).Start(ctx, "ProcessScanJob",
+		trace.WithAttributes(scanJobAttributes(job)...),
+		trace.WithAttributes(attribute.Int("scan.attempt", job.Attempts+1)),
+	)
+}
+
+// endScanJobSpan adds the status of the given scan job to the span, before the span is ended.
+func endScanJobSpan(span trace.Span, job *ScanJob) {
+	span.SetAttributes(attribute.String("scan.status", string(job.Status)))
+	if job.Status == ScanJobStatusFailed {
+		span.SetStatus(codes.Error, job.Error)
+	}
+
+	span.End()
+}
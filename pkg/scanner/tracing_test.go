@@ -0,0 +1,94 @@
+package scanner
+
+import (
+	"bytes"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+
+	"github.com/ricoberger/harbor-snyk-scanner/pkg/harbor"
+
+	"github.com/stretchr/testify/require"
+	"go.opentelemetry.io/otel"
+	"go.opentelemetry.io/otel/attribute"
+	"go.opentelemetry.io/otel/propagation"
+	sdktrace "go.opentelemetry.io/otel/sdk/trace"
+	"go.opentelemetry.io/otel/sdk/trace/tracetest"
+	"go.opentelemetry.io/otel/trace"
+)
+
+func TestEndToEndScanTracing(t *testing.T) {
+	exporter := tracetest.NewInMemoryExporter()
+
+	defer func(previousProvider trace.TracerProvider, previousPropagator propagation.TextMapPropagator) {
+		otel.SetTracerProvider(previousProvider)
+		otel.SetTextMapPropagator(previousPropagator)
+	}(otel.GetTracerProvider(), otel.GetTextMapPropagator())
+
+	otel.SetTracerProvider(sdktrace.NewTracerProvider(sdktrace.WithSyncer(exporter)))
+	otel.SetTextMapPropagator(propagation.TraceContext{})
+
+	// The fixtures of the fake Snyk API only contain the image by its tag, so that the digest is only used for the
+	// attributes of the spans.
+	defer func(previous string) { imageTemplate = previous }(imageTemplate)
+	imageTemplate = "{{ .Repository }}:{{ .Tag }}"
+
+	_, client := newSnykServer(t)
+	server := newTestServer(t, client)
+
+	body, err := json.Marshal(harbor.ScanRequest{Artifact: harbor.Artifact{Repository: "library/nginx", Tag: "latest", Digest: "sha256:1234"}})
+	require.NoError(t, err)
+
+	req := httptest.NewRequest(http.MethodPost, "/api/scan", bytes.NewReader(body))
+	req.Header.Set("traceparent", "00-4bf92f3577b34da6a3ce929d0e0e4736-00f067aa0ba902b7-01")
+
+	w := httptest.NewRecorder()
+	server.server.Handler.ServeHTTP(w, req)
+	require.Equal(t, http.StatusAccepted, w.Code)
+
+	var scanResponse harbor.ScanResponse
+	require.NoError(t, json.NewDecoder(w.Body).Decode(&scanResponse))
+
+	require.Eventually(t, func() bool {
+		return getReport(t, server, scanResponse.ID).Code == http.StatusOK
+	}, 5*time.Second, 10*time.Millisecond)
+
+	scanJobID, err := getScanJobID(server.ids, scanResponse.ID)
+	require.NoError(t, err)
+
+	spansByName := make(map[string][]tracetest.SpanStub)
+	for _, span := range exporter.GetSpans() {
+		spansByName[span.Name] = append(spansByName[span.Name], span)
+	}
+
+	require.Len(t, spansByName["/api/scan"], 1)
+	scanSpan := spansByName["/api/scan"][0]
+	require.Equal(t, "4bf92f3577b34da6a3ce929d0e0e4736", scanSpan.SpanContext.TraceID().String())
+	require.Contains(t, scanSpan.Attributes, attribute.String("scan.id", scanJobID))
+	require.Contains(t, scanSpan.Attributes, attribute.String("artifact.repository", "library/nginx"))
+	require.Contains(t, scanSpan.Attributes, attribute.String("artifact.digest", "sha256:1234"))
+
+	// The import is done within the scan request, all other requests against Snyk are done by the workers, which
+	// continue the trace of the scan request.
+	require.NotEmpty(t, spansByName["Snyk POST import"])
+	require.Equal(t, scanSpan.SpanContext.SpanID(), spansByName["Snyk POST import"][0].Parent.SpanID())
+
+	require.NotEmpty(t, spansByName["ProcessScanJob"])
+	for _, span := range spansByName["ProcessScanJob"] {
+		require.Equal(t, scanSpan.SpanContext.TraceID(), span.SpanContext.TraceID())
+		require.Equal(t, scanSpan.SpanContext.SpanID(), span.Parent.SpanID())
+		require.Contains(t, span.Attributes, attribute.String("scan.id", scanJobID))
+	}
+
+	require.Len(t, spansByName["GetIssues"], 1)
+	require.NotEmpty(t, spansByName["GetProjectIssues"])
+	for _, span := range spansByName["GetProjectIssues"] {
+		require.Equal(t, spansByName["GetIssues"][0].SpanContext.SpanID(), span.Parent.SpanID())
+	}
+
+	reportSpans := spansByName["/api/scan/{scan_request_id}/report"]
+	require.NotEmpty(t, reportSpans)
+	require.Contains(t, reportSpans[len(reportSpans)-1].Attributes, attribute.String("scan.id", scanJobID))
+}
@@ -0,0 +1,331 @@
+package scanner
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"time"
+
+	"github.com/ricoberger/harbor-snyk-scanner/pkg/log"
+	"github.com/ricoberger/harbor-snyk-scanner/pkg/snyk"
+
+	"go.uber.org/zap"
+)
+
+// startWorkers starts the configured number of workers, the expiration of old scan jobs, the cleanup of projects and the
+// garbage collector. All pending scan jobs from the store are enqueued, so that scans which were started before a
+// restart of the scanner are continued.
+func (s *Server) startWorkers() {
+	s.wg.Add(workers + 3)
+
+	for i := 0; i < workers; i++ {
+		go s.runWorker()
+	}
+
+	go s.expireScanJobs()
+	go s.runCleanup()
+	go s.runGarbageCollector()
+
+	jobs, err := s.store.List(s.ctx)
+	if err != nil {
+		log.Error(nil, "Could not list scan jobs", zap.Error(err))
+		return
+	}
+
+	for _, job := range jobs {
+		if job.Status == ScanJobStatusPending {
+			s.recordScanStarted(job)
+			s.enqueueScanJob(job.ID, 0)
+		}
+	}
+}
+
+// enqueueScanJob adds the scan job with the given id to the queue after the given delay. The job is dropped when the
+// scanner is stopped before a worker picks it up. Because it is still pending in the store, it will be enqueued again
+// on the next start.
+func (s *Server) enqueueScanJob(id string, delay time.Duration) {
+	time.AfterFunc(delay, func() {
+		select {
+		case <-s.ctx.Done():
+		case s.queue <- id:
+		}
+	})
+}
+
+// runWorker processes the scan jobs from the queue until the scanner is stopped.
+func (s *Server) runWorker() {
+	defer s.wg.Done()
+
+	for {
+		select {
+		case <-s.ctx.Done():
+			return
+		case id := <-s.queue:
+			s.processScanJob(log.ContextWithValue(s.ctx, zap.String("scanJobID", id)), id)
+		}
+	}
+}
+
+// processScanJob polls Snyk for the result of the scan job with the given id. When the import job in Snyk is completed
+// we get the issues for all imported projects and save the report for the artifact in the store. When the result is not
+// available yet, the job is enqueued again after the configured poll interval.
+func (s *Server) processScanJob(ctx context.Context, id string) {
+	job, err := s.store.Get(ctx, id)
+	if err != nil {
+		log.Error(ctx, "Could not get scan job", zap.Error(err))
+		return
+	}
+
+	if job.Status != ScanJobStatusPending {
+		return
+	}
+
+	ctx, span := startScanJobSpan(ctx, job)
+	defer endScanJobSpan(span, job)
+
+	if s.failExpiredScanJob(ctx, job) {
+		return
+	}
+
+	snykClient, err := s.getSnykClient(job.Route)
+	if err != nil {
+		log.Error(ctx, "Could not get Snyk client", zap.Error(err), zap.String("route", job.Route))
+		s.updateScanJob(ctx, job, ScanJobStatusFailed, fmt.Sprintf("Could not get Snyk client: %s", err.Error()))
+		return
+	}
+
+	job.Attempts = job.Attempts + 1
+
+	if job.ProjectIDs == nil {
+		projectIDs, err := snykClient.GetProjectIDs(ctx, job.Image, job.Location)
+		if err != nil {
+			s.handleSnykError(ctx, job, "Could not get project ids from Snyk", err)
+			return
+		}
+
+		job.ProjectIDs = projectIDs
+		tagProjects(ctx, snykClient, job)
+	}
+
+	issues, err := snykClient.GetAggregatedIssues(ctx, job.ProjectIDs)
+	if err != nil {
+		s.handleSnykError(ctx, job, "Could not get aggregated issues from Snyk", err)
+		return
+	}
+
+	scanReport := createScanReportFromIssues(scannerData, job.Artifact, issues, licenseSeverities)
+	job.Report = &scanReport
+
+	log.Info(ctx, "Scan job completed", zap.String("image", job.Image), zap.Int("attempts", job.Attempts), zap.Int("vulnerabilities", len(scanReport.Vulnerabilities)))
+	s.updateScanJob(ctx, job, ScanJobStatusCompleted, "")
+}
+
+// handleSnykError decides based on the kind of the error returned by the Snyk client, if the scan job should be retried
+// or if it should be marked as failed. We only retry a job, when the error is temporary, e.g. when the import job is
+// not completed yet, when we are rate limited or when Snyk is not available. For all errors which will not go away by
+// retrying the request, e.g. an invalid API key, the job is marked as failed, so that Harbor shows the error right
+// away instead of waiting for the deadline.
+func (s *Server) handleSnykError(ctx context.Context, job *ScanJob, msg string, err error) {
+	fields := []zap.Field{zap.Error(err), zap.String("image", job.Image), zap.String("route", job.Route), zap.String("location", job.Location), zap.Strings("projectIDs", job.ProjectIDs)}
+
+	var rateLimitErr *snyk.RateLimitError
+
+	switch {
+	case errors.Is(err, snyk.ErrImportPending):
+		log.Debug(ctx, msg, fields...)
+		s.retryScanJob(ctx, job, pollInterval)
+	case errors.As(err, &rateLimitErr):
+		log.Warn(ctx, msg, fields...)
+
+		retryAfter := pollInterval
+		if rateLimitErr.RetryAfter > retryAfter {
+			retryAfter = rateLimitErr.RetryAfter
+		}
+
+		s.retryScanJob(ctx, job, retryAfter)
+	case errors.Is(err, snyk.ErrImportFailed):
+		log.Error(ctx, "Import of the image into Snyk failed", fields...)
+		s.updateScanJob(ctx, job, ScanJobStatusFailed, fmt.Sprintf("Import of the image into Snyk failed: %s", err.Error()))
+	case errors.Is(err, snyk.ErrUnauthorized):
+		log.Error(ctx, msg, fields...)
+		s.updateScanJob(ctx, job, ScanJobStatusFailed, fmt.Sprintf("%s, access was denied: %s", msg, err.Error()))
+	case errors.Is(err, snyk.ErrNotFound):
+		log.Error(ctx, msg, fields...)
+		s.updateScanJob(ctx, job, ScanJobStatusFailed, fmt.Sprintf("%s, import job or project was not found: %s", msg, err.Error()))
+	case errors.Is(err, snyk.ErrInvalidLocation):
+		log.Error(ctx, msg, fields...)
+		s.updateScanJob(ctx, job, ScanJobStatusFailed, fmt.Sprintf("%s: %s", msg, err.Error()))
+	default:
+		log.Error(ctx, msg, fields...)
+		s.retryScanJob(ctx, job, pollInterval)
+	}
+}
+
+// retryScanJob saves the given pending scan job and enqueues it again after the given delay. The time of the next
+// retry is also saved in the job, so that we can tell Harbor to not retry the request before this time.
+func (s *Server) retryScanJob(ctx context.Context, job *ScanJob, delay time.Duration) {
+	job.RetryAt = time.Now().Add(delay)
+	s.updateScanJob(ctx, job, ScanJobStatusPending, "")
+	s.enqueueScanJob(job.ID, delay)
+}
+
+// expireScanJobs removes all scan jobs from the store, which were created before the configured retention. The
+// function is called every minute until the scanner is stopped.
+func (s *Server) expireScanJobs() {
+	defer s.wg.Done()
+
+	ticker := time.NewTicker(1 * time.Minute)
+	defer ticker.Stop()
+
+	for {
+		select {
+		case <-s.ctx.Done():
+			return
+		case <-ticker.C:
+			jobs, err := s.store.List(s.ctx)
+			if err != nil {
+				log.Error(nil, "Could not list scan jobs", zap.Error(err))
+				continue
+			}
+
+			for _, job := range jobs {
+				if time.Since(job.CreatedAt) > storeRetention {
+					if err := s.store.Delete(s.ctx, job.ID); err != nil {
+						log.Error(nil, "Could not delete expired scan job", zap.Error(err), zap.String("scanJobID", job.ID))
+					} else {
+						log.Debug(nil, "Expired scan job was deleted", zap.String("scanJobID", job.ID))
+					}
+				}
+			}
+		}
+	}
+}
+
+// runCleanup removes the projects of finished scan jobs from Snyk every minute until the scanner is stopped. When the
+// ephemeral mode is disabled, the function returns immediately.
+func (s *Server) runCleanup() {
+	defer s.wg.Done()
+
+	if ephemeral == "" {
+		return
+	}
+
+	ticker := time.NewTicker(1 * time.Minute)
+	defer ticker.Stop()
+
+	for {
+		select {
+		case <-s.ctx.Done():
+			return
+		case <-ticker.C:
+			s.cleanupScanJobs(s.ctx)
+		}
+	}
+}
+
+// cleanupScanJobs removes the projects of all scan jobs from Snyk, where the grace period is over. Depending on the
+// ephemeral mode the projects are deleted or deactivated. In the dry run mode the projects are only logged. When the
+// projects of a job could not be removed, we try it again in the next run.
+func (s *Server) cleanupScanJobs(ctx context.Context) {
+	jobs, err := s.store.List(ctx)
+	if err != nil {
+		log.Error(ctx, "Could not list scan jobs", zap.Error(err))
+		return
+	}
+
+	now := time.Now()
+	removed := make(map[string]bool)
+
+	for _, job := range jobs {
+		if job.CleanedUp || job.CleanupAt.IsZero() || job.CleanupAt.After(now) {
+			continue
+		}
+
+		used, ok := usedProjects(jobs, job, now)
+		if !ok {
+			log.Debug(ctx, "Another scan job for the image is pending, projects are removed in the next run", zap.String("scanJobID", job.ID), zap.String("image", job.Image))
+			continue
+		}
+
+		if s.removeProjects(ctx, job, used, removed) {
+			job.CleanedUp = true
+			if err := s.store.Update(ctx, job); err != nil {
+				log.Error(ctx, "Could not update scan job", zap.Error(err), zap.String("scanJobID", job.ID))
+			}
+		}
+	}
+}
+
+// usedProjects returns the projects of all other scan jobs, which still need their projects, because the report was
+// not delivered yet or the grace period is not over. Snyk refreshes the existing projects, when the same image is
+// imported again, so that multiple scan jobs can contain the same projects. The second return value is false, when a
+// pending scan job for the same image doesn't know its projects yet, so that we can not decide which projects are used.
+func usedProjects(jobs []*ScanJob, job *ScanJob, now time.Time) (map[string]bool, bool) {
+	used := make(map[string]bool)
+
+	for _, other := range jobs {
+		if other.ID == job.ID || other.CleanedUp || (!other.CleanupAt.IsZero() && !other.CleanupAt.After(now)) {
+			continue
+		}
+
+		if other.ProjectIDs == nil && other.Status == ScanJobStatusPending && other.Route == job.Route && other.Image == job.Image {
+			return nil, false
+		}
+
+		for _, projectID := range other.ProjectIDs {
+			used[projectID] = true
+		}
+	}
+
+	return used, true
+}
+
+// removeProjects deletes or deactivates all projects of the given scan job in Snyk. It returns false, when a project
+// could not be removed or is still used by another scan job and the job should be retried. Projects which do not exist
+// anymore or which were already removed in the current run are ignored.
+func (s *Server) removeProjects(ctx context.Context, job *ScanJob, used, removed map[string]bool) bool {
+	fields := []zap.Field{zap.String("scanJobID", job.ID), zap.String("image", job.Image), zap.String("route", job.Route), zap.String("mode", ephemeral)}
+
+	snykClient, err := s.getSnykClient(job.Route)
+	if err != nil {
+		log.Error(ctx, "Could not get Snyk client, projects are not removed", append(fields, zap.Error(err))...)
+		return true
+	}
+
+	result := true
+
+	for _, projectID := range job.ProjectIDs {
+		if removed[projectID] {
+			continue
+		}
+
+		if used[projectID] {
+			log.Debug(ctx, "Project is still used by another scan job, project is removed in the next run", append(fields, zap.String("projectID", projectID))...)
+			result = false
+			continue
+		}
+
+		if ephemeralDryRun {
+			log.Info(ctx, "Dry run, project would be removed from Snyk", append(fields, zap.String("projectID", projectID))...)
+			removed[projectID] = true
+			continue
+		}
+
+		if ephemeral == "delete" {
+			err = snykClient.DeleteProject(ctx, projectID)
+		} else {
+			err = snykClient.DeactivateProject(ctx, projectID)
+		}
+
+		if err != nil && !errors.Is(err, snyk.ErrNotFound) {
+			log.Error(ctx, "Could not remove project from Snyk", append(fields, zap.String("projectID", projectID), zap.Error(err))...)
+			result = false
+			continue
+		}
+
+		removed[projectID] = true
+		log.Info(ctx, "Project was removed from Snyk", append(fields, zap.String("projectID", projectID))...)
+	}
+
+	return result
+}
@@ -0,0 +1,205 @@
+package snyk
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io/ioutil"
+	"net/http"
+	"strconv"
+	"strings"
+	"time"
+)
+
+var (
+	// ErrImportPending is returned when the import job in Snyk is not completed yet.
+	ErrImportPending = errors.New("import job is not completed yet")
+	// ErrImportFailed is returned when the import job in Snyk failed. The returned error is always an
+	// ImportFailedError, which contains the messages from Snyk.
+	ErrImportFailed = errors.New("import job failed")
+	// ErrUnauthorized is returned when the Snyk API rejects our API key (status code 401) or when the API key is not
+	// allowed to access the requested resource (status code 403).
+	ErrUnauthorized = errors.New("unauthorized")
+	// ErrNotFound is returned when the requested resource, e.g. the organisation, the integration, the import job or a
+	// project doesn't exist in Snyk.
+	ErrNotFound = errors.New("not found")
+	// ErrRateLimited is returned when we are rate limited by the Snyk API. The returned error is always a
+	// RateLimitError, which contains the duration after which the request can be retried.
+	ErrRateLimited = errors.New("rate limited")
+	// ErrUnavailable is returned when the Snyk API could not be reached or returned a server error.
+	ErrUnavailable = errors.New("unavailable")
+	// ErrInvalidLocation is returned when the location of an import job doesn't point to the configured Snyk API.
+	ErrInvalidLocation = errors.New("invalid location")
+)
+
+// APIError is returned when the Snyk API returns a status code which is not in the 2xx range. It contains the status
+// code and the decoded error response. The error can be compared with the ErrUnauthorized, ErrNotFound, ErrRateLimited
+// and ErrUnavailable errors via errors.Is.
+type APIError struct {
+	StatusCode int
+	Response   ErrorResponse
+}
+
+func (e *APIError) Error() string {
+	if e.Response.Message != "" {
+		return fmt.Sprintf("%d: %s", e.StatusCode, e.Response.Message)
+	}
+
+	return fmt.Sprintf("%d: %s", e.StatusCode, http.StatusText(e.StatusCode))
+}
+
+func (e *APIError) Is(target error) bool {
+	switch target {
+	case ErrUnauthorized:
+		return e.StatusCode == http.StatusUnauthorized || e.StatusCode == http.StatusForbidden
+	case ErrNotFound:
+		return e.StatusCode == http.StatusNotFound
+	case ErrRateLimited:
+		return e.StatusCode == http.StatusTooManyRequests
+	case ErrUnavailable:
+		return e.StatusCode >= 500
+	default:
+		return false
+	}
+}
+
+// RateLimitError is returned when the Snyk API returns a 429 status code. RetryAfter is the duration after which the
+// request can be retried. It is parsed from the Retry-After header of the response.
+type RateLimitError struct {
+	RetryAfter time.Duration
+	Err        *APIError
+}
+
+func (e *RateLimitError) Error() string {
+	return fmt.Sprintf("%s (retry after %s)", e.Err.Error(), e.RetryAfter)
+}
+
+func (e *RateLimitError) Unwrap() error {
+	return e.Err
+}
+
+// NetworkError is returned when the request to the Snyk API failed, before we got a response. It can be compared with
+// the ErrUnavailable error via errors.Is.
+type NetworkError struct {
+	Err error
+}
+
+func (e *NetworkError) Error() string {
+	return e.Err.Error()
+}
+
+func (e *NetworkError) Unwrap() error {
+	return e.Err
+}
+
+func (e *NetworkError) Is(target error) bool {
+	return target == ErrUnavailable
+}
+
+// ImportFailedError is returned when the import job in Snyk failed or when no project could be created for the
+// imported image. It contains the status of the import job and the messages returned by Snyk for the failed projects,
+// e.g. when the image could not be found or when the operating system of the image is not supported.
+type ImportFailedError struct {
+	Status   string
+	Messages []string
+}
+
+func (e *ImportFailedError) Error() string {
+	if len(e.Messages) == 0 {
+		return fmt.Sprintf("import job %s", e.Status)
+	}
+
+	return fmt.Sprintf("import job %s: %s", e.Status, strings.Join(e.Messages, "; "))
+}
+
+func (e *ImportFailedError) Is(target error) bool {
+	return target == ErrImportFailed
+}
+
+// ProjectError is the error for a single project, when we could not get the issues for this project.
+type ProjectError struct {
+	ProjectID string
+	Err       error
+}
+
+func (e *ProjectError) Error() string {
+	return fmt.Sprintf("project %s: %s", e.ProjectID, e.Err.Error())
+}
+
+func (e *ProjectError) Unwrap() error {
+	return e.Err
+}
+
+// ProjectErrors is returned by GetAggregatedIssues, when we could not get the issues for one or more projects. It
+// contains the error for each failed project. Via errors.Is and errors.As all the contained errors can be checked.
+type ProjectErrors []*ProjectError
+
+func (e ProjectErrors) Error() string {
+	var messages []string
+	for _, err := range e {
+		messages = append(messages, err.Error())
+	}
+
+	return strings.Join(messages, "; ")
+}
+
+func (e ProjectErrors) Is(target error) bool {
+	for _, err := range e {
+		if errors.Is(err, target) {
+			return true
+		}
+	}
+
+	return false
+}
+
+func (e ProjectErrors) As(target interface{}) bool {
+	for _, err := range e {
+		if errors.As(err, target) {
+			return true
+		}
+	}
+
+	return false
+}
+
+// newAPIError returns the error for the given response. The body of the response is decoded as ErrorResponse. If the
+// body can not be decoded, the body is used as message, so that we do not lose the status code of the response.
+func newAPIError(resp *http.Response) error {
+	apiErr := &APIError{StatusCode: resp.StatusCode}
+
+	body, err := ioutil.ReadAll(resp.Body)
+	if err == nil && json.Unmarshal(body, &apiErr.Response) != nil {
+		apiErr.Response.Message = strings.TrimSpace(string(body))
+	}
+
+	// The REST API returns the errors in the JSON:API format, so that we have to use the detail of the first error as
+	// message.
+	if apiErr.Response.Message == "" && len(apiErr.Response.Errors) > 0 {
+		apiErr.Response.Message = apiErr.Response.Errors[0].Detail
+	}
+
+	if resp.StatusCode == http.StatusTooManyRequests {
+		return &RateLimitError{RetryAfter: parseRetryAfter(resp.Header.Get("Retry-After"), time.Now()), Err: apiErr}
+	}
+
+	return apiErr
+}
+
+// parseRetryAfter parses the value of a Retry-After header, which can be the number of seconds or a http date. If the
+// value is empty or invalid we return a default of 60 seconds.
+func parseRetryAfter(value string, now time.Time) time.Duration {
+	if seconds, err := strconv.Atoi(value); err == nil && seconds >= 0 {
+		return time.Duration(seconds) * time.Second
+	}
+
+	if date, err := http.ParseTime(value); err == nil {
+		if retryAfter := date.Sub(now); retryAfter > 0 {
+			return retryAfter
+		}
+
+		return 0
+	}
+
+	return 60 * time.Second
+}
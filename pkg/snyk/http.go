@@ -0,0 +1,108 @@
+package snyk
+
+import (
+	"crypto/tls"
+	"crypto/x509"
+	"fmt"
+	"io/ioutil"
+	"net/http"
+	"net/url"
+	"time"
+
+	"golang.org/x/net/http/httpproxy"
+)
+
+// transportOptions are the options for the HTTP transport, which is used for all requests against the Snyk API.
+type transportOptions struct {
+	ProxyURL            string
+	NoProxy             string
+	CAFile              string
+	ClientCertFile      string
+	ClientKeyFile       string
+	TLSMinVersion       string
+	MaxIdleConns        int
+	MaxIdleConnsPerHost int
+	MaxConnsPerHost     int
+	IdleConnTimeout     time.Duration
+}
+
+// tlsVersions maps the supported values for the minimum TLS version to the constants of the tls package.
+var tlsVersions = map[string]uint16{
+	"1.0": tls.VersionTLS10,
+	"1.1": tls.VersionTLS11,
+	"1.2": tls.VersionTLS12,
+	"1.3": tls.VersionTLS13,
+}
+
+// newHTTPTransport returns the HTTP transport for the given options. The proxy is configured via the HTTP_PROXY,
+// HTTPS_PROXY and NO_PROXY environment variables like for the default transport of Go, where the proxy url and the
+// hosts which should not be requested via the proxy can be overwritten by the options. The CA
+// bundle is added to the system certificates, so that the Snyk API and a broker with a private CA can be used at the
+// same time.
+func newHTTPTransport(options transportOptions) (*http.Transport, error) {
+	transport := http.DefaultTransport.(*http.Transport).Clone()
+	transport.MaxIdleConns = options.MaxIdleConns
+	transport.MaxIdleConnsPerHost = options.MaxIdleConnsPerHost
+	transport.MaxConnsPerHost = options.MaxConnsPerHost
+	transport.IdleConnTimeout = options.IdleConnTimeout
+
+	proxyConfig := httpproxy.FromEnvironment()
+
+	if options.ProxyURL != "" {
+		if _, err := url.Parse(options.ProxyURL); err != nil {
+			return nil, fmt.Errorf("invalid proxy url: %w", err)
+		}
+
+		proxyConfig.HTTPProxy = options.ProxyURL
+		proxyConfig.HTTPSProxy = options.ProxyURL
+	}
+
+	if options.NoProxy != "" {
+		proxyConfig.NoProxy = options.NoProxy
+	}
+
+	proxyFunc := proxyConfig.ProxyFunc()
+	transport.Proxy = func(req *http.Request) (*url.URL, error) {
+		return proxyFunc(req.URL)
+	}
+
+	tlsVersion, ok := tlsVersions[options.TLSMinVersion]
+	if !ok {
+		return nil, fmt.Errorf("invalid minimum TLS version %q, must be 1.0, 1.1, 1.2 or 1.3", options.TLSMinVersion)
+	}
+
+	tlsConfig := &tls.Config{
+		MinVersion: tlsVersion,
+	}
+
+	if options.CAFile != "" {
+		rootCAs, err := x509.SystemCertPool()
+		if err != nil || rootCAs == nil {
+			rootCAs = x509.NewCertPool()
+		}
+
+		ca, err := ioutil.ReadFile(options.CAFile)
+		if err != nil {
+			return nil, fmt.Errorf("could not read CA file: %w", err)
+		}
+
+		if !rootCAs.AppendCertsFromPEM(ca) {
+			return nil, fmt.Errorf("could not add CA file, no valid certificates found")
+		}
+
+		tlsConfig.RootCAs = rootCAs
+	}
+
+	if options.ClientCertFile != "" || options.ClientKeyFile != "" {
+		cert, err := tls.LoadX509KeyPair(options.ClientCertFile, options.ClientKeyFile)
+		if err != nil {
+			return nil, fmt.Errorf("could not load client certificate: %w", err)
+		}
+
+		tlsConfig.Certificates = []tls.Certificate{cert}
+	}
+
+	transport.TLSClientConfig = tlsConfig
+
+	return transport, nil
+}
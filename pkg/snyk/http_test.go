@@ -0,0 +1,215 @@
+package snyk
+
+import (
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/rand"
+	"crypto/tls"
+	"crypto/x509"
+	"crypto/x509/pkix"
+	"encoding/pem"
+	"io/ioutil"
+	"math/big"
+	"net/http"
+	"net/http/httptest"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/require"
+)
+
+// newCertificate creates a new certificate, which is signed by the given parent. If the parent is nil, a self signed
+// CA certificate is created.
+func newCertificate(t *testing.T, name string, parent *x509.Certificate, parentKey *ecdsa.PrivateKey) (*x509.Certificate, *ecdsa.PrivateKey, []byte, []byte) {
+	key, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	require.NoError(t, err)
+
+	template := &x509.Certificate{
+		SerialNumber: big.NewInt(time.Now().UnixNano()),
+		Subject:      pkix.Name{CommonName: name},
+		NotBefore:    time.Now().Add(-1 * time.Hour),
+		NotAfter:     time.Now().Add(1 * time.Hour),
+		KeyUsage:     x509.KeyUsageDigitalSignature | x509.KeyUsageCertSign,
+		ExtKeyUsage:  []x509.ExtKeyUsage{x509.ExtKeyUsageClientAuth},
+	}
+
+	if parent == nil {
+		template.IsCA = true
+		template.BasicConstraintsValid = true
+		parent = template
+		parentKey = key
+	}
+
+	der, err := x509.CreateCertificate(rand.Reader, template, parent, &key.PublicKey, parentKey)
+	require.NoError(t, err)
+
+	cert, err := x509.ParseCertificate(der)
+	require.NoError(t, err)
+
+	keyDer, err := x509.MarshalECPrivateKey(key)
+	require.NoError(t, err)
+
+	return cert, key, pem.EncodeToMemory(&pem.Block{Type: "CERTIFICATE", Bytes: der}), pem.EncodeToMemory(&pem.Block{Type: "EC PRIVATE KEY", Bytes: keyDer})
+}
+
+func writeFile(t *testing.T, name string, data []byte) string {
+	file := filepath.Join(t.TempDir(), name)
+	require.NoError(t, ioutil.WriteFile(file, data, 0600))
+	return file
+}
+
+func newTestTransport(t *testing.T, options transportOptions) *http.Client {
+	if options.TLSMinVersion == "" {
+		options.TLSMinVersion = "1.2"
+	}
+
+	transport, err := newHTTPTransport(options)
+	require.NoError(t, err)
+
+	return &http.Client{Transport: transport}
+}
+
+func TestHTTPTransportCA(t *testing.T) {
+	ts := httptest.NewTLSServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {}))
+	defer ts.Close()
+
+	_, err := newTestTransport(t, transportOptions{}).Get(ts.URL)
+	require.Error(t, err)
+
+	caFile := writeFile(t, "ca.pem", pem.EncodeToMemory(&pem.Block{Type: "CERTIFICATE", Bytes: ts.Certificate().Raw}))
+
+	resp, err := newTestTransport(t, transportOptions{CAFile: caFile}).Get(ts.URL)
+	require.NoError(t, err)
+	resp.Body.Close()
+	require.Equal(t, http.StatusOK, resp.StatusCode)
+
+	_, err = newHTTPTransport(transportOptions{CAFile: writeFile(t, "invalid.pem", []byte("invalid")), TLSMinVersion: "1.2"})
+	require.EqualError(t, err, "could not add CA file, no valid certificates found")
+}
+
+func TestHTTPTransportClientCertificate(t *testing.T) {
+	ca, caKey, _, _ := newCertificate(t, "ca", nil, nil)
+	_, _, clientCert, clientKey := newCertificate(t, "client", ca, caKey)
+
+	clientCAs := x509.NewCertPool()
+	clientCAs.AddCert(ca)
+
+	ts := httptest.NewUnstartedServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		require.Len(t, r.TLS.PeerCertificates, 1)
+		require.Equal(t, "client", r.TLS.PeerCertificates[0].Subject.CommonName)
+	}))
+	ts.TLS = &tls.Config{ClientAuth: tls.RequireAndVerifyClientCert, ClientCAs: clientCAs}
+	ts.StartTLS()
+	defer ts.Close()
+
+	caFile := writeFile(t, "ca.pem", pem.EncodeToMemory(&pem.Block{Type: "CERTIFICATE", Bytes: ts.Certificate().Raw}))
+
+	_, err := newTestTransport(t, transportOptions{CAFile: caFile}).Get(ts.URL)
+	require.Error(t, err)
+
+	resp, err := newTestTransport(t, transportOptions{CAFile: caFile, ClientCertFile: writeFile(t, "client.pem", clientCert), ClientKeyFile: writeFile(t, "client-key.pem", clientKey)}).Get(ts.URL)
+	require.NoError(t, err)
+	resp.Body.Close()
+	require.Equal(t, http.StatusOK, resp.StatusCode)
+
+	_, err = newHTTPTransport(transportOptions{ClientCertFile: writeFile(t, "client.pem", clientCert), TLSMinVersion: "1.2"})
+	require.Error(t, err)
+}
+
+func TestHTTPTransportTLSMinVersion(t *testing.T) {
+	ts := httptest.NewUnstartedServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {}))
+	ts.TLS = &tls.Config{MaxVersion: tls.VersionTLS12}
+	ts.StartTLS()
+	defer ts.Close()
+
+	caFile := writeFile(t, "ca.pem", pem.EncodeToMemory(&pem.Block{Type: "CERTIFICATE", Bytes: ts.Certificate().Raw}))
+
+	resp, err := newTestTransport(t, transportOptions{CAFile: caFile, TLSMinVersion: "1.2"}).Get(ts.URL)
+	require.NoError(t, err)
+	resp.Body.Close()
+
+	_, err = newTestTransport(t, transportOptions{CAFile: caFile, TLSMinVersion: "1.3"}).Get(ts.URL)
+	require.Error(t, err)
+
+	_, err = newHTTPTransport(transportOptions{TLSMinVersion: "1.4"})
+	require.EqualError(t, err, "invalid minimum TLS version \"1.4\", must be 1.0, 1.1, 1.2 or 1.3")
+}
+
+func TestHTTPTransportProxy(t *testing.T) {
+	var proxied []string
+
+	proxy := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		proxied = append(proxied, r.URL.String())
+	}))
+	defer proxy.Close()
+
+	transport, err := newHTTPTransport(transportOptions{ProxyURL: proxy.URL, NoProxy: ".internal.example.com", TLSMinVersion: "1.2"})
+	require.NoError(t, err)
+
+	for _, tt := range []struct {
+		url   string
+		proxy string
+	}{
+		{url: "https://snyk.io/api/v1", proxy: proxy.URL},
+		{url: "http://snyk.io/api/v1", proxy: proxy.URL},
+		{url: "https://broker.internal.example.com/api/v1", proxy: ""},
+	} {
+		req, err := http.NewRequest(http.MethodGet, tt.url, nil)
+		require.NoError(t, err)
+
+		proxyURL, err := transport.Proxy(req)
+		require.NoError(t, err)
+
+		if tt.proxy == "" {
+			require.Nil(t, proxyURL, tt.url)
+		} else {
+			require.Equal(t, tt.proxy, proxyURL.String(), tt.url)
+		}
+	}
+
+	resp, err := (&http.Client{Transport: transport}).Get("http://snyk.io/api/v1")
+	require.NoError(t, err)
+	resp.Body.Close()
+	require.Equal(t, []string{"http://snyk.io/api/v1"}, proxied)
+}
+
+func TestHTTPTransportEnvironmentProxy(t *testing.T) {
+	t.Setenv("HTTP_PROXY", "http://proxy.example.com:3128")
+	t.Setenv("HTTPS_PROXY", "http://proxy.example.com:3128")
+	t.Setenv("NO_PROXY", "other.example.com")
+
+	transport, err := newHTTPTransport(transportOptions{NoProxy: ".internal.example.com", TLSMinVersion: "1.2"})
+	require.NoError(t, err)
+
+	for _, tt := range []struct {
+		url   string
+		proxy string
+	}{
+		{url: "https://snyk.io/api/v1", proxy: "http://proxy.example.com:3128"},
+		{url: "https://other.example.com/api/v1", proxy: "http://proxy.example.com:3128"},
+		{url: "https://broker.internal.example.com/api/v1", proxy: ""},
+	} {
+		req, err := http.NewRequest(http.MethodGet, tt.url, nil)
+		require.NoError(t, err)
+
+		proxyURL, err := transport.Proxy(req)
+		require.NoError(t, err)
+
+		if tt.proxy == "" {
+			require.Nil(t, proxyURL, tt.url)
+		} else {
+			require.Equal(t, tt.proxy, proxyURL.String(), tt.url)
+		}
+	}
+}
+
+func TestHTTPTransportConnectionPool(t *testing.T) {
+	transport, err := newHTTPTransport(transportOptions{TLSMinVersion: "1.2", MaxIdleConns: 50, MaxIdleConnsPerHost: 5, MaxConnsPerHost: 20, IdleConnTimeout: 30 * time.Second})
+	require.NoError(t, err)
+	require.Equal(t, 50, transport.MaxIdleConns)
+	require.Equal(t, 5, transport.MaxIdleConnsPerHost)
+	require.Equal(t, 20, transport.MaxConnsPerHost)
+	require.Equal(t, 30*time.Second, transport.IdleConnTimeout)
+	require.Equal(t, uint16(tls.VersionTLS12), transport.TLSClientConfig.MinVersion)
+}
@@ -0,0 +1,167 @@
+package snyk
+
+import (
+	"context"
+	"errors"
+	"net"
+	"net/http"
+	"regexp"
+	"strconv"
+	"time"
+
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/prometheus/client_golang/prometheus/promauto"
+)
+
+var (
+	requestsMetric = promauto.NewCounterVec(prometheus.CounterOpts{
+		Namespace: "harbor_snyk_scanner",
+		Name:      "snyk_requests_total",
+		Help:      "Number of requests against the Snyk API, partitioned by endpoint, method and status code. Retries are counted as separate requests.",
+	}, []string{"endpoint", "method", "status_code"})
+
+	requestDurationMetric = promauto.NewHistogramVec(prometheus.HistogramOpts{
+		Namespace: "harbor_snyk_scanner",
+		Name:      "snyk_request_duration_seconds",
+		Help:      "Latency of requests against the Snyk API until the response header was received, partitioned by endpoint and method.",
+		Buckets:   []float64{0.05, 0.1, 0.25, 0.5, 1, 2.5, 5, 10, 30, 60},
+	}, []string{"endpoint", "method"})
+
+	requestErrorsMetric = promauto.NewCounterVec(prometheus.CounterOpts{
+		Namespace: "harbor_snyk_scanner",
+		Name:      "snyk_request_errors_total",
+		Help:      "Number of failed requests against the Snyk API, partitioned by endpoint and error class.",
+	}, []string{"endpoint", "class"})
+
+	rateLimitLimitMetric = promauto.NewGaugeVec(prometheus.GaugeOpts{
+		Namespace: "harbor_snyk_scanner",
+		Name:      "snyk_rate_limit_limit",
+		Help:      "The rate limit of the Snyk API from the X-RateLimit-Limit header of the last response, partitioned by organisation.",
+	}, []string{"organisation"})
+
+	rateLimitRemainingMetric = promauto.NewGaugeVec(prometheus.GaugeOpts{
+		Namespace: "harbor_snyk_scanner",
+		Name:      "snyk_rate_limit_remaining",
+		Help:      "The remaining requests of the rate limit of the Snyk API from the X-RateLimit-Remaining header of the last response, partitioned by organisation.",
+	}, []string{"organisation"})
+
+	importJobsMetric = promauto.NewCounterVec(prometheus.CounterOpts{
+		Namespace: "harbor_snyk_scanner",
+		Name:      "snyk_import_jobs_total",
+		Help:      "Number of finished import jobs in Snyk, partitioned by the final status. Completed jobs without a project for the image are counted as \"no_projects\".",
+	}, []string{"status"})
+
+	// endpoints maps the paths of the Snyk API to the endpoint label of the metrics. The ids in the paths are replaced, so
+	// that the number of label values is bounded.
+	endpoints = []struct {
+		name  string
+		regex *regexp.Regexp
+	}{
+		{name: "import", regex: regexp.MustCompile(`/api/v1/org/[^/]+/integrations/[^/]+/import$`)},
+		{name: "import-job", regex: regexp.MustCompile(`/api/v1/org/[^/]+/integrations/[^/]+/import/[^/]+$`)},
+		{name: "aggregated-issues", regex: regexp.MustCompile(`/api/v1/org/[^/]+/project/[^/]+/aggregated-issues$`)},
+		{name: "project-deactivate", regex: regexp.MustCompile(`/api/v1/org/[^/]+/project/[^/]+/deactivate$`)},
+		{name: "project-tags-remove", regex: regexp.MustCompile(`/api/v1/org/[^/]+/project/[^/]+/tags/remove$`)},
+		{name: "project-tags", regex: regexp.MustCompile(`/api/v1/org/[^/]+/project/[^/]+/tags$`)},
+		{name: "project-attributes", regex: regexp.MustCompile(`/api/v1/org/[^/]+/project/[^/]+/attributes$`)},
+		{name: "project", regex: regexp.MustCompile(`/api/v1/org/[^/]+/project/[^/]+$`)},
+		{name: "rest-projects", regex: regexp.MustCompile(`/rest/orgs/[^/]+/projects$`)},
+		{name: "rest-issues", regex: regexp.MustCompile(`/rest/orgs/[^/]+/issues$`)},
+	}
+
+	organisationRegex = regexp.MustCompile(`/(?:api/v1/org|rest/orgs)/([^/]+)/`)
+)
+
+// getEndpoint returns the name of the endpoint for the given path, which is used as label for the metrics.
+func getEndpoint(path string) string {
+	for _, endpoint := range endpoints {
+		if endpoint.regex.MatchString(path) {
+			return endpoint.name
+		}
+	}
+
+	return "other"
+}
+
+// getErrorClass returns the class of the error for a failed request. If the request didn't fail an empty string is
+// returned.
+func getErrorClass(resp *http.Response, err error) string {
+	if err != nil {
+		var netErr net.Error
+		if errors.Is(err, context.DeadlineExceeded) || (errors.As(err, &netErr) && netErr.Timeout()) {
+			return "timeout"
+		}
+
+		if errors.Is(err, context.Canceled) {
+			return "canceled"
+		}
+
+		return "network"
+	}
+
+	switch {
+	case resp.StatusCode == http.StatusTooManyRequests:
+		return "rate_limited"
+	case resp.StatusCode == http.StatusUnauthorized || resp.StatusCode == http.StatusForbidden:
+		return "unauthorized"
+	case resp.StatusCode == http.StatusNotFound:
+		return "not_found"
+	case resp.StatusCode >= 500:
+		return "server_error"
+	case resp.StatusCode >= 400:
+		return "client_error"
+	default:
+		return ""
+	}
+}
+
+// metricsTransport is a http.RoundTripper, which records the metrics for all requests against the Snyk API. It is
+// used below the retryTransport, so that each retry is recorded as separate request.
+type metricsTransport struct {
+	next http.RoundTripper
+}
+
+func (t *metricsTransport) RoundTrip(req *http.Request) (*http.Response, error) {
+	endpoint := getEndpoint(req.URL.Path)
+	start := time.Now()
+
+	resp, err := t.next.RoundTrip(req)
+
+	requestDurationMetric.WithLabelValues(endpoint, req.Method).Observe(time.Since(start).Seconds())
+
+	statusCode := "error"
+	if err == nil {
+		statusCode = strconv.Itoa(resp.StatusCode)
+		recordRateLimit(req.URL.Path, resp.Header)
+	}
+	requestsMetric.WithLabelValues(endpoint, req.Method, statusCode).Inc()
+
+	if class := getErrorClass(resp, err); class != "" {
+		requestErrorsMetric.WithLabelValues(endpoint, class).Inc()
+	}
+
+	return resp, err
+}
+
+// recordRateLimit sets the rate limit metrics for the organisation of the given path, when the response contains the
+// rate limit headers.
+func recordRateLimit(path string, header http.Header) {
+	matches := organisationRegex.FindStringSubmatch(path)
+	if matches == nil {
+		return
+	}
+
+	if limit, err := strconv.ParseFloat(header.Get("X-RateLimit-Limit"), 64); err == nil {
+		rateLimitLimitMetric.WithLabelValues(matches[1]).Set(limit)
+	}
+
+	if remaining, err := strconv.ParseFloat(header.Get("X-RateLimit-Remaining"), 64); err == nil {
+		rateLimitRemainingMetric.WithLabelValues(matches[1]).Set(remaining)
+	}
+}
+
+// recordImportJob increases the counter for import jobs with the given final status. The status is only recorded,
+// when GetProjectIDs returns the final result for a job, so that each job is only counted once.
+func recordImportJob(status string) {
+	importJobsMetric.WithLabelValues(status).Inc()
+}
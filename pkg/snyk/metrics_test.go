@@ -0,0 +1,79 @@
+package snyk
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	"github.com/prometheus/client_golang/prometheus/testutil"
+	"github.com/stretchr/testify/require"
+)
+
+func TestGetEndpoint(t *testing.T) {
+	for _, tt := range []struct {
+		path     string
+		endpoint string
+	}{
+		{path: "/api/v1/org/org/integrations/integration/import", endpoint: "import"},
+		{path: "/api/v1/org/org/integrations/integration/import/job", endpoint: "import-job"},
+		{path: "/api/v1/org/org/project/project/aggregated-issues", endpoint: "aggregated-issues"},
+		{path: "/api/v1/org/org/project/project/deactivate", endpoint: "project-deactivate"},
+		{path: "/api/v1/org/org/project/project/tags", endpoint: "project-tags"},
+		{path: "/api/v1/org/org/project/project/tags/remove", endpoint: "project-tags-remove"},
+		{path: "/api/v1/org/org/project/project/attributes", endpoint: "project-attributes"},
+		{path: "/api/v1/org/org/project/project", endpoint: "project"},
+		{path: "/rest/orgs/org/projects", endpoint: "rest-projects"},
+		{path: "/rest/orgs/org/issues", endpoint: "rest-issues"},
+		{path: "/api/v1/user/me", endpoint: "other"},
+	} {
+		require.Equal(t, tt.endpoint, getEndpoint(tt.path), tt.path)
+	}
+}
+
+func TestGetErrorClass(t *testing.T) {
+	require.Equal(t, "", getErrorClass(&http.Response{StatusCode: http.StatusOK}, nil))
+	require.Equal(t, "rate_limited", getErrorClass(&http.Response{StatusCode: http.StatusTooManyRequests}, nil))
+	require.Equal(t, "unauthorized", getErrorClass(&http.Response{StatusCode: http.StatusForbidden}, nil))
+	require.Equal(t, "not_found", getErrorClass(&http.Response{StatusCode: http.StatusNotFound}, nil))
+	require.Equal(t, "client_error", getErrorClass(&http.Response{StatusCode: http.StatusBadRequest}, nil))
+	require.Equal(t, "server_error", getErrorClass(&http.Response{StatusCode: http.StatusBadGateway}, nil))
+	require.Equal(t, "timeout", getErrorClass(nil, fmt.Errorf("request failed: %w", context.DeadlineExceeded)))
+	require.Equal(t, "canceled", getErrorClass(nil, context.Canceled))
+	require.Equal(t, "network", getErrorClass(nil, errors.New("connection refused")))
+}
+
+func TestMetricsTransport(t *testing.T) {
+	ts := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set("X-RateLimit-Limit", "2000")
+		w.Header().Set("X-RateLimit-Remaining", "1999")
+
+		if r.URL.Path == "/api/v1/org/metrics-org/project/missing/aggregated-issues" {
+			w.WriteHeader(http.StatusNotFound)
+			return
+		}
+
+		fmt.Fprint(w, `{"status": "failed", "logs": []}`)
+	}))
+	defer ts.Close()
+
+	c := &client{apiKey: "apikey", baseURL: ts.URL, organisationID: "metrics-org", integrationID: "integration", httpClient: &http.Client{Transport: &metricsTransport{next: http.DefaultTransport}}}
+
+	requests := testutil.ToFloat64(requestsMetric.WithLabelValues("import-job", http.MethodGet, "200"))
+	notFound := testutil.ToFloat64(requestErrorsMetric.WithLabelValues("aggregated-issues", "not_found"))
+	failedJobs := testutil.ToFloat64(importJobsMetric.WithLabelValues("failed"))
+
+	_, err := c.GetProjectIDs(context.Background(), "library/nginx:latest", ts.URL+"/api/v1/org/metrics-org/integrations/integration/import/job")
+	require.ErrorIs(t, err, ErrImportFailed)
+
+	_, err = c.getAggregatedIssues(context.Background(), "missing")
+	require.ErrorIs(t, err, ErrNotFound)
+
+	require.Equal(t, requests+1, testutil.ToFloat64(requestsMetric.WithLabelValues("import-job", http.MethodGet, "200")))
+	require.Equal(t, notFound+1, testutil.ToFloat64(requestErrorsMetric.WithLabelValues("aggregated-issues", "not_found")))
+	require.Equal(t, failedJobs+1, testutil.ToFloat64(importJobsMetric.WithLabelValues("failed")))
+	require.Equal(t, 2000.0, testutil.ToFloat64(rateLimitLimitMetric.WithLabelValues("metrics-org")))
+	require.Equal(t, 1999.0, testutil.ToFloat64(rateLimitRemainingMetric.WithLabelValues("metrics-org")))
+}
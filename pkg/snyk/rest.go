@@ -0,0 +1,348 @@
+package snyk
+
+import (
+	"context"
+	"fmt"
+	"net/http"
+	"net/url"
+	"strings"
+	"time"
+)
+
+// restURL returns the url for the given path in the REST API of Snyk. The configured version is always added to the
+// query parameters, because it is required for all requests against the REST API.
+func (c *client) restURL(path string, query url.Values) string {
+	if query == nil {
+		query = url.Values{}
+	}
+
+	query.Set("version", c.restVersion)
+
+	return fmt.Sprintf("%s/rest%s?%s", c.baseURL, path, query.Encode())
+}
+
+// nextURL returns the absolute url for the next link of a paginated response from the REST API. The link is only
+// accepted, when it points to the configured Snyk API, so that we do not send our API key to another host.
+func (c *client) nextURL(next string) (string, error) {
+	base, err := url.Parse(c.baseURL)
+	if err != nil {
+		return "", err
+	}
+
+	u, err := url.Parse(next)
+	if err != nil {
+		return "", err
+	}
+
+	if !u.IsAbs() {
+		if !strings.HasPrefix(u.Path, "/rest/") {
+			u.Path = "/rest" + u.Path
+		}
+
+		u = &url.URL{Scheme: base.Scheme, Host: base.Host, Path: strings.TrimSuffix(base.Path, "/") + u.Path, RawQuery: u.RawQuery}
+	}
+
+	if u.Scheme != base.Scheme || u.Host != base.Host || u.User != nil {
+		return "", fmt.Errorf("next link %q is not allowed", next)
+	}
+
+	return u.String(), nil
+}
+
+// doREST sends the given request to the REST API of Snyk and decodes the body of the response into v.
+func (c *client) doREST(req *http.Request, v interface{}) (*http.Response, error) {
+	req.Header.Set("Authorization", fmt.Sprintf("token %s", c.apiKey))
+	req.Header.Set("Accept", "application/vnd.api+json")
+	if req.Body != nil {
+		req.Header.Set("Content-Type", "application/vnd.api+json")
+	}
+
+	return c.send(req, v)
+}
+
+// listProjects returns all projects of the organisation, which are matching the given query. The REST API uses cursor
+// based pagination, so that we have to follow the next link until all pages are fetched.
+func (c *client) listProjects(ctx context.Context, query url.Values) ([]Project, error) {
+	var projects []Project
+
+	query.Set("limit", "100")
+	query.Set("meta.latest_issue_counts", "true")
+	next := c.restURL(fmt.Sprintf("/orgs/%s/projects", c.organisationID), query)
+
+	for next != "" {
+		req, err := http.NewRequestWithContext(ctx, http.MethodGet, next, nil)
+		if err != nil {
+			return nil, err
+		}
+
+		var projectsResponse RESTProjectsResponse
+
+		if _, err := c.doREST(req, &projectsResponse); err != nil {
+			return nil, err
+		}
+
+		for _, data := range projectsResponse.Data {
+			projects = append(projects, Project{
+				ID:         data.ID,
+				Name:       data.Attributes.Name,
+				Origin:     data.Attributes.Origin,
+				Tags:       data.Attributes.Tags,
+				Created:    data.Attributes.Created,
+				LastTested: data.Meta.LatestIssueCounts.UpdatedAt,
+			})
+		}
+
+		next = ""
+		if projectsResponse.Links.Next != "" {
+			next, err = c.nextURL(projectsResponse.Links.Next)
+			if err != nil {
+				return nil, err
+			}
+		}
+	}
+
+	return projects, nil
+}
+
+// ListProjects returns all projects of the organisation. When origins are given, only the projects which were created
+// via an integration with one of these origins (e.g. "harbor-cr") are returned.
+func (c *client) ListProjects(ctx context.Context, origins []string) ([]Project, error) {
+	query := url.Values{}
+	if len(origins) > 0 {
+		query.Set("origins", strings.Join(origins, ","))
+	}
+
+	return c.listProjects(ctx, query)
+}
+
+// FindProjects returns the ids of all projects for the given image, which were tested within the given max age. A
+// project belongs to the image, when the name of the project is the image (or starts with the image for application
+// projects within the image), or when the project has a "digest" tag with the given digest. The projects are always
+// listed via the REST API, also for the v1 client, because the v1 API doesn't allow us to filter the projects of an
+// organisation.
+func (c *client) FindProjects(ctx context.Context, image, digest string, maxAge time.Duration) ([]string, error) {
+	projects, err := c.listProjects(ctx, url.Values{"names_start_with": []string{getImageRepository(image)}})
+	if err != nil {
+		return nil, err
+	}
+
+	var projectIDs []string
+
+	for _, project := range projects {
+		if !matchProject(project, image, digest) {
+			continue
+		}
+
+		lastTested := project.LastTested
+		if lastTested.IsZero() {
+			lastTested = project.Created
+		}
+
+		if time.Since(lastTested) <= maxAge {
+			projectIDs = append(projectIDs, project.ID)
+		}
+	}
+
+	return projectIDs, nil
+}
+
+// matchProject returns true, when the given project belongs to the image or digest.
+func matchProject(project Project, image, digest string) bool {
+	if project.Name == image || strings.HasPrefix(project.Name, image+":") {
+		return true
+	}
+
+	if digest != "" {
+		for _, tag := range project.Tags {
+			if tag.Key == "digest" && tag.Value == digest {
+				return true
+			}
+		}
+	}
+
+	return false
+}
+
+// getImageRepository returns the repository of the given image, by removing the tag and digest from the image.
+func getImageRepository(image string) string {
+	repository, _, _ := SplitImage(image)
+	return repository
+}
+
+// restClient is the client for the REST API of Snyk. The REST API doesn't provide an endpoint to import an image via an
+// integration, so that the import and the status of the import job are still handled via the v1 API. The projects and
+// the issues are fetched via the REST API.
+type restClient struct {
+	*client
+}
+
+// WithOrganisation returns a copy of the REST client, which uses the given organisation, integration and API key.
+func (c *restClient) WithOrganisation(organisationID, integrationID, apiKey string) Client {
+	return &restClient{client: c.client.WithOrganisation(organisationID, integrationID, apiKey).(*client)}
+}
+
+// GetAggregatedIssues returns the issues for all the given projects via the issues endpoint of the REST API. The
+// issues are converted into the format of the v1 API, so that the scan report is the same for both APIs.
+func (c *restClient) GetAggregatedIssues(ctx context.Context, projectIDs []string) ([]Issue, error) {
+	return c.getIssues(ctx, projectIDs, c.listIssues)
+}
+
+// listIssues returns all open issues of the given project, which are matching the configured filters. The severity and
+// the ignored filter are passed to the REST API, the other filters are applied to the returned issues. The exploit
+// maturity and the patched filter are not supported, see validateRESTFilters. The REST API also returns resolved
+// issues, which are not part of the aggregated issues of the v1 API, so that we only request open issues and skip all
+// other issues in case the status filter is not applied.
+func (c *restClient) listIssues(ctx context.Context, project string) ([]Issue, error) {
+	query := url.Values{}
+	query.Set("scan_item.id", project)
+	query.Set("scan_item.type", "project")
+	query.Set("limit", "100")
+	query.Set("status", "open")
+	if len(c.filters.Severities) > 0 {
+		query.Set("effective_severity_level", strings.Join(c.filters.Severities, ","))
+	}
+	if !c.filters.Ignored {
+		query.Set("ignored", "false")
+	}
+
+	issues := []Issue{}
+	next := c.restURL(fmt.Sprintf("/orgs/%s/issues", c.organisationID), query)
+
+	for next != "" {
+		req, err := http.NewRequestWithContext(ctx, http.MethodGet, next, nil)
+		if err != nil {
+			return nil, err
+		}
+
+		var issuesResponse RESTIssuesResponse
+
+		if _, err := c.doREST(req, &issuesResponse); err != nil {
+			return nil, err
+		}
+
+		for _, restIssue := range issuesResponse.Data {
+			if restIssue.Attributes.Status != "" && restIssue.Attributes.Status != "open" {
+				continue
+			}
+
+			issue := convertRESTIssue(restIssue)
+			if matchFilters(issue, c.filters) {
+				issues = append(issues, issue)
+			}
+		}
+
+		next = ""
+		if issuesResponse.Links.Next != "" {
+			next, err = c.nextURL(issuesResponse.Links.Next)
+			if err != nil {
+				return nil, err
+			}
+		}
+	}
+
+	return issues, nil
+}
+
+// convertRESTIssue converts an issue from the REST API into the format of the v1 API.
+func convertRESTIssue(restIssue RESTIssue) Issue {
+	var issue Issue
+
+	issue.ID = restIssue.Attributes.Key
+	issue.IssueType = IssueTypeVuln
+	if restIssue.Attributes.Type == IssueTypeLicense {
+		issue.IssueType = IssueTypeLicense
+	}
+
+	issue.PriorityScore = restIssue.Attributes.Risk.Score.Value
+	issue.Priority.Score = restIssue.Attributes.Risk.Score.Value
+	issue.IsIgnored = restIssue.Attributes.Ignored
+
+	issue.IssueData.ID = restIssue.Attributes.Key
+	issue.IssueData.Title = restIssue.Attributes.Title
+	issue.IssueData.Severity = restIssue.Attributes.EffectiveSeverityLevel
+	issue.IssueData.Description = restIssue.Attributes.Description
+	if issue.IssueType == IssueTypeVuln {
+		issue.IssueData.URL = fmt.Sprintf("https://security.snyk.io/vuln/%s", restIssue.Attributes.Key)
+	}
+
+	for _, problem := range restIssue.Attributes.Problems {
+		switch {
+		case strings.HasPrefix(problem.ID, "CVE-"):
+			issue.IssueData.Identifiers.Cve = append(issue.IssueData.Identifiers.Cve, problem.ID)
+		case strings.HasPrefix(problem.ID, "CWE-"):
+			issue.IssueData.Identifiers.Cwe = append(issue.IssueData.Identifiers.Cwe, problem.ID)
+		}
+	}
+
+	for _, severity := range restIssue.Attributes.Severities {
+		if severity.Vector != "" && (issue.IssueData.CVSSv3 == "" || severity.Source == "Snyk") {
+			issue.IssueData.CVSSv3 = severity.Vector
+			issue.IssueData.CvssScore = severity.Score
+		}
+	}
+
+	for _, coordinate := range restIssue.Attributes.Coordinates {
+		issue.FixInfo.IsUpgradable = issue.FixInfo.IsUpgradable || coordinate.IsUpgradeable
+		issue.FixInfo.IsPinnable = issue.FixInfo.IsPinnable || coordinate.IsPinnable
+		issue.FixInfo.IsPatchable = issue.FixInfo.IsPatchable || coordinate.IsPatchable
+
+		for _, representation := range coordinate.Representations {
+			if representation.Dependency.PackageName != "" {
+				issue.PkgName = representation.Dependency.PackageName
+				issue.PkgVersions = appendUnique(issue.PkgVersions, representation.Dependency.PackageVersion)
+			}
+		}
+
+		for _, remedy := range coordinate.Remedies {
+			if index := strings.LastIndex(remedy.Details.UpgradePackage, "@"); index > 0 {
+				issue.FixInfo.FixedIn = appendUnique(issue.FixInfo.FixedIn, remedy.Details.UpgradePackage[index+1:])
+			}
+		}
+	}
+
+	issue.FixInfo.IsFixable = issue.FixInfo.IsUpgradable || issue.FixInfo.IsPinnable || issue.FixInfo.IsPatchable
+
+	return issue
+}
+
+// validateRESTFilters returns an error, when the exploit maturity or the patched filter are set to another value than
+// the default. The REST API doesn't return the exploit maturity and the patch status of an issue, so that these filters
+// can not be applied. Ignoring them would silently change the content of the scan reports, when the API is switched.
+func validateRESTFilters(filters AggregatedIssuesFilters) error {
+	for _, exploitMaturity := range []string{"mature", "proof-of-concept", "no-known-exploit", "no-data"} {
+		if !contains(filters.ExploitMaturity, exploitMaturity) {
+			return fmt.Errorf("exploitMaturity filter is not supported by the REST API, all values must be set")
+		}
+	}
+
+	if filters.Patched {
+		return fmt.Errorf("patched filter is not supported by the REST API")
+	}
+
+	return nil
+}
+
+// matchFilters returns true, when the given issue matches the type, severity, ignored and priority filters.
+func matchFilters(issue Issue, filters AggregatedIssuesFilters) bool {
+	if len(filters.Types) > 0 && !contains(filters.Types, issue.IssueType) {
+		return false
+	}
+
+	if len(filters.Severities) > 0 && !contains(filters.Severities, issue.IssueData.Severity) {
+		return false
+	}
+
+	if issue.IsIgnored && !filters.Ignored {
+		return false
+	}
+
+	return issue.PriorityScore >= filters.Priority.Score.Min && (filters.Priority.Score.Max == 0 || issue.PriorityScore <= filters.Priority.Score.Max)
+}
+
+func appendUnique(values []string, value string) []string {
+	if value == "" || contains(values, value) {
+		return values
+	}
+
+	return append(values, value)
+}
@@ -0,0 +1,130 @@
+package snyk
+
+import (
+	"context"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	"github.com/stretchr/testify/require"
+)
+
+func TestRESTClientGetAggregatedIssues(t *testing.T) {
+	ts := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		require.Equal(t, "/rest/orgs/org/issues", r.URL.Path)
+		require.Equal(t, "2023-05-29", r.URL.Query().Get("version"))
+		require.Equal(t, "project1", r.URL.Query().Get("scan_item.id"))
+		require.Equal(t, "project", r.URL.Query().Get("scan_item.type"))
+		require.Equal(t, "critical,high,medium,low", r.URL.Query().Get("effective_severity_level"))
+		require.Equal(t, "false", r.URL.Query().Get("ignored"))
+		require.Equal(t, "open", r.URL.Query().Get("status"))
+		require.Equal(t, "token apikey", r.Header.Get("Authorization"))
+
+		if r.URL.Query().Get("starting_after") == "" {
+			fmt.Fprint(w, `{"data": [
+				{"id": "1", "type": "issue", "attributes": {
+					"key": "SNYK-DEBIAN11-OPENSSL-2388380", "title": "Improper Certificate Validation", "type": "package_vulnerability",
+					"effective_severity_level": "high",
+					"problems": [{"id": "CVE-2022-0778", "source": "NVD"}, {"id": "CWE-835", "source": "CWE"}],
+					"coordinates": [{"is_upgradeable": true, "remedies": [{"type": "indeterminate", "details": {"upgrade_package": "openssl@1.1.1n-0+deb11u1"}}], "representations": [{"dependency": {"package_name": "openssl", "package_version": "1.1.1k-1+deb11u1"}}]}],
+					"severities": [{"source": "NVD", "level": "high", "score": 7.4, "vector": "CVSS:3.1/AV:N"}, {"source": "Snyk", "level": "high", "score": 7.5, "vector": "CVSS:3.1/AV:N/AC:L"}],
+					"risk": {"score": {"value": 600}}
+				}},
+				{"id": "2", "type": "issue", "attributes": {"key": "snyk:lic:deb:gcc-10:GPL-3.0", "title": "GPL-3.0 license", "type": "license", "effective_severity_level": "medium"}}
+			], "links": {"next": "/orgs/org/issues?version=2023-05-29&scan_item.id=project1&scan_item.type=project&effective_severity_level=critical,high,medium,low&ignored=false&status=open&starting_after=abc"}}`)
+			return
+		}
+
+		fmt.Fprint(w, `{"data": [
+			{"id": "3", "type": "issue", "attributes": {"key": "SNYK-DEBIAN11-ZLIB-2433933", "title": "Out-of-bounds Write", "type": "package_vulnerability", "effective_severity_level": "low", "status": "open", "risk": {"score": {"value": 100}}}},
+			{"id": "4", "type": "issue", "attributes": {"key": "SNYK-DEBIAN11-CURL-2813755", "title": "Double Free", "type": "package_vulnerability", "effective_severity_level": "high", "status": "resolved", "risk": {"score": {"value": 700}}}}
+		], "links": {}}`)
+	}))
+	defer ts.Close()
+
+	filters := AggregatedIssuesFilters{Severities: []string{"critical", "high", "medium", "low"}, Types: []string{IssueTypeVuln}}
+	filters.Priority.Score.Max = 1000
+
+	c := &restClient{client: &client{apiKey: "apikey", baseURL: ts.URL, organisationID: "org", restVersion: "2023-05-29", concurrency: 1, filters: filters, httpClient: ts.Client()}}
+
+	issues, err := c.GetAggregatedIssues(context.Background(), []string{"project1"})
+	require.NoError(t, err)
+	require.Len(t, issues, 2)
+
+	require.Equal(t, "SNYK-DEBIAN11-OPENSSL-2388380", issues[0].ID)
+	require.Equal(t, IssueTypeVuln, issues[0].IssueType)
+	require.Equal(t, "openssl", issues[0].PkgName)
+	require.Equal(t, []string{"1.1.1k-1+deb11u1"}, issues[0].PkgVersions)
+	require.Equal(t, []string{"1.1.1n-0+deb11u1"}, issues[0].FixInfo.FixedIn)
+	require.True(t, issues[0].FixInfo.IsFixable)
+	require.Equal(t, "high", issues[0].IssueData.Severity)
+	require.Equal(t, []string{"CVE-2022-0778"}, issues[0].IssueData.Identifiers.Cve)
+	require.Equal(t, []string{"CWE-835"}, issues[0].IssueData.Identifiers.Cwe)
+	require.Equal(t, 7.5, issues[0].IssueData.CvssScore)
+	require.Equal(t, "CVSS:3.1/AV:N/AC:L", issues[0].IssueData.CVSSv3)
+	require.Equal(t, 600, issues[0].PriorityScore)
+
+	require.Equal(t, "SNYK-DEBIAN11-ZLIB-2433933", issues[1].ID)
+
+	// The resolved issue on the second page must not be part of the report, even when the API ignores the status filter.
+	for _, issue := range issues {
+		require.NotEqual(t, "SNYK-DEBIAN11-CURL-2813755", issue.ID)
+	}
+
+	t.Run("priority filter", func(t *testing.T) {
+		c.filters.Priority.Score.Min = 500
+		defer func() {
+			c.filters.Priority.Score.Min = 0
+		}()
+
+		issues, err := c.GetAggregatedIssues(context.Background(), []string{"project1"})
+		require.NoError(t, err)
+		require.Len(t, issues, 1)
+		require.Equal(t, "SNYK-DEBIAN11-OPENSSL-2388380", issues[0].ID)
+	})
+}
+
+func TestRESTClientWithOrganisation(t *testing.T) {
+	c := &restClient{client: &client{apiKey: "apikey", organisationID: "org", integrationID: "integration"}}
+
+	routed, ok := c.WithOrganisation("org-a", "integration-a", "").(*restClient)
+	require.True(t, ok)
+	require.Equal(t, "org-a", routed.organisationID)
+	require.Equal(t, "apikey", routed.apiKey)
+}
+
+func TestNewClientAPI(t *testing.T) {
+	defer func() {
+		api = "v1"
+	}()
+
+	c, err := NewClient()
+	require.NoError(t, err)
+	require.IsType(t, &client{}, c)
+
+	api = "rest"
+	c, err = NewClient()
+	require.NoError(t, err)
+	require.IsType(t, &restClient{}, c)
+
+	t.Run("unsupported filters", func(t *testing.T) {
+		defer func(previousExploitMaturity []string, previousPatched bool) {
+			filterExploitMaturity = previousExploitMaturity
+			filterPatched = previousPatched
+		}(filterExploitMaturity, filterPatched)
+
+		filterExploitMaturity = []string{"mature"}
+		_, err = NewClient()
+		require.EqualError(t, err, "exploitMaturity filter is not supported by the REST API, all values must be set")
+
+		filterExploitMaturity = []string{"mature", "proof-of-concept", "no-known-exploit", "no-data"}
+		filterPatched = true
+		_, err = NewClient()
+		require.EqualError(t, err, "patched filter is not supported by the REST API")
+	})
+
+	api = "v2"
+	_, err = NewClient()
+	require.EqualError(t, err, "invalid api \"v2\", must be \"v1\" or \"rest\"")
+}
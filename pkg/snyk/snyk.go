@@ -6,11 +6,16 @@ import (
 	"encoding/json"
 	"fmt"
 	"net/http"
+	"net/url"
 	"os"
+	"path"
+	"strconv"
+	"strings"
 	"sync"
 	"time"
 
 	flag "github.com/spf13/pflag"
+	"go.opentelemetry.io/otel/attribute"
 )
 
 var (
@@ -18,10 +23,41 @@ var (
 	baseURL        string
 	integrationID  string
 	organisationID string
+	timeout        time.Duration
+	maxRetries     int
+	importRetries  int
+	retryWaitMin   time.Duration
+	retryWaitMax   time.Duration
+	rateLimit      float64
+	rateLimitBurst int
+	concurrency    int
+	restVersion    string
+	api            string
+
+	filterSeverities      []string
+	filterExploitMaturity []string
+	filterTypes           []string
+	filterIgnored         bool
+	filterPatched         bool
+	filterPriorityMin     int
+	filterPriorityMax     int
+	includeLicenseIssues  bool
+
+	proxyURL            string
+	noProxy             string
+	caFile              string
+	clientCertFile      string
+	clientKeyFile       string
+	tlsMinVersion       string
+	maxIdleConns        int
+	maxIdleConnsPerHost int
+	maxConnsPerHost     int
+	idleConnTimeout     time.Duration
 )
 
 // init is used to define all flags, which are needed for the Snyk client. These are the base url of the Snyk API, an
-// API key and the integration and organisation id.
+// API key and the integration and organisation id. We also define the flags to configure the retries and the rate
+// limit for requests against the Snyk API.
 func init() {
 	defaultBaseURL := "https://snyk.io"
 	if os.Getenv("SNYK_BASE_URL") != "" {
@@ -43,15 +79,253 @@ func init() {
 		defaultOrganisationID = os.Getenv("SNYK_ORGANISATION_ID")
 	}
 
+	defaultTimeout := 60 * time.Second
+	if os.Getenv("SNYK_TIMEOUT") != "" {
+		parsedTimeout, err := time.ParseDuration(os.Getenv("SNYK_TIMEOUT"))
+		if err == nil {
+			defaultTimeout = parsedTimeout
+		}
+	}
+
+	defaultMaxRetries := 3
+	if os.Getenv("SNYK_MAX_RETRIES") != "" {
+		parsedMaxRetries, err := strconv.Atoi(os.Getenv("SNYK_MAX_RETRIES"))
+		if err == nil {
+			defaultMaxRetries = parsedMaxRetries
+		}
+	}
+
+	defaultImportRetries := 0
+	if os.Getenv("SNYK_IMPORT_RETRIES") != "" {
+		parsedImportRetries, err := strconv.Atoi(os.Getenv("SNYK_IMPORT_RETRIES"))
+		if err == nil {
+			defaultImportRetries = parsedImportRetries
+		}
+	}
+
+	defaultRetryWaitMin := 1 * time.Second
+	if os.Getenv("SNYK_RETRY_WAIT_MIN") != "" {
+		parsedRetryWaitMin, err := time.ParseDuration(os.Getenv("SNYK_RETRY_WAIT_MIN"))
+		if err == nil {
+			defaultRetryWaitMin = parsedRetryWaitMin
+		}
+	}
+
+	defaultRetryWaitMax := 30 * time.Second
+	if os.Getenv("SNYK_RETRY_WAIT_MAX") != "" {
+		parsedRetryWaitMax, err := time.ParseDuration(os.Getenv("SNYK_RETRY_WAIT_MAX"))
+		if err == nil {
+			defaultRetryWaitMax = parsedRetryWaitMax
+		}
+	}
+
+	defaultRateLimit := 0.0
+	if os.Getenv("SNYK_RATE_LIMIT") != "" {
+		parsedRateLimit, err := strconv.ParseFloat(os.Getenv("SNYK_RATE_LIMIT"), 64)
+		if err == nil {
+			defaultRateLimit = parsedRateLimit
+		}
+	}
+
+	defaultRateLimitBurst := 10
+	if os.Getenv("SNYK_RATE_LIMIT_BURST") != "" {
+		parsedRateLimitBurst, err := strconv.Atoi(os.Getenv("SNYK_RATE_LIMIT_BURST"))
+		if err == nil {
+			defaultRateLimitBurst = parsedRateLimitBurst
+		}
+	}
+
+	defaultConcurrency := 5
+	if os.Getenv("SNYK_CONCURRENCY") != "" {
+		parsedConcurrency, err := strconv.Atoi(os.Getenv("SNYK_CONCURRENCY"))
+		if err == nil {
+			defaultConcurrency = parsedConcurrency
+		}
+	}
+
+	defaultRESTVersion := "2023-05-29"
+	if os.Getenv("SNYK_REST_VERSION") != "" {
+		defaultRESTVersion = os.Getenv("SNYK_REST_VERSION")
+	}
+
+	defaultFilterSeverities := []string{"critical", "high", "medium", "low"}
+	if os.Getenv("SNYK_FILTER_SEVERITIES") != "" {
+		defaultFilterSeverities = strings.Split(os.Getenv("SNYK_FILTER_SEVERITIES"), ",")
+	}
+
+	defaultFilterExploitMaturity := []string{"mature", "proof-of-concept", "no-known-exploit", "no-data"}
+	if os.Getenv("SNYK_FILTER_EXPLOIT_MATURITY") != "" {
+		defaultFilterExploitMaturity = strings.Split(os.Getenv("SNYK_FILTER_EXPLOIT_MATURITY"), ",")
+	}
+
+	defaultFilterTypes := []string{"vuln"}
+	if os.Getenv("SNYK_FILTER_TYPES") != "" {
+		defaultFilterTypes = strings.Split(os.Getenv("SNYK_FILTER_TYPES"), ",")
+	}
+
+	defaultFilterIgnored := false
+	if os.Getenv("SNYK_FILTER_IGNORED") != "" {
+		defaultFilterIgnored, _ = strconv.ParseBool(os.Getenv("SNYK_FILTER_IGNORED"))
+	}
+
+	defaultFilterPatched := false
+	if os.Getenv("SNYK_FILTER_PATCHED") != "" {
+		defaultFilterPatched, _ = strconv.ParseBool(os.Getenv("SNYK_FILTER_PATCHED"))
+	}
+
+	defaultFilterPriorityMin := 0
+	if os.Getenv("SNYK_FILTER_PRIORITY_MIN") != "" {
+		parsedFilterPriorityMin, err := strconv.Atoi(os.Getenv("SNYK_FILTER_PRIORITY_MIN"))
+		if err == nil {
+			defaultFilterPriorityMin = parsedFilterPriorityMin
+		}
+	}
+
+	defaultFilterPriorityMax := 1000
+	if os.Getenv("SNYK_FILTER_PRIORITY_MAX") != "" {
+		parsedFilterPriorityMax, err := strconv.Atoi(os.Getenv("SNYK_FILTER_PRIORITY_MAX"))
+		if err == nil {
+			defaultFilterPriorityMax = parsedFilterPriorityMax
+		}
+	}
+
+	defaultIncludeLicenseIssues := false
+	if os.Getenv("SNYK_INCLUDE_LICENSE_ISSUES") != "" {
+		defaultIncludeLicenseIssues, _ = strconv.ParseBool(os.Getenv("SNYK_INCLUDE_LICENSE_ISSUES"))
+	}
+
+	defaultProxyURL := ""
+	if os.Getenv("SNYK_PROXY_URL") != "" {
+		defaultProxyURL = os.Getenv("SNYK_PROXY_URL")
+	}
+
+	defaultNoProxy := ""
+	if os.Getenv("SNYK_NO_PROXY") != "" {
+		defaultNoProxy = os.Getenv("SNYK_NO_PROXY")
+	}
+
+	defaultCAFile := ""
+	if os.Getenv("SNYK_CA_FILE") != "" {
+		defaultCAFile = os.Getenv("SNYK_CA_FILE")
+	}
+
+	defaultClientCertFile := ""
+	if os.Getenv("SNYK_CLIENT_CERT_FILE") != "" {
+		defaultClientCertFile = os.Getenv("SNYK_CLIENT_CERT_FILE")
+	}
+
+	defaultClientKeyFile := ""
+	if os.Getenv("SNYK_CLIENT_KEY_FILE") != "" {
+		defaultClientKeyFile = os.Getenv("SNYK_CLIENT_KEY_FILE")
+	}
+
+	defaultTLSMinVersion := "1.2"
+	if os.Getenv("SNYK_TLS_MIN_VERSION") != "" {
+		defaultTLSMinVersion = os.Getenv("SNYK_TLS_MIN_VERSION")
+	}
+
+	defaultMaxIdleConns := 100
+	if os.Getenv("SNYK_MAX_IDLE_CONNS") != "" {
+		parsedMaxIdleConns, err := strconv.Atoi(os.Getenv("SNYK_MAX_IDLE_CONNS"))
+		if err == nil {
+			defaultMaxIdleConns = parsedMaxIdleConns
+		}
+	}
+
+	defaultMaxIdleConnsPerHost := 10
+	if os.Getenv("SNYK_MAX_IDLE_CONNS_PER_HOST") != "" {
+		parsedMaxIdleConnsPerHost, err := strconv.Atoi(os.Getenv("SNYK_MAX_IDLE_CONNS_PER_HOST"))
+		if err == nil {
+			defaultMaxIdleConnsPerHost = parsedMaxIdleConnsPerHost
+		}
+	}
+
+	defaultMaxConnsPerHost := 0
+	if os.Getenv("SNYK_MAX_CONNS_PER_HOST") != "" {
+		parsedMaxConnsPerHost, err := strconv.Atoi(os.Getenv("SNYK_MAX_CONNS_PER_HOST"))
+		if err == nil {
+			defaultMaxConnsPerHost = parsedMaxConnsPerHost
+		}
+	}
+
+	defaultIdleConnTimeout := 90 * time.Second
+	if os.Getenv("SNYK_IDLE_CONN_TIMEOUT") != "" {
+		parsedIdleConnTimeout, err := time.ParseDuration(os.Getenv("SNYK_IDLE_CONN_TIMEOUT"))
+		if err == nil {
+			defaultIdleConnTimeout = parsedIdleConnTimeout
+		}
+	}
+
+	defaultAPI := "v1"
+	if os.Getenv("SNYK_API") != "" {
+		defaultAPI = os.Getenv("SNYK_API")
+	}
+
 	flag.StringVar(&apiKey, "snyk.api-key", defaultAPIKey, "The API key to access the Snyk API.")
 	flag.StringVar(&baseURL, "snyk.base-url", defaultBaseURL, "The base url of the Snyk API.")
 	flag.StringVar(&integrationID, "snyk.integration-id", defaultIntegrationID, "The id of the Snyk integration.")
 	flag.StringVar(&organisationID, "snyk.organisation-id", defaultOrganisationID, "The id of the Snyk organisation.")
+	flag.DurationVar(&timeout, "snyk.timeout", defaultTimeout, "The timeout for a single request against the Snyk API.")
+	flag.IntVar(&maxRetries, "snyk.max-retries", defaultMaxRetries, "The maximum number of retries for a failed request against the Snyk API.")
+	flag.IntVar(&importRetries, "snyk.import-retries", defaultImportRetries, "The maximum number of retries for a failed import of an image into Snyk.")
+	flag.DurationVar(&retryWaitMin, "snyk.retry-wait-min", defaultRetryWaitMin, "The minimum time to wait before a failed request against the Snyk API is retried.")
+	flag.DurationVar(&retryWaitMax, "snyk.retry-wait-max", defaultRetryWaitMax, "The maximum time to wait before a failed request against the Snyk API is retried. If Snyk asks us to wait longer, the request is not retried.")
+	flag.Float64Var(&rateLimit, "snyk.rate-limit", defaultRateLimit, "The maximum number of requests per second against the Snyk API. If the value is 0, the requests are not limited.")
+	flag.IntVar(&rateLimitBurst, "snyk.rate-limit-burst", defaultRateLimitBurst, "The maximum number of requests, which can be sent at once against the Snyk API.")
+	flag.IntVar(&concurrency, "snyk.concurrency", defaultConcurrency, "The maximum number of projects, for which the issues are fetched concurrently.")
+	flag.StringVar(&api, "snyk.api", defaultAPI, "The Snyk API, which is used to get the issues for a project. Must be \"v1\" or \"rest\". The import of an image and the status of the import job are always handled via the v1 API, because the REST API doesn't support the import via an integration. The REST API doesn't support the snyk.filter-exploit-maturity and snyk.filter-patched filters.")
+	flag.StringVar(&restVersion, "snyk.rest-version", defaultRESTVersion, "The version of the Snyk REST API, which is used for all requests against the REST API. The REST API is also used, when \"v1\" is selected via the snyk.api flag, because the projects for the reuse of projects (scanner.reuse-max-age) and the garbage collector (scanner.gc-interval) are always listed via the REST API. The API key must therefore be allowed to use the REST API.")
+	flag.StringSliceVar(&filterSeverities, "snyk.filter-severities", defaultFilterSeverities, "The severities of the issues, which should be included in the scan report. Possible values are \"critical\", \"high\", \"medium\" and \"low\".")
+	flag.StringSliceVar(&filterExploitMaturity, "snyk.filter-exploit-maturity", defaultFilterExploitMaturity, "The exploit maturities of the issues, which should be included in the scan report. Possible values are \"mature\", \"proof-of-concept\", \"no-known-exploit\" and \"no-data\". The filter is not supported by the REST API, so that all values must be set, when the REST API is used.")
+	flag.StringSliceVar(&filterTypes, "snyk.filter-types", defaultFilterTypes, "The types of the issues, which should be included in the scan report. Possible values are \"vuln\" and \"license\".")
+	flag.BoolVar(&filterIgnored, "snyk.filter-ignored", defaultFilterIgnored, "Include issues, which are ignored in Snyk.")
+	flag.BoolVar(&filterPatched, "snyk.filter-patched", defaultFilterPatched, "Include issues, which are patched in Snyk. The filter is not supported by the REST API, so that it must not be set, when the REST API is used.")
+	flag.IntVar(&filterPriorityMin, "snyk.filter-priority-min", defaultFilterPriorityMin, "The minimum priority score of the issues, which should be included in the scan report.")
+	flag.IntVar(&filterPriorityMax, "snyk.filter-priority-max", defaultFilterPriorityMax, "The maximum priority score of the issues, which should be included in the scan report.")
+	flag.BoolVar(&includeLicenseIssues, "snyk.include-license-issues", defaultIncludeLicenseIssues, "Include license issues in the scan report. This adds the \"license\" type to the types filter.")
+	flag.StringVar(&proxyURL, "snyk.proxy-url", defaultProxyURL, "The url of the proxy for all requests against the Snyk API. If the url is not set, the HTTP_PROXY, HTTPS_PROXY and NO_PROXY environment variables are used.")
+	flag.StringVar(&noProxy, "snyk.no-proxy", defaultNoProxy, "A comma separated list of hosts, domains and IP ranges, which should not be requested via the proxy. If the list is set, it overwrites the NO_PROXY environment variable, also when the proxy is configured via the environment variables.")
+	flag.StringVar(&caFile, "snyk.ca-file", defaultCAFile, "The path to a PEM encoded CA bundle, which is used in addition to the system certificates to verify the Snyk API.")
+	flag.StringVar(&clientCertFile, "snyk.client-cert-file", defaultClientCertFile, "The path to a PEM encoded client certificate, which is used for mTLS.")
+	flag.StringVar(&clientKeyFile, "snyk.client-key-file", defaultClientKeyFile, "The path to the PEM encoded key of the client certificate, which is used for mTLS.")
+	flag.StringVar(&tlsMinVersion, "snyk.tls-min-version", defaultTLSMinVersion, "The minimum TLS version for requests against the Snyk API. Must be \"1.0\", \"1.1\", \"1.2\" or \"1.3\".")
+	flag.IntVar(&maxIdleConns, "snyk.max-idle-conns", defaultMaxIdleConns, "The maximum number of idle connections across all hosts. If the value is 0, there is no limit.")
+	flag.IntVar(&maxIdleConnsPerHost, "snyk.max-idle-conns-per-host", defaultMaxIdleConnsPerHost, "The maximum number of idle connections per host.")
+	flag.IntVar(&maxConnsPerHost, "snyk.max-conns-per-host", defaultMaxConnsPerHost, "The maximum number of connections per host. If the value is 0, there is no limit.")
+	flag.DurationVar(&idleConnTimeout, "snyk.idle-conn-timeout", defaultIdleConnTimeout, "The duration after which an idle connection is closed.")
 }
 
+// Client is the interface for the Snyk API. ImportProject imports the given image into Snyk and returns the location
+// of the import job. The location can then be passed to GetProjectIDs, which returns the ids of all projects created
+// for the image, when the import job is completed. GetAggregatedIssues returns the issues for the given projects.
+//
+// FindProjects can be used before an image is imported, to check if there are already projects for the image, which
+// were tested within the given max age. If this is the case, the returned project ids can be passed to
+// GetAggregatedIssues directly, without importing the image again.
+//
+// WithOrganisation returns a client for another organisation and integration. The returned client shares the HTTP
+// client and therefore also the rate limit with the original client.
+//
+// DeleteProject and DeactivateProject can be used to remove the projects of an import job from Snyk, when they are not
+// needed anymore. ListProjects returns all projects of the organisation, so that projects for artifacts which were
+// deleted in Harbor can be found.
+//
+// AddProjectTag and SetProjectAttributes are used to add metadata from Harbor to the projects of an import job, so that
+// the projects can be filtered by this metadata in Snyk. GetProjectTags and RemoveProjectTag are used to replace the
+// tags of a project, which was refreshed by another import of the same image.
 type Client interface {
 	ImportProject(ctx context.Context, image string) (string, error)
-	GetAggregatedIssues(ctx context.Context, image, location string) ([]Issue, error)
+	GetProjectIDs(ctx context.Context, image, location string) ([]string, error)
+	GetAggregatedIssues(ctx context.Context, projectIDs []string) ([]Issue, error)
+	FindProjects(ctx context.Context, image, digest string, maxAge time.Duration) ([]string, error)
+	WithOrganisation(organisationID, integrationID, apiKey string) Client
+	DeleteProject(ctx context.Context, projectID string) error
+	DeactivateProject(ctx context.Context, projectID string) error
+	ListProjects(ctx context.Context, origins []string) ([]Project, error)
+	GetProjectTags(ctx context.Context, projectID string) ([]Tag, error)
+	AddProjectTag(ctx context.Context, projectID string, tag Tag) error
+	RemoveProjectTag(ctx context.Context, projectID string, tag Tag) error
+	SetProjectAttributes(ctx context.Context, projectID string, attributes ProjectAttributes) error
 }
 
 type client struct {
@@ -59,155 +333,530 @@ type client struct {
 	baseURL        string
 	integrationID  string
 	organisationID string
+	maxRetries     int
+	importRetries  int
+	concurrency    int
+	restVersion    string
+	filters        AggregatedIssuesFilters
 	httpClient     *http.Client
 }
 
-func (c *client) getAggregatedIssues(ctx context.Context, project string) ([]Issue, error) {
-	req, err := http.NewRequestWithContext(ctx, http.MethodPost, fmt.Sprintf("%s/api/v1/org/%s/project/%s/aggregated-issues", c.baseURL, c.organisationID, project), bytes.NewBuffer([]byte("{\"includeDescription\": true, \"includeIntroducedThrough\": false, \"filters\": {\"severities\": [\"critical\", \"high\", \"medium\", \"low\"], \"exploitMaturity\": [\"mature\", \"proof-of-concept\", \"no-known-exploit\", \"no-data\"], \"types\": [\"vuln\"], \"ignored\": false, \"patched\": false, \"priority\": {\"score\": {\"min\": 0, \"max\": 1000}}}}")))
-	if err != nil {
-		return nil, err
+// do sends the given request to the v1 API of Snyk. If the response has a status code in the 2xx range, the body is
+// decoded into v, when v is not nil. For all other status codes an APIError is returned. When the request fails before
+// we get a response a NetworkError is returned, so that the caller can distinguish between both cases.
+func (c *client) do(req *http.Request, v interface{}) (*http.Response, error) {
+	req.Header.Set("Authorization", fmt.Sprintf("token: %s", c.apiKey))
+	if req.Body != nil {
+		req.Header.Set("Content-Type", "application/json; charset=utf-8")
 	}
 
-	req.Header.Set("Authorization", fmt.Sprintf("token: %s", c.apiKey))
-	req.Header.Set("Content-Type", "application/json; charset=utf-8")
+	return c.send(req, v)
+}
 
+// send sends the given request and decodes the body of the response into v. The caller must set the required headers
+// for the used API.
+func (c *client) send(req *http.Request, v interface{}) (*http.Response, error) {
 	resp, err := c.httpClient.Do(req)
 	if err != nil {
-		return nil, err
+		if req.Context().Err() != nil {
+			return nil, req.Context().Err()
+		}
+
+		return nil, &NetworkError{Err: err}
 	}
 
 	defer resp.Body.Close()
 
-	if resp.StatusCode >= 200 && resp.StatusCode < 300 {
-		var issuesResponse IssuesResponse
-		err = json.NewDecoder(resp.Body).Decode(&issuesResponse)
-		if err != nil {
-			return nil, err
-		}
+	if resp.StatusCode < 200 || resp.StatusCode >= 300 {
+		return nil, newAPIError(resp)
+	}
 
-		return issuesResponse.Issues, nil
+	if v != nil {
+		if err := json.NewDecoder(resp.Body).Decode(v); err != nil {
+			return nil, fmt.Errorf("could not decode response: %w", err)
+		}
 	}
 
-	var res ErrorResponse
+	return resp, nil
+}
 
-	err = json.NewDecoder(resp.Body).Decode(&res)
+func (c *client) getAggregatedIssues(ctx context.Context, project string) ([]Issue, error) {
+	// The request to get the aggregated issues doesn't modify anything in Snyk, so that it can be retried like a GET
+	// request.
+	body, err := json.Marshal(AggregatedIssuesRequest{
+		IncludeDescription:       true,
+		IncludeIntroducedThrough: false,
+		Filters:                  c.filters,
+	})
 	if err != nil {
 		return nil, err
 	}
 
-	return nil, fmt.Errorf("%s", res.Message)
+	req, err := http.NewRequestWithContext(withRetries(ctx, c.maxRetries), http.MethodPost, fmt.Sprintf("%s/api/v1/org/%s/project/%s/aggregated-issues", c.baseURL, c.organisationID, project), bytes.NewBuffer(body))
+	if err != nil {
+		return nil, err
+	}
+
+	var issuesResponse IssuesResponse
+
+	if _, err := c.do(req, &issuesResponse); err != nil {
+		return nil, err
+	}
+
+	return issuesResponse.Issues, nil
 }
 
 func (c *client) ImportProject(ctx context.Context, image string) (string, error) {
-	req, err := http.NewRequestWithContext(ctx, http.MethodPost, fmt.Sprintf("%s/api/v1/org/%s/integrations/%s/import", c.baseURL, c.organisationID, c.integrationID), bytes.NewBuffer([]byte(fmt.Sprintf("{\"target\": {\"name\": \"%s\"}}", image))))
+	// The image is rendered via a template, which is configured by the operator, so that it must be encoded properly
+	// instead of being formatted into the body.
+	body, err := json.Marshal(ImportRequest{Target: ImportTarget{Name: image}})
 	if err != nil {
 		return "", err
 	}
 
-	req.Header.Set("Authorization", fmt.Sprintf("token: %s", c.apiKey))
-	req.Header.Set("Content-Type", "application/json; charset=utf-8")
-
-	resp, err := c.httpClient.Do(req)
+	req, err := http.NewRequestWithContext(withRetries(ctx, c.importRetries), http.MethodPost, fmt.Sprintf("%s/api/v1/org/%s/integrations/%s/import", c.baseURL, c.organisationID, c.integrationID), bytes.NewBuffer(body))
 	if err != nil {
 		return "", err
 	}
 
-	defer resp.Body.Close()
+	resp, err := c.do(req, nil)
+	if err != nil {
+		return "", err
+	}
 
-	if resp.StatusCode >= 200 && resp.StatusCode < 300 {
-		return resp.Header.Get("location"), nil
+	location, err := resp.Location()
+	if err != nil {
+		return "", err
 	}
 
-	var res ErrorResponse
+	return location.String(), nil
+}
 
-	err = json.NewDecoder(resp.Body).Decode(&res)
+// checkLocation verifies that the given location points to an import job of our organisation and integration within
+// the configured Snyk API. This is required, because we send our API key to the location and we do not want to leak it
+// to another host.
+func (c *client) checkLocation(location string) error {
+	u, err := url.Parse(location)
 	if err != nil {
-		return "", err
+		return fmt.Errorf("%w: %s", ErrInvalidLocation, err.Error())
+	}
+
+	base, err := url.Parse(c.baseURL)
+	if err != nil {
+		return err
+	}
+
+	prefix := path.Join(base.Path, "/api/v1/org", c.organisationID, "integrations", c.integrationID, "import") + "/"
+
+	if u.Scheme != base.Scheme || u.Host != base.Host || u.User != nil || !strings.HasPrefix(u.Path, prefix) || path.Clean(u.Path) != u.Path {
+		return fmt.Errorf("%w: location %q is not allowed", ErrInvalidLocation, location)
 	}
 
-	return "", fmt.Errorf("%s", res.Message)
+	return nil
 }
 
-func (c *client) GetAggregatedIssues(ctx context.Context, image, location string) ([]Issue, error) {
+func (c *client) GetProjectIDs(ctx context.Context, image, location string) ([]string, error) {
+	if err := c.checkLocation(location); err != nil {
+		return nil, err
+	}
+
 	req, err := http.NewRequestWithContext(ctx, http.MethodGet, location, nil)
 	if err != nil {
 		return nil, err
 	}
 
-	req.Header.Set("Authorization", fmt.Sprintf("token: %s", c.apiKey))
+	var importJob ImportJobResponse
 
-	resp, err := c.httpClient.Do(req)
-	if err != nil {
+	if _, err := c.do(req, &importJob); err != nil {
 		return nil, err
 	}
 
-	defer resp.Body.Close()
+	if importJob.Status == "failed" || importJob.Status == "aborted" {
+		recordImportJob(importJob.Status)
+		return nil, &ImportFailedError{Status: importJob.Status, Messages: getUserMessages(importJob, image)}
+	}
 
-	if resp.StatusCode >= 200 && resp.StatusCode < 300 {
-		var importJob ImportJobResponse
+	if importJob.Status != "complete" {
+		return nil, ErrImportPending
+	}
 
-		err = json.NewDecoder(resp.Body).Decode(&importJob)
-		if err != nil {
-			return nil, err
+	var projectIDs []string
+	var found bool
+
+	for _, log := range importJob.Logs {
+		if matchImage(log.Name, image) {
+			found = true
+			for _, project := range log.Projects {
+				if project.Success {
+					projectIDs = append(projectIDs, project.ProjectID)
+				}
+			}
 		}
+	}
+
+	// When the import job is completed, but there is no successfully imported project for the image, the import
+	// failed. This is the case when Snyk could not pull the image or does not support the image. Snyk doesn't return
+	// the digest of the scanned manifest, so that we can not verify it. When the image was imported by its digest, the
+	// registry always returns the manifest for this digest.
+	if !found {
+		recordImportJob("no_projects")
+		return nil, &ImportFailedError{Status: importJob.Status, Messages: []string{fmt.Sprintf("import job does not contain the image %s", image)}}
+	}
+
+	if len(projectIDs) == 0 {
+		recordImportJob("no_projects")
+		return nil, &ImportFailedError{Status: importJob.Status, Messages: getUserMessages(importJob, image)}
+	}
+
+	recordImportJob("complete")
+	return projectIDs, nil
+}
+
+// GetAggregatedIssues returns the issues for all the given projects via the aggregated issues endpoint of the v1 API.
+func (c *client) GetAggregatedIssues(ctx context.Context, projectIDs []string) ([]Issue, error) {
+	return c.getIssues(ctx, projectIDs, c.getAggregatedIssues)
+}
+
+// getIssues returns the issues for all the given projects, where the issues of a single project are fetched via the
+// given function. The issues are fetched by a pool of workers, where the number of workers is limited by the configured
+// concurrency. The returned issues are in the same order as the given projects. If the issues for one or more projects
+// could not be fetched, all errors are returned as ProjectErrors. Each project is fetched within its own span, so that a
+// slow project is visible in the trace of a scan.
+func (c *client) getIssues(ctx context.Context, projectIDs []string, fetch func(ctx context.Context, project string) ([]Issue, error)) (issues []Issue, err error) {
+	ctx, span := startSpan(ctx, "GetIssues", attribute.String("snyk.organisation.id", c.organisationID), attribute.Int("snyk.projects", len(projectIDs)))
+	defer func() { endSpan(span, err) }()
+
+	results := make([][]Issue, len(projectIDs))
+	errs := make([]error, len(projectIDs))
+
+	workers := c.concurrency
+	if workers > len(projectIDs) {
+		workers = len(projectIDs)
+	}
+	if workers < 1 {
+		workers = 1
+	}
 
-		if importJob.Status != "complete" {
-			return nil, fmt.Errorf("import job is not completed yet")
+	indices := make(chan int)
+
+	var wg sync.WaitGroup
+	wg.Add(workers)
+
+	for i := 0; i < workers; i++ {
+		go func() {
+			defer wg.Done()
+
+			for index := range indices {
+				projectCtx, projectSpan := startSpan(ctx, "GetProjectIssues", attribute.String("snyk.project.id", projectIDs[index]))
+				results[index], errs[index] = fetch(projectCtx, projectIDs[index])
+				endSpan(projectSpan, errs[index])
+			}
+		}()
+	}
+
+	for index := range projectIDs {
+		indices <- index
+	}
+
+	close(indices)
+	wg.Wait()
+
+	var projectErrs ProjectErrors
+
+	for index, projectID := range projectIDs {
+		if errs[index] != nil {
+			projectErrs = append(projectErrs, &ProjectError{ProjectID: projectID, Err: errs[index]})
+			continue
 		}
 
-		var projectIDs []string
-		for _, log := range importJob.Logs {
-			if log.Name == image {
-				for _, project := range log.Projects {
-					if project.Success {
-						projectIDs = append(projectIDs, project.ProjectID)
-					}
+		issues = append(issues, results[index]...)
+	}
+
+	if projectErrs != nil {
+		return nil, projectErrs
+	}
+
+	return issues, nil
+}
+
+// getUserMessages returns the messages of all projects in the import job for the given image, which could not be
+// imported.
+func getUserMessages(importJob ImportJobResponse, image string) []string {
+	var messages []string
+
+	for _, log := range importJob.Logs {
+		if matchImage(log.Name, image) {
+			for _, project := range log.Projects {
+				if !project.Success && project.UserMessage != "" {
+					messages = append(messages, project.UserMessage)
 				}
 			}
 		}
+	}
 
-		var issues []Issue
-		var issuesErr error
+	return messages
+}
 
-		var wg sync.WaitGroup
-		wg.Add(len(projectIDs))
+// DeleteProject deletes the project with the given id from Snyk.
+func (c *client) DeleteProject(ctx context.Context, projectID string) error {
+	req, err := http.NewRequestWithContext(withRetries(ctx, c.maxRetries), http.MethodDelete, fmt.Sprintf("%s/api/v1/org/%s/project/%s", c.baseURL, c.organisationID, projectID), nil)
+	if err != nil {
+		return err
+	}
 
-		for _, project := range projectIDs {
-			go func(project string) {
-				tmpIssues, err := c.getAggregatedIssues(ctx, project)
-				if err != nil {
-					issuesErr = err
-				} else {
-					issues = append(issues, tmpIssues...)
-				}
+	_, err = c.do(req, nil)
+	return err
+}
 
-				wg.Done()
-			}(project)
-		}
+// DeactivateProject deactivates the project with the given id in Snyk. A deactivated project is not tested anymore,
+// but it is still visible in Snyk.
+func (c *client) DeactivateProject(ctx context.Context, projectID string) error {
+	req, err := http.NewRequestWithContext(withRetries(ctx, c.maxRetries), http.MethodPost, fmt.Sprintf("%s/api/v1/org/%s/project/%s/deactivate", c.baseURL, c.organisationID, projectID), nil)
+	if err != nil {
+		return err
+	}
 
-		wg.Wait()
+	_, err = c.do(req, nil)
+	return err
+}
+
+// AddProjectTag adds the given tag to the project with the given id.
+func (c *client) AddProjectTag(ctx context.Context, projectID string, tag Tag) error {
+	body, err := json.Marshal(tag)
+	if err != nil {
+		return err
+	}
 
-		return issues, issuesErr
+	req, err := http.NewRequestWithContext(withRetries(ctx, c.maxRetries), http.MethodPost, fmt.Sprintf("%s/api/v1/org/%s/project/%s/tags", c.baseURL, c.organisationID, projectID), bytes.NewBuffer(body))
+	if err != nil {
+		return err
 	}
 
-	var res ErrorResponse
+	_, err = c.do(req, nil)
+	return err
+}
 
-	err = json.NewDecoder(resp.Body).Decode(&res)
+// GetProjectTags returns the tags of the project with the given id.
+func (c *client) GetProjectTags(ctx context.Context, projectID string) ([]Tag, error) {
+	req, err := http.NewRequestWithContext(withRetries(ctx, c.maxRetries), http.MethodGet, fmt.Sprintf("%s/api/v1/org/%s/project/%s", c.baseURL, c.organisationID, projectID), nil)
 	if err != nil {
 		return nil, err
 	}
 
-	return nil, fmt.Errorf("%s", res.Message)
+	var project ProjectResponse
+
+	if _, err := c.do(req, &project); err != nil {
+		return nil, err
+	}
+
+	return project.Tags, nil
+}
+
+// RemoveProjectTag removes the given tag from the project with the given id. The key and the value of the tag must
+// match.
+func (c *client) RemoveProjectTag(ctx context.Context, projectID string, tag Tag) error {
+	body, err := json.Marshal(tag)
+	if err != nil {
+		return err
+	}
+
+	req, err := http.NewRequestWithContext(withRetries(ctx, c.maxRetries), http.MethodPost, fmt.Sprintf("%s/api/v1/org/%s/project/%s/tags/remove", c.baseURL, c.organisationID, projectID), bytes.NewBuffer(body))
+	if err != nil {
+		return err
+	}
+
+	_, err = c.do(req, nil)
+	return err
+}
+
+// SetProjectAttributes sets the criticality, environment and lifecycle attributes of the project with the given id.
+// Snyk replaces all attributes of the project with the given attributes.
+func (c *client) SetProjectAttributes(ctx context.Context, projectID string, attributes ProjectAttributes) error {
+	body, err := json.Marshal(attributes)
+	if err != nil {
+		return err
+	}
+
+	req, err := http.NewRequestWithContext(withRetries(ctx, c.maxRetries), http.MethodPost, fmt.Sprintf("%s/api/v1/org/%s/project/%s/attributes", c.baseURL, c.organisationID, projectID), bytes.NewBuffer(body))
+	if err != nil {
+		return err
+	}
+
+	_, err = c.do(req, nil)
+	return err
+}
+
+// WithOrganisation returns a copy of the client, which uses the given organisation, integration and API key. If the API
+// key is empty the API key of the current client is used.
+func (c *client) WithOrganisation(organisationID, integrationID, apiKey string) Client {
+	clone := *c
+	clone.organisationID = organisationID
+	clone.integrationID = integrationID
+	if apiKey != "" {
+		clone.apiKey = apiKey
+	}
+
+	return &clone
+}
+
+// validateFilters returns an error, when the given filters for the aggregated issues contain an unknown value or when
+// the priority range is invalid. Snyk silently ignores unknown values, so that a typo would result in an empty scan
+// report, which we want to avoid.
+func validateFilters(filters AggregatedIssuesFilters) error {
+	for _, filter := range []struct {
+		name    string
+		values  []string
+		allowed []string
+	}{
+		{name: "severities", values: filters.Severities, allowed: []string{"critical", "high", "medium", "low"}},
+		{name: "exploitMaturity", values: filters.ExploitMaturity, allowed: []string{"mature", "proof-of-concept", "no-known-exploit", "no-data"}},
+		{name: "types", values: filters.Types, allowed: []string{IssueTypeVuln, IssueTypeLicense}},
+	} {
+		if len(filter.values) == 0 {
+			return fmt.Errorf("%s filter must not be empty", filter.name)
+		}
+
+		for _, value := range filter.values {
+			if !contains(filter.allowed, value) {
+				return fmt.Errorf("invalid value %q for %s filter, must be one of %s", value, filter.name, strings.Join(filter.allowed, ", "))
+			}
+		}
+	}
+
+	if filters.Priority.Score.Min < 0 || filters.Priority.Score.Max > 1000 || filters.Priority.Score.Min > filters.Priority.Score.Max {
+		return fmt.Errorf("invalid priority score range %d-%d, must be within 0-1000", filters.Priority.Score.Min, filters.Priority.Score.Max)
+	}
+
+	return nil
+}
+
+// ValidateProjectAttributes returns an error, when one of the given project attributes has a value which is not
+// supported by Snyk.
+func ValidateProjectAttributes(attributes ProjectAttributes) error {
+	for _, attribute := range []struct {
+		name    string
+		values  []string
+		allowed []string
+	}{
+		{name: "criticality", values: attributes.Criticality, allowed: []string{"critical", "high", "medium", "low"}},
+		{name: "environment", values: attributes.Environment, allowed: []string{"frontend", "backend", "internal", "external", "mobile", "saas", "onprem", "hosted", "distributed"}},
+		{name: "lifecycle", values: attributes.Lifecycle, allowed: []string{"production", "development", "sandbox"}},
+	} {
+		for _, value := range attribute.values {
+			if !contains(attribute.allowed, value) {
+				return fmt.Errorf("invalid value %q for %s attribute, must be one of %s", value, attribute.name, strings.Join(attribute.allowed, ", "))
+			}
+		}
+	}
+
+	return nil
+}
+
+func contains(values []string, value string) bool {
+	for _, v := range values {
+		if v == value {
+			return true
+		}
+	}
+
+	return false
 }
 
-func NewClient() Client {
-	return &client{
+// NewClient returns a new client for the Snyk API, which is configured via the flags of the package. An error is
+// returned when the configured filters for the aggregated issues or the options for the transport are invalid.
+func NewClient() (Client, error) {
+	types := filterTypes
+	if includeLicenseIssues && !contains(types, IssueTypeLicense) {
+		types = append(append([]string{}, types...), IssueTypeLicense)
+	}
+
+	filters := AggregatedIssuesFilters{
+		Severities:      filterSeverities,
+		ExploitMaturity: filterExploitMaturity,
+		Types:           types,
+		Ignored:         filterIgnored,
+		Patched:         filterPatched,
+	}
+	filters.Priority.Score.Min = filterPriorityMin
+	filters.Priority.Score.Max = filterPriorityMax
+
+	if err := validateFilters(filters); err != nil {
+		return nil, err
+	}
+
+	transport, err := newHTTPTransport(transportOptions{
+		ProxyURL:            proxyURL,
+		NoProxy:             noProxy,
+		CAFile:              caFile,
+		ClientCertFile:      clientCertFile,
+		ClientKeyFile:       clientKeyFile,
+		TLSMinVersion:       tlsMinVersion,
+		MaxIdleConns:        maxIdleConns,
+		MaxIdleConnsPerHost: maxIdleConnsPerHost,
+		MaxConnsPerHost:     maxConnsPerHost,
+		IdleConnTimeout:     idleConnTimeout,
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	c := &client{
 		apiKey:         apiKey,
 		baseURL:        baseURL,
 		integrationID:  integrationID,
 		organisationID: organisationID,
+		maxRetries:     maxRetries,
+		importRetries:  importRetries,
+		concurrency:    concurrency,
+		restVersion:    restVersion,
+		filters:        filters,
 		httpClient: &http.Client{
-			Timeout: 60 * time.Second,
+			Transport: newRetryTransport(&metricsTransport{next: newTracingTransport(transport)}, timeout, maxRetries, retryWaitMin, retryWaitMax, rateLimit, rateLimitBurst),
 		},
 	}
+
+	switch api {
+	case "v1":
+		return c, nil
+	case "rest":
+		if err := validateRESTFilters(filters); err != nil {
+			return nil, err
+		}
+
+		return &restClient{client: c}, nil
+	default:
+		return nil, fmt.Errorf("invalid api %q, must be \"v1\" or \"rest\"", api)
+	}
+}
+
+// matchImage returns true, when the name of an image in an import job is the given image. When the image contains a
+// digest, the name also matches, when it is the same repository with the same digest, because Snyk may add the tag to
+// the name of an image, which was imported by its digest.
+func matchImage(name, image string) bool {
+	if name == image {
+		return true
+	}
+
+	_, _, digest := SplitImage(image)
+	_, _, nameDigest := SplitImage(name)
+
+	return digest != "" && digest == nameDigest && getImageRepository(name) == getImageRepository(image)
+}
+
+// SplitImage splits the given image into the repository, the tag and the digest. The tag and the digest are empty,
+// when they are not part of the image.
+func SplitImage(image string) (string, string, string) {
+	var tag, digest string
+
+	if index := strings.Index(image, "@"); index >= 0 {
+		digest = image[index+1:]
+		image = image[:index]
+	}
+
+	if index := strings.LastIndex(image, ":"); index > strings.LastIndex(image, "/") {
+		tag = image[index+1:]
+		image = image[:index]
+	}
+
+	return image, tag, digest
 }
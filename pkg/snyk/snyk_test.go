@@ -0,0 +1,448 @@
+package snyk
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/require"
+)
+
+func TestCheckLocation(t *testing.T) {
+	c := &client{baseURL: "https://snyk.io", organisationID: "org", integrationID: "integration"}
+
+	require.NoError(t, c.checkLocation("https://snyk.io/api/v1/org/org/integrations/integration/import/job"))
+
+	require.Error(t, c.checkLocation("https://example.com/api/v1/org/org/integrations/integration/import/job"))
+	require.Error(t, c.checkLocation("http://snyk.io/api/v1/org/org/integrations/integration/import/job"))
+	require.Error(t, c.checkLocation("https://user@snyk.io/api/v1/org/org/integrations/integration/import/job"))
+	require.Error(t, c.checkLocation("https://snyk.io.example.com/api/v1/org/org/integrations/integration/import/job"))
+	require.Error(t, c.checkLocation("https://snyk.io/api/v1/org/other/integrations/integration/import/job"))
+	require.Error(t, c.checkLocation("https://snyk.io/api/v1/org/org/integrations/integration/import/../../../../other"))
+}
+
+func TestImportProject(t *testing.T) {
+	image := `library/nginx:latest", "files": [{"path": "\\"}]`
+
+	ts := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		var importRequest map[string]map[string]interface{}
+		require.NoError(t, json.NewDecoder(r.Body).Decode(&importRequest))
+		require.Equal(t, map[string]map[string]interface{}{"target": {"name": image}}, importRequest)
+
+		w.Header().Set("Location", "http://localhost/api/v1/org/org/integrations/integration/import/job")
+		w.WriteHeader(http.StatusCreated)
+	}))
+	defer ts.Close()
+
+	c := &client{baseURL: ts.URL, organisationID: "org", integrationID: "integration", httpClient: ts.Client()}
+
+	location, err := c.ImportProject(context.Background(), image)
+	require.NoError(t, err)
+	require.Equal(t, "http://localhost/api/v1/org/org/integrations/integration/import/job", location)
+}
+
+func TestGetProjectIDs(t *testing.T) {
+	for _, tt := range []struct {
+		name               string
+		importJob          string
+		expectedProjectIDs []string
+		expectedErr        string
+		expectedFailed     bool
+	}{
+		{
+			name:        "pending",
+			importJob:   `{"id": "job", "status": "pending", "logs": []}`,
+			expectedErr: "import job is not completed yet",
+		},
+		{
+			name:           "failed",
+			importJob:      `{"id": "job", "status": "failed", "logs": [{"name": "library/nginx:latest", "projects": [{"success": false, "userMessage": "Image not found"}]}]}`,
+			expectedErr:    "import job failed: Image not found",
+			expectedFailed: true,
+		},
+		{
+			name:           "complete without successful projects",
+			importJob:      `{"id": "job", "status": "complete", "logs": [{"name": "library/nginx:latest", "projects": [{"success": false, "userMessage": "Unsupported OS"}, {"success": false, "userMessage": "Credentials rejected"}]}]}`,
+			expectedErr:    "import job complete: Unsupported OS; Credentials rejected",
+			expectedFailed: true,
+		},
+		{
+			name:           "complete without image",
+			importJob:      `{"id": "job", "status": "complete", "logs": [{"name": "library/nginx:other", "projects": [{"success": true, "projectId": "project1"}]}]}`,
+			expectedErr:    "import job complete: import job does not contain the image library/nginx:latest",
+			expectedFailed: true,
+		},
+		{
+			name:               "complete",
+			importJob:          `{"id": "job", "status": "complete", "logs": [{"name": "library/nginx:latest", "projects": [{"success": true, "projectId": "project1"}, {"success": false, "userMessage": "Unsupported OS"}, {"success": true, "projectId": "project2"}]}]}`,
+			expectedProjectIDs: []string{"project1", "project2"},
+		},
+	} {
+		t.Run(tt.name, func(t *testing.T) {
+			ts := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+				fmt.Fprint(w, tt.importJob)
+			}))
+			defer ts.Close()
+
+			c := &client{baseURL: ts.URL, organisationID: "org", integrationID: "integration", httpClient: ts.Client()}
+
+			projectIDs, err := c.GetProjectIDs(context.Background(), "library/nginx:latest", ts.URL+"/api/v1/org/org/integrations/integration/import/job")
+			if tt.expectedErr != "" {
+				require.EqualError(t, err, tt.expectedErr)
+
+				var importFailedErr *ImportFailedError
+				require.Equal(t, tt.expectedFailed, errors.As(err, &importFailedErr))
+			} else {
+				require.NoError(t, err)
+				require.Equal(t, tt.expectedProjectIDs, projectIDs)
+			}
+		})
+	}
+}
+
+func TestGetProjectIDsByDigest(t *testing.T) {
+	image := "library/nginx@sha256:1234"
+
+	for _, tt := range []struct {
+		name               string
+		importJob          string
+		expectedProjectIDs []string
+		expectedErr        error
+	}{
+		{
+			name:               "same name",
+			importJob:          `{"id": "job", "status": "complete", "logs": [{"name": "library/nginx@sha256:1234", "projects": [{"success": true, "projectId": "project1"}]}]}`,
+			expectedProjectIDs: []string{"project1"},
+		},
+		{
+			name:               "name with tag",
+			importJob:          `{"id": "job", "status": "complete", "logs": [{"name": "library/nginx:latest@sha256:1234", "projects": [{"success": true, "projectId": "project1"}]}]}`,
+			expectedProjectIDs: []string{"project1"},
+		},
+		{
+			name:        "other digest",
+			importJob:   `{"id": "job", "status": "complete", "logs": [{"name": "library/nginx@sha256:5678", "projects": [{"success": true, "projectId": "project1"}]}]}`,
+			expectedErr: ErrImportFailed,
+		},
+	} {
+		t.Run(tt.name, func(t *testing.T) {
+			ts := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+				fmt.Fprint(w, tt.importJob)
+			}))
+			defer ts.Close()
+
+			c := &client{baseURL: ts.URL, organisationID: "org", integrationID: "integration", httpClient: ts.Client()}
+
+			projectIDs, err := c.GetProjectIDs(context.Background(), image, ts.URL+"/api/v1/org/org/integrations/integration/import/job")
+			if tt.expectedErr != nil {
+				require.ErrorIs(t, err, tt.expectedErr)
+				require.EqualError(t, err, "import job complete: import job does not contain the image library/nginx@sha256:1234")
+			} else {
+				require.NoError(t, err)
+				require.Equal(t, tt.expectedProjectIDs, projectIDs)
+			}
+		})
+	}
+}
+
+func TestSplitImage(t *testing.T) {
+	for _, tt := range []struct {
+		image      string
+		repository string
+		tag        string
+		digest     string
+	}{
+		{image: "library/nginx", repository: "library/nginx"},
+		{image: "library/nginx:latest", repository: "library/nginx", tag: "latest"},
+		{image: "library/nginx@sha256:1234", repository: "library/nginx", digest: "sha256:1234"},
+		{image: "library/nginx:latest@sha256:1234", repository: "library/nginx", tag: "latest", digest: "sha256:1234"},
+		{image: "harbor.example.com:443/library/nginx:latest", repository: "harbor.example.com:443/library/nginx", tag: "latest"},
+	} {
+		repository, tag, digest := SplitImage(tt.image)
+		require.Equal(t, tt.repository, repository, tt.image)
+		require.Equal(t, tt.tag, tag, tt.image)
+		require.Equal(t, tt.digest, digest, tt.image)
+	}
+
+	require.True(t, matchImage("library/nginx:latest", "library/nginx:latest"))
+	require.True(t, matchImage("library/nginx:latest@sha256:1234", "library/nginx@sha256:1234"))
+	require.False(t, matchImage("library/nginx:latest", "library/nginx@sha256:1234"))
+	require.False(t, matchImage("library/nginx:latest", "library/nginx:1.21"))
+	require.False(t, matchImage("library/httpd@sha256:1234", "library/nginx@sha256:1234"))
+}
+
+func TestErrors(t *testing.T) {
+	ts := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		switch r.URL.Path {
+		case "/unauthorized":
+			w.WriteHeader(http.StatusUnauthorized)
+			fmt.Fprint(w, `{"code": 401, "message": "Invalid auth token provided", "error": "Unauthorized"}`)
+		case "/not-found":
+			w.WriteHeader(http.StatusNotFound)
+			fmt.Fprint(w, `not found`)
+		case "/rate-limited":
+			w.Header().Set("Retry-After", "30")
+			w.WriteHeader(http.StatusTooManyRequests)
+		case "/unavailable":
+			w.WriteHeader(http.StatusServiceUnavailable)
+		}
+	}))
+
+	c := &client{baseURL: ts.URL, httpClient: ts.Client()}
+
+	do := func(path string) error {
+		req, err := http.NewRequest(http.MethodGet, ts.URL+path, nil)
+		require.NoError(t, err)
+
+		_, err = c.do(req, nil)
+		return err
+	}
+
+	err := do("/unauthorized")
+	require.ErrorIs(t, err, ErrUnauthorized)
+	require.EqualError(t, err, "401: Invalid auth token provided")
+
+	err = do("/not-found")
+	require.ErrorIs(t, err, ErrNotFound)
+	require.EqualError(t, err, "404: not found")
+
+	err = do("/rate-limited")
+	require.ErrorIs(t, err, ErrRateLimited)
+	var rateLimitErr *RateLimitError
+	require.True(t, errors.As(err, &rateLimitErr))
+	require.Equal(t, 30*time.Second, rateLimitErr.RetryAfter)
+
+	require.ErrorIs(t, do("/unavailable"), ErrUnavailable)
+
+	ts.Close()
+	err = do("/unauthorized")
+	require.ErrorIs(t, err, ErrUnavailable)
+	require.False(t, errors.Is(err, ErrUnauthorized))
+}
+
+func TestParseRetryAfter(t *testing.T) {
+	now := time.Date(2022, 1, 1, 0, 0, 0, 0, time.UTC)
+
+	require.Equal(t, 120*time.Second, parseRetryAfter("120", now))
+	require.Equal(t, 90*time.Second, parseRetryAfter("Sat, 01 Jan 2022 00:01:30 GMT", now))
+	require.Equal(t, time.Duration(0), parseRetryAfter("Fri, 31 Dec 2021 23:00:00 GMT", now))
+	require.Equal(t, 60*time.Second, parseRetryAfter("", now))
+	require.Equal(t, 60*time.Second, parseRetryAfter("invalid", now))
+}
+
+func TestGetAggregatedIssues(t *testing.T) {
+	var inFlight, maxInFlight int32
+
+	ts := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		current := atomic.AddInt32(&inFlight, 1)
+		defer atomic.AddInt32(&inFlight, -1)
+
+		for {
+			max := atomic.LoadInt32(&maxInFlight)
+			if current <= max || atomic.CompareAndSwapInt32(&maxInFlight, max, current) {
+				break
+			}
+		}
+
+		time.Sleep(time.Millisecond)
+
+		project := strings.Split(r.URL.Path, "/")[6]
+		if strings.HasPrefix(project, "failing") {
+			w.WriteHeader(http.StatusNotFound)
+			fmt.Fprint(w, `{"code": 404, "message": "Project not found"}`)
+			return
+		}
+
+		fmt.Fprintf(w, `{"issues": [{"id": "%s-1"}, {"id": "%s-2"}]}`, project, project)
+	}))
+	defer ts.Close()
+
+	c := &client{baseURL: ts.URL, organisationID: "org", concurrency: 4, httpClient: ts.Client()}
+
+	t.Run("many projects", func(t *testing.T) {
+		var projectIDs []string
+		var expectedIDs []string
+
+		for i := 0; i < 100; i++ {
+			projectIDs = append(projectIDs, fmt.Sprintf("project%d", i))
+			expectedIDs = append(expectedIDs, fmt.Sprintf("project%d-1", i), fmt.Sprintf("project%d-2", i))
+		}
+
+		issues, err := c.GetAggregatedIssues(context.Background(), projectIDs)
+		require.NoError(t, err)
+
+		var ids []string
+		for _, issue := range issues {
+			ids = append(ids, issue.ID)
+		}
+
+		require.Equal(t, expectedIDs, ids)
+		require.LessOrEqual(t, atomic.LoadInt32(&maxInFlight), int32(4))
+	})
+
+	t.Run("failing projects", func(t *testing.T) {
+		issues, err := c.GetAggregatedIssues(context.Background(), []string{"project1", "failing1", "project2", "failing2"})
+		require.Nil(t, issues)
+		require.EqualError(t, err, "project failing1: 404: Project not found; project failing2: 404: Project not found")
+		require.ErrorIs(t, err, ErrNotFound)
+
+		var apiErr *APIError
+		require.True(t, errors.As(err, &apiErr))
+		require.Equal(t, http.StatusNotFound, apiErr.StatusCode)
+
+		var projectErrs ProjectErrors
+		require.True(t, errors.As(err, &projectErrs))
+		require.Len(t, projectErrs, 2)
+		require.Equal(t, "failing1", projectErrs[0].ProjectID)
+	})
+
+	t.Run("no projects", func(t *testing.T) {
+		issues, err := c.GetAggregatedIssues(context.Background(), nil)
+		require.NoError(t, err)
+		require.Empty(t, issues)
+	})
+}
+
+func TestAggregatedIssuesFilters(t *testing.T) {
+	filters := AggregatedIssuesFilters{
+		Severities:      []string{"critical", "high"},
+		ExploitMaturity: []string{"mature"},
+		Types:           []string{"vuln", "license"},
+		Ignored:         true,
+	}
+	filters.Priority.Score.Min = 500
+	filters.Priority.Score.Max = 1000
+
+	ts := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		var req AggregatedIssuesRequest
+		require.NoError(t, json.NewDecoder(r.Body).Decode(&req))
+		require.True(t, req.IncludeDescription)
+		require.Equal(t, filters, req.Filters)
+
+		fmt.Fprint(w, `{"issues": []}`)
+	}))
+	defer ts.Close()
+
+	c := &client{baseURL: ts.URL, organisationID: "org", concurrency: 1, filters: filters, httpClient: ts.Client()}
+
+	_, err := c.GetAggregatedIssues(context.Background(), []string{"project"})
+	require.NoError(t, err)
+}
+
+func TestValidateFilters(t *testing.T) {
+	newFilters := func(severities []string, min, max int) AggregatedIssuesFilters {
+		filters := AggregatedIssuesFilters{
+			Severities:      severities,
+			ExploitMaturity: []string{"mature", "proof-of-concept", "no-known-exploit", "no-data"},
+			Types:           []string{"vuln"},
+		}
+		filters.Priority.Score.Min = min
+		filters.Priority.Score.Max = max
+
+		return filters
+	}
+
+	require.NoError(t, validateFilters(newFilters([]string{"critical", "high", "medium", "low"}, 0, 1000)))
+	require.EqualError(t, validateFilters(newFilters([]string{"critical", "hihg"}, 0, 1000)), "invalid value \"hihg\" for severities filter, must be one of critical, high, medium, low")
+	require.EqualError(t, validateFilters(newFilters(nil, 0, 1000)), "severities filter must not be empty")
+	require.EqualError(t, validateFilters(newFilters([]string{"high"}, 800, 500)), "invalid priority score range 800-500, must be within 0-1000")
+	require.Error(t, validateFilters(newFilters([]string{"high"}, 0, 1001)))
+}
+
+func TestFindProjects(t *testing.T) {
+	var ts *httptest.Server
+	ts = httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		require.Equal(t, "/rest/orgs/org/projects", r.URL.Path)
+		require.Equal(t, "2023-05-29", r.URL.Query().Get("version"))
+		require.Equal(t, "library/nginx", r.URL.Query().Get("names_start_with"))
+		require.Equal(t, "token apikey", r.Header.Get("Authorization"))
+
+		now := time.Now().UTC()
+
+		if r.URL.Query().Get("starting_after") == "" {
+			fmt.Fprintf(w, `{"data": [
+				{"id": "project1", "attributes": {"name": "library/nginx:latest", "created": %q}, "meta": {"latest_issue_counts": {"updated_at": %q}}},
+				{"id": "project2", "attributes": {"name": "library/nginx:latest:/app/package.json", "created": %q}},
+				{"id": "project3", "attributes": {"name": "library/nginx:1.21", "created": %q}}
+			], "links": {"next": "/orgs/org/projects?version=2023-05-29&names_start_with=library%%2Fnginx&starting_after=abc"}}`, now.Add(-48*time.Hour).Format(time.RFC3339), now.Add(-1*time.Hour).Format(time.RFC3339), now.Add(-1*time.Hour).Format(time.RFC3339), now.Format(time.RFC3339))
+			return
+		}
+
+		fmt.Fprintf(w, `{"data": [
+			{"id": "project4", "attributes": {"name": "library/nginx:latest", "created": %q}},
+			{"id": "project5", "attributes": {"name": "library/nginx:old", "created": %q, "tags": [{"key": "digest", "value": "sha256:1234"}]}}
+		], "links": {}}`, now.Add(-48*time.Hour).Format(time.RFC3339), now.Format(time.RFC3339))
+	}))
+	defer ts.Close()
+
+	c := &client{apiKey: "apikey", baseURL: ts.URL, organisationID: "org", restVersion: "2023-05-29", httpClient: ts.Client()}
+
+	projectIDs, err := c.FindProjects(context.Background(), "library/nginx:latest", "sha256:1234", 24*time.Hour)
+	require.NoError(t, err)
+	require.Equal(t, []string{"project1", "project2", "project5"}, projectIDs)
+}
+
+func TestListProjects(t *testing.T) {
+	ts := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		require.Equal(t, "/rest/orgs/org/projects", r.URL.Path)
+		require.Equal(t, "harbor-cr", r.URL.Query().Get("origins"))
+		require.Equal(t, "token apikey", r.Header.Get("Authorization"))
+
+		fmt.Fprint(w, `{"data": [
+			{"id": "project1", "attributes": {"name": "library/nginx:latest", "origin": "harbor-cr"}},
+			{"id": "project2", "attributes": {"name": "library/nginx@sha256:1234", "origin": "harbor-cr"}}
+		], "links": {}}`)
+	}))
+	defer ts.Close()
+
+	c := &client{apiKey: "apikey", baseURL: ts.URL, organisationID: "org", restVersion: "2023-05-29", httpClient: ts.Client()}
+
+	projects, err := c.ListProjects(context.Background(), []string{"harbor-cr"})
+	require.NoError(t, err)
+	require.Len(t, projects, 2)
+	require.Equal(t, "project1", projects[0].ID)
+	require.Equal(t, "library/nginx@sha256:1234", projects[1].Name)
+	require.Equal(t, "harbor-cr", projects[1].Origin)
+}
+
+func TestNextURL(t *testing.T) {
+	c := &client{baseURL: "https://api.snyk.io"}
+
+	next, err := c.nextURL("/orgs/org/projects?starting_after=abc")
+	require.NoError(t, err)
+	require.Equal(t, "https://api.snyk.io/rest/orgs/org/projects?starting_after=abc", next)
+
+	next, err = c.nextURL("/rest/orgs/org/projects?starting_after=abc")
+	require.NoError(t, err)
+	require.Equal(t, "https://api.snyk.io/rest/orgs/org/projects?starting_after=abc", next)
+
+	_, err = c.nextURL("https://example.com/rest/orgs/org/projects?starting_after=abc")
+	require.Error(t, err)
+}
+
+func TestGetImageRepository(t *testing.T) {
+	require.Equal(t, "library/nginx", getImageRepository("library/nginx:latest"))
+	require.Equal(t, "library/nginx", getImageRepository("library/nginx@sha256:1234"))
+	require.Equal(t, "harbor.example.com:443/library/nginx", getImageRepository("harbor.example.com:443/library/nginx:latest"))
+	require.Equal(t, "harbor.example.com:443/library/nginx", getImageRepository("harbor.example.com:443/library/nginx"))
+}
+
+func TestWithOrganisation(t *testing.T) {
+	c := &client{apiKey: "apikey", baseURL: "https://snyk.io", organisationID: "org", integrationID: "integration"}
+
+	routed := c.WithOrganisation("org-a", "integration-a", "").(*client)
+	require.Equal(t, "apikey", routed.apiKey)
+	require.NoError(t, routed.checkLocation("https://snyk.io/api/v1/org/org-a/integrations/integration-a/import/job"))
+	require.Error(t, routed.checkLocation("https://snyk.io/api/v1/org/org/integrations/integration/import/job"))
+	require.Equal(t, "org", c.organisationID)
+
+	routed = c.WithOrganisation("org-b", "integration-b", "apikey-b").(*client)
+	require.Equal(t, "apikey-b", routed.apiKey)
+}
@@ -0,0 +1,657 @@
+// Package snyktest implements a fake of the Snyk API, which can be used in tests and for the local development of the
+// scanner. The fake supports the endpoints of the v1 API, which are used by the Snyk client: the import of an image
+// via an integration, the status of an import job, the aggregated issues of a project and the endpoints to remove,
+// tag and set the attributes of a project.
+//
+// The images which can be imported are defined via fixtures. For each image the fixture defines how often the import
+// job is returned as pending, if the import fails and which projects with which issues are created. To test the
+// error handling of the client, faults like latency, rate limits, server errors and malformed responses can be
+// injected for all or only for some requests.
+package snyktest
+
+import (
+	"encoding/json"
+	"fmt"
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/ricoberger/harbor-snyk-scanner/pkg/snyk"
+
+	"github.com/go-chi/chi/v5"
+	flag "github.com/spf13/pflag"
+)
+
+// Fixtures contains all images, which can be imported into the fake Snyk API. The key of the map is the name of the
+// image, which is passed as target to the import endpoint.
+type Fixtures struct {
+	Images map[string]Image `json:"images"`
+}
+
+// Image is the fixture for a single image. The import job for the image is returned as pending for the number of
+// PendingPolls. Afterwards the import job is completed with the configured projects or it is failed, when a
+// FailedMessage is set.
+type Image struct {
+	PendingPolls  int       `json:"pendingPolls"`
+	FailedMessage string    `json:"failedMessage"`
+	Projects      []Project `json:"projects"`
+}
+
+// Project is a project, which is created for an image. When a UserMessage is set, the import of the project failed and
+// the project is reported as not successful in the import job.
+type Project struct {
+	ID          string       `json:"id"`
+	TargetFile  string       `json:"targetFile"`
+	UserMessage string       `json:"userMessage"`
+	Issues      []snyk.Issue `json:"issues"`
+}
+
+// Fault is injected into all requests, where the method and the path are matching. An empty method matches all
+// methods and the path is matched as prefix. The request is delayed by the configured latency. When a status code is
+// set, the status code, the header and the body are returned instead of the real response. A status code of 200 with
+// an invalid body can be used to return malformed JSON. When Count is greater than 0, the fault is only injected for
+// the given number of requests.
+type Fault struct {
+	Method     string
+	Path       string
+	Latency    time.Duration
+	StatusCode int
+	Header     http.Header
+	Body       string
+	Count      int
+}
+
+// Server is the fake Snyk API. It must be created via the New or NewServer function.
+type Server struct {
+	// URL is the base url of the fake Snyk API, when the server was created via NewServer. It can be used as base url
+	// for the Snyk client.
+	URL string
+
+	APIKey         string
+	OrganisationID string
+	IntegrationID  string
+
+	mu          sync.Mutex
+	images      map[string]Image
+	jobs        map[string]*importJob
+	faults      []*Fault
+	requests    []string
+	deleted     []string
+	deactivated []string
+	tags        map[string][]snyk.Tag
+	attributes  map[string]snyk.ProjectAttributes
+	jobCounter  int
+	router      chi.Router
+	httpServer  *httptest.Server
+}
+
+type importJob struct {
+	id      string
+	image   string
+	polls   int
+	created time.Time
+}
+
+// New returns a new fake Snyk API for the given API key, organisation and integration. The returned server is not
+// started, it can be used as http.Handler, e.g. to run it on a fixed address during the local development.
+func New(apiKey, organisationID, integrationID string) *Server {
+	s := &Server{
+		APIKey:         apiKey,
+		OrganisationID: organisationID,
+		IntegrationID:  integrationID,
+		images:         make(map[string]Image),
+		jobs:           make(map[string]*importJob),
+		tags:           make(map[string][]snyk.Tag),
+		attributes:     make(map[string]snyk.ProjectAttributes),
+	}
+
+	router := chi.NewRouter()
+	router.Use(s.recordRequests)
+	router.Use(s.injectFaults)
+	router.Use(s.authenticate)
+	router.Post("/api/v1/org/{org}/integrations/{integration}/import", s.importImage)
+	router.Get("/api/v1/org/{org}/integrations/{integration}/import/{job}", s.getImportJob)
+	router.Post("/api/v1/org/{org}/project/{project}/aggregated-issues", s.getAggregatedIssues)
+	router.Get("/api/v1/org/{org}/project/{project}", s.getProjectDetails)
+	router.Delete("/api/v1/org/{org}/project/{project}", s.deleteProject)
+	router.Post("/api/v1/org/{org}/project/{project}/deactivate", s.deactivateProject)
+	router.Post("/api/v1/org/{org}/project/{project}/tags", s.addProjectTag)
+	router.Post("/api/v1/org/{org}/project/{project}/tags/remove", s.removeProjectTag)
+	router.Post("/api/v1/org/{org}/project/{project}/attributes", s.setProjectAttributes)
+	router.NotFound(func(w http.ResponseWriter, r *http.Request) {
+		writeError(w, http.StatusNotFound, "Not found")
+	})
+
+	s.router = router
+	return s
+}
+
+// NewServer returns a new fake Snyk API like New, which is started via the httptest package. The server must be closed
+// via the Close method, when it isn't used anymore.
+func NewServer(apiKey, organisationID, integrationID string) *Server {
+	s := New(apiKey, organisationID, integrationID)
+	s.httpServer = httptest.NewServer(s)
+	s.URL = s.httpServer.URL
+	return s
+}
+
+// Close closes the underlying httptest server.
+func (s *Server) Close() {
+	if s.httpServer != nil {
+		s.httpServer.Close()
+	}
+}
+
+func (s *Server) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	s.router.ServeHTTP(w, r)
+}
+
+// SetFlags sets the flags of the snyk package to the url, API key, organisation and integration of the server, so that
+// the client returned by snyk.NewClient uses the fake Snyk API.
+func (s *Server) SetFlags() error {
+	for name, value := range map[string]string{
+		"snyk.base-url":        s.URL,
+		"snyk.api-key":         s.APIKey,
+		"snyk.organisation-id": s.OrganisationID,
+		"snyk.integration-id":  s.IntegrationID,
+	} {
+		if err := flag.Set(name, value); err != nil {
+			return err
+		}
+	}
+
+	return nil
+}
+
+// AddImage adds the fixture for the given image.
+func (s *Server) AddImage(name string, image Image) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	s.images[name] = image
+}
+
+// LoadFixtures adds all images from the given JSON file.
+func (s *Server) LoadFixtures(file string) error {
+	data, err := ioutil.ReadFile(file)
+	if err != nil {
+		return err
+	}
+
+	var fixtures Fixtures
+	if err := json.Unmarshal(data, &fixtures); err != nil {
+		return err
+	}
+
+	for name, image := range fixtures.Images {
+		s.AddImage(name, image)
+	}
+
+	return nil
+}
+
+// AddFault adds the given fault. Faults are checked in the order they were added and only the first matching fault is
+// injected into a request.
+func (s *Server) AddFault(fault Fault) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	s.faults = append(s.faults, &fault)
+}
+
+// Requests returns all requests, which were received by the server in the format "METHOD PATH".
+func (s *Server) Requests() []string {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	return append([]string{}, s.requests...)
+}
+
+// DeletedProjects returns the ids of all deleted projects.
+func (s *Server) DeletedProjects() []string {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	return append([]string{}, s.deleted...)
+}
+
+// DeactivatedProjects returns the ids of all deactivated projects.
+func (s *Server) DeactivatedProjects() []string {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	return append([]string{}, s.deactivated...)
+}
+
+// ProjectTags returns the tags, which were added to the project with the given id.
+func (s *Server) ProjectTags(id string) []snyk.Tag {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	return append([]snyk.Tag{}, s.tags[id]...)
+}
+
+// ProjectAttributes returns the attributes, which were set for the project with the given id.
+func (s *Server) ProjectAttributes(id string) snyk.ProjectAttributes {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	return s.attributes[id]
+}
+
+func (s *Server) recordRequests(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		s.mu.Lock()
+		s.requests = append(s.requests, fmt.Sprintf("%s %s", r.Method, r.URL.Path))
+		s.mu.Unlock()
+
+		next.ServeHTTP(w, r)
+	})
+}
+
+func (s *Server) injectFaults(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		fault := s.getFault(r)
+		if fault == nil {
+			next.ServeHTTP(w, r)
+			return
+		}
+
+		if fault.Latency > 0 {
+			select {
+			case <-r.Context().Done():
+				return
+			case <-time.After(fault.Latency):
+			}
+		}
+
+		if fault.StatusCode == 0 {
+			next.ServeHTTP(w, r)
+			return
+		}
+
+		for key, values := range fault.Header {
+			for _, value := range values {
+				w.Header().Add(key, value)
+			}
+		}
+
+		w.Header().Set("Content-Type", "application/json; charset=utf-8")
+		w.WriteHeader(fault.StatusCode)
+		fmt.Fprint(w, fault.Body)
+	})
+}
+
+// getFault returns the first fault, which matches the given request. The count of the fault is decreased, when it is
+// limited to a number of requests.
+func (s *Server) getFault(r *http.Request) *Fault {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	for i, fault := range s.faults {
+		if (fault.Method == "" || fault.Method == r.Method) && strings.HasPrefix(r.URL.Path, fault.Path) {
+			if fault.Count > 0 {
+				fault.Count = fault.Count - 1
+				if fault.Count == 0 {
+					s.faults = append(s.faults[:i], s.faults[i+1:]...)
+				}
+			}
+
+			return fault
+		}
+	}
+
+	return nil
+}
+
+func (s *Server) authenticate(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if s.APIKey != "" && r.Header.Get("Authorization") != fmt.Sprintf("token: %s", s.APIKey) {
+			writeError(w, http.StatusUnauthorized, "Invalid auth token provided")
+			return
+		}
+
+		next.ServeHTTP(w, r)
+	})
+}
+
+// checkPath returns false and writes an error, when the organisation or integration in the path of the request are not
+// the ones of the server.
+func (s *Server) checkPath(w http.ResponseWriter, r *http.Request) bool {
+	if chi.URLParam(r, "org") != s.OrganisationID {
+		writeError(w, http.StatusNotFound, "Org not found")
+		return false
+	}
+
+	if integration := chi.URLParam(r, "integration"); integration != "" && integration != s.IntegrationID {
+		writeError(w, http.StatusNotFound, "Integration not found")
+		return false
+	}
+
+	return true
+}
+
+func (s *Server) importImage(w http.ResponseWriter, r *http.Request) {
+	if !s.checkPath(w, r) {
+		return
+	}
+
+	var body struct {
+		Target struct {
+			Name string `json:"name"`
+		} `json:"target"`
+	}
+
+	if err := json.NewDecoder(r.Body).Decode(&body); err != nil || body.Target.Name == "" {
+		writeError(w, http.StatusBadRequest, "Invalid target")
+		return
+	}
+
+	s.mu.Lock()
+	s.jobCounter = s.jobCounter + 1
+	job := &importJob{id: fmt.Sprintf("job-%d", s.jobCounter), image: body.Target.Name, created: time.Now()}
+	s.jobs[job.id] = job
+	s.mu.Unlock()
+
+	w.Header().Set("Location", fmt.Sprintf("%s://%s%s/%s", scheme(r), r.Host, r.URL.Path, job.id))
+	w.WriteHeader(http.StatusCreated)
+}
+
+func (s *Server) getImportJob(w http.ResponseWriter, r *http.Request) {
+	if !s.checkPath(w, r) {
+		return
+	}
+
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	job, ok := s.jobs[chi.URLParam(r, "job")]
+	if !ok {
+		writeError(w, http.StatusNotFound, "Import job not found")
+		return
+	}
+
+	job.polls = job.polls + 1
+
+	var response snyk.ImportJobResponse
+	response.ID = job.id
+	response.Created = job.created
+
+	image, ok := s.images[job.image]
+
+	switch {
+	case ok && job.polls <= image.PendingPolls:
+		response.Status = "pending"
+	case !ok:
+		response.Status = "failed"
+		response.Logs = newImportLogs(job.image, "failed", []Project{{UserMessage: "Image not found"}})
+	case image.FailedMessage != "":
+		response.Status = "failed"
+		response.Logs = newImportLogs(job.image, "failed", []Project{{UserMessage: image.FailedMessage}})
+	default:
+		response.Status = "complete"
+		response.Logs = newImportLogs(job.image, "complete", image.Projects)
+	}
+
+	writeJSON(w, http.StatusOK, response)
+}
+
+func (s *Server) getAggregatedIssues(w http.ResponseWriter, r *http.Request) {
+	if !s.checkPath(w, r) {
+		return
+	}
+
+	var request snyk.AggregatedIssuesRequest
+	if err := json.NewDecoder(r.Body).Decode(&request); err != nil {
+		writeError(w, http.StatusBadRequest, "Invalid body")
+		return
+	}
+
+	project, ok := s.getProject(chi.URLParam(r, "project"))
+	if !ok {
+		writeError(w, http.StatusNotFound, "Project not found")
+		return
+	}
+
+	response := snyk.IssuesResponse{Issues: []snyk.Issue{}}
+
+	for _, issue := range project.Issues {
+		if matchFilters(issue, request.Filters) {
+			response.Issues = append(response.Issues, issue)
+		}
+	}
+
+	writeJSON(w, http.StatusOK, response)
+}
+
+func (s *Server) deleteProject(w http.ResponseWriter, r *http.Request) {
+	if !s.checkPath(w, r) {
+		return
+	}
+
+	project, ok := s.getProject(chi.URLParam(r, "project"))
+	if !ok {
+		writeError(w, http.StatusNotFound, "Project not found")
+		return
+	}
+
+	s.mu.Lock()
+	s.deleted = append(s.deleted, project.ID)
+	s.mu.Unlock()
+
+	w.WriteHeader(http.StatusOK)
+}
+
+func (s *Server) deactivateProject(w http.ResponseWriter, r *http.Request) {
+	if !s.checkPath(w, r) {
+		return
+	}
+
+	project, ok := s.getProject(chi.URLParam(r, "project"))
+	if !ok {
+		writeError(w, http.StatusNotFound, "Project not found")
+		return
+	}
+
+	s.mu.Lock()
+	s.deactivated = append(s.deactivated, project.ID)
+	s.mu.Unlock()
+
+	w.WriteHeader(http.StatusOK)
+}
+
+func (s *Server) getProjectDetails(w http.ResponseWriter, r *http.Request) {
+	if !s.checkPath(w, r) {
+		return
+	}
+
+	project, ok := s.getProject(chi.URLParam(r, "project"))
+	if !ok {
+		writeError(w, http.StatusNotFound, "Project not found")
+		return
+	}
+
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	writeJSON(w, http.StatusOK, snyk.ProjectResponse{ID: project.ID, Tags: append([]snyk.Tag{}, s.tags[project.ID]...)})
+}
+
+func (s *Server) addProjectTag(w http.ResponseWriter, r *http.Request) {
+	if !s.checkPath(w, r) {
+		return
+	}
+
+	project, ok := s.getProject(chi.URLParam(r, "project"))
+	if !ok {
+		writeError(w, http.StatusNotFound, "Project not found")
+		return
+	}
+
+	var tag snyk.Tag
+	if err := json.NewDecoder(r.Body).Decode(&tag); err != nil || tag.Key == "" || tag.Value == "" {
+		writeError(w, http.StatusBadRequest, "Invalid tag")
+		return
+	}
+
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	for _, existing := range s.tags[project.ID] {
+		if existing == tag {
+			writeError(w, http.StatusUnprocessableEntity, "Tag already exists")
+			return
+		}
+	}
+
+	s.tags[project.ID] = append(s.tags[project.ID], tag)
+	writeJSON(w, http.StatusOK, map[string][]snyk.Tag{"tags": s.tags[project.ID]})
+}
+
+func (s *Server) removeProjectTag(w http.ResponseWriter, r *http.Request) {
+	if !s.checkPath(w, r) {
+		return
+	}
+
+	project, ok := s.getProject(chi.URLParam(r, "project"))
+	if !ok {
+		writeError(w, http.StatusNotFound, "Project not found")
+		return
+	}
+
+	var tag snyk.Tag
+	if err := json.NewDecoder(r.Body).Decode(&tag); err != nil || tag.Key == "" || tag.Value == "" {
+		writeError(w, http.StatusBadRequest, "Invalid tag")
+		return
+	}
+
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	tags := []snyk.Tag{}
+	for _, existing := range s.tags[project.ID] {
+		if existing != tag {
+			tags = append(tags, existing)
+		}
+	}
+
+	s.tags[project.ID] = tags
+	writeJSON(w, http.StatusOK, map[string][]snyk.Tag{"tags": tags})
+}
+
+func (s *Server) setProjectAttributes(w http.ResponseWriter, r *http.Request) {
+	if !s.checkPath(w, r) {
+		return
+	}
+
+	project, ok := s.getProject(chi.URLParam(r, "project"))
+	if !ok {
+		writeError(w, http.StatusNotFound, "Project not found")
+		return
+	}
+
+	var attributes snyk.ProjectAttributes
+	if err := json.NewDecoder(r.Body).Decode(&attributes); err != nil {
+		writeError(w, http.StatusBadRequest, "Invalid attributes")
+		return
+	}
+
+	if err := snyk.ValidateProjectAttributes(attributes); err != nil {
+		writeError(w, http.StatusUnprocessableEntity, err.Error())
+		return
+	}
+
+	s.mu.Lock()
+	s.attributes[project.ID] = attributes
+	s.mu.Unlock()
+
+	writeJSON(w, http.StatusOK, attributes)
+}
+
+// getProject returns the project with the given id from all fixtures. Deleted projects are not returned.
+func (s *Server) getProject(id string) (Project, bool) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	if contains(s.deleted, id) {
+		return Project{}, false
+	}
+
+	for _, image := range s.images {
+		for _, project := range image.Projects {
+			if project.ID == id && project.UserMessage == "" {
+				return project, true
+			}
+		}
+	}
+
+	return Project{}, false
+}
+
+// matchFilters returns true, when the given issue matches the severities, types and ignored filters from the request.
+func matchFilters(issue snyk.Issue, filters snyk.AggregatedIssuesFilters) bool {
+	if len(filters.Severities) > 0 && !contains(filters.Severities, issue.IssueData.Severity) {
+		return false
+	}
+
+	issueType := issue.IssueType
+	if issueType == "" {
+		issueType = snyk.IssueTypeVuln
+	}
+
+	if len(filters.Types) > 0 && !contains(filters.Types, issueType) {
+		return false
+	}
+
+	if issue.IsIgnored && !filters.Ignored {
+		return false
+	}
+
+	return true
+}
+
+func newImportLogs(image, status string, projects []Project) []snyk.ImportJobLog {
+	log := snyk.ImportJobLog{Name: image, Status: status, Created: time.Now()}
+
+	for _, project := range projects {
+		log.Projects = append(log.Projects, snyk.ImportJobProject{
+			TargetFile:  project.TargetFile,
+			Success:     project.UserMessage == "",
+			UserMessage: project.UserMessage,
+			ProjectID:   project.ID,
+		})
+	}
+
+	return []snyk.ImportJobLog{log}
+}
+
+func contains(values []string, value string) bool {
+	for _, v := range values {
+		if v == value {
+			return true
+		}
+	}
+
+	return false
+}
+
+func scheme(r *http.Request) string {
+	if r.TLS != nil {
+		return "https"
+	}
+
+	return "http"
+}
+
+func writeJSON(w http.ResponseWriter, status int, v interface{}) {
+	w.Header().Set("Content-Type", "application/json; charset=utf-8")
+	w.WriteHeader(status)
+	json.NewEncoder(w).Encode(v)
+}
+
+func writeError(w http.ResponseWriter, status int, message string) {
+	writeJSON(w, status, snyk.ErrorResponse{Code: status, Message: message, Error: message})
+}
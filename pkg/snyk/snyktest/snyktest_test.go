@@ -0,0 +1,158 @@
+package snyktest
+
+import (
+	"context"
+	"errors"
+	"net/http"
+	"testing"
+	"time"
+
+	"github.com/ricoberger/harbor-snyk-scanner/pkg/snyk"
+
+	flag "github.com/spf13/pflag"
+	"github.com/stretchr/testify/require"
+)
+
+func newClient(t *testing.T) (*Server, snyk.Client) {
+	server := NewServer("apikey", "org", "integration")
+	t.Cleanup(server.Close)
+
+	require.NoError(t, server.LoadFixtures("testdata/fixtures.json"))
+	require.NoError(t, server.SetFlags())
+	require.NoError(t, flag.Set("snyk.retry-wait-min", "1ms"))
+	require.NoError(t, flag.Set("snyk.retry-wait-max", "10ms"))
+
+	client, err := snyk.NewClient()
+	require.NoError(t, err)
+
+	return server, client
+}
+
+func TestImport(t *testing.T) {
+	server, client := newClient(t)
+
+	location, err := client.ImportProject(context.Background(), "library/nginx:latest")
+	require.NoError(t, err)
+	require.Equal(t, server.URL+"/api/v1/org/org/integrations/integration/import/job-1", location)
+
+	_, err = client.GetProjectIDs(context.Background(), "library/nginx:latest", location)
+	require.ErrorIs(t, err, snyk.ErrImportPending)
+
+	projectIDs, err := client.GetProjectIDs(context.Background(), "library/nginx:latest", location)
+	require.NoError(t, err)
+	require.Equal(t, []string{"nginx-os"}, projectIDs)
+
+	issues, err := client.GetAggregatedIssues(context.Background(), projectIDs)
+	require.NoError(t, err)
+	require.Len(t, issues, 2)
+	require.Equal(t, "SNYK-DEBIAN11-OPENSSL-2388380", issues[0].ID)
+	require.Equal(t, "SNYK-DEBIAN11-ZLIB-2433933", issues[1].ID)
+}
+
+func TestImportFailed(t *testing.T) {
+	_, client := newClient(t)
+
+	for image, message := range map[string]string{
+		"library/broken:latest":  "import job failed: Image not found",
+		"library/unknown:latest": "import job failed: Image not found",
+	} {
+		location, err := client.ImportProject(context.Background(), image)
+		require.NoError(t, err)
+
+		_, err = client.GetProjectIDs(context.Background(), image, location)
+		require.ErrorIs(t, err, snyk.ErrImportFailed)
+		require.EqualError(t, err, message)
+	}
+}
+
+func TestAuthentication(t *testing.T) {
+	server, client := newClient(t)
+	server.APIKey = "other"
+
+	_, err := client.ImportProject(context.Background(), "library/nginx:latest")
+	require.ErrorIs(t, err, snyk.ErrUnauthorized)
+
+	_, err = client.WithOrganisation("other", "integration", "other").ImportProject(context.Background(), "library/nginx:latest")
+	require.ErrorIs(t, err, snyk.ErrNotFound)
+}
+
+func TestFaults(t *testing.T) {
+	t.Run("server error is retried", func(t *testing.T) {
+		server, client := newClient(t)
+		server.AddFault(Fault{Path: "/api/v1/org/org/project/", StatusCode: http.StatusBadGateway, Count: 2})
+
+		issues, err := client.GetAggregatedIssues(context.Background(), []string{"nginx-os"})
+		require.NoError(t, err)
+		require.Len(t, issues, 2)
+		require.Len(t, server.Requests(), 3)
+	})
+
+	t.Run("rate limit", func(t *testing.T) {
+		server, client := newClient(t)
+		server.AddFault(Fault{Method: http.MethodPost, StatusCode: http.StatusTooManyRequests, Header: http.Header{"Retry-After": []string{"120"}}, Body: `{"code": 429, "message": "Too many requests"}`})
+
+		_, err := client.ImportProject(context.Background(), "library/nginx:latest")
+		require.ErrorIs(t, err, snyk.ErrRateLimited)
+
+		var rateLimitErr *snyk.RateLimitError
+		require.True(t, errors.As(err, &rateLimitErr))
+		require.Equal(t, 120*time.Second, rateLimitErr.RetryAfter)
+	})
+
+	t.Run("malformed json", func(t *testing.T) {
+		server, client := newClient(t)
+		server.AddFault(Fault{Path: "/api/v1/org/org/project/", StatusCode: http.StatusOK, Body: `{"issues": [`})
+
+		_, err := client.GetAggregatedIssues(context.Background(), []string{"nginx-os"})
+		require.Error(t, err)
+		require.Contains(t, err.Error(), "could not decode response")
+	})
+
+	t.Run("latency", func(t *testing.T) {
+		server, client := newClient(t)
+		server.AddFault(Fault{Latency: 50 * time.Millisecond})
+
+		ctx, cancel := context.WithTimeout(context.Background(), 10*time.Millisecond)
+		defer cancel()
+
+		_, err := client.ImportProject(ctx, "library/nginx:latest")
+		require.ErrorIs(t, err, context.DeadlineExceeded)
+	})
+}
+
+func TestRemoveProjects(t *testing.T) {
+	server, client := newClient(t)
+
+	require.NoError(t, client.DeactivateProject(context.Background(), "nginx-os"))
+	require.Equal(t, []string{"nginx-os"}, server.DeactivatedProjects())
+
+	require.NoError(t, client.DeleteProject(context.Background(), "nginx-os"))
+	require.Equal(t, []string{"nginx-os"}, server.DeletedProjects())
+	require.Equal(t, []string{"DELETE /api/v1/org/org/project/nginx-os"}, server.Requests()[1:])
+
+	require.ErrorIs(t, client.DeleteProject(context.Background(), "nginx-os"), snyk.ErrNotFound)
+
+	_, err := client.GetAggregatedIssues(context.Background(), []string{"nginx-os"})
+	require.ErrorIs(t, err, snyk.ErrNotFound)
+}
+
+func TestProjectMetadata(t *testing.T) {
+	server, client := newClient(t)
+
+	require.NoError(t, client.AddProjectTag(context.Background(), "nginx-os", snyk.Tag{Key: "harbor-project", Value: "library"}))
+	require.Equal(t, []snyk.Tag{{Key: "harbor-project", Value: "library"}}, server.ProjectTags("nginx-os"))
+	require.Error(t, client.AddProjectTag(context.Background(), "nginx-os", snyk.Tag{Key: "harbor-project", Value: "library"}))
+
+	tags, err := client.GetProjectTags(context.Background(), "nginx-os")
+	require.NoError(t, err)
+	require.Equal(t, []snyk.Tag{{Key: "harbor-project", Value: "library"}}, tags)
+
+	require.NoError(t, client.RemoveProjectTag(context.Background(), "nginx-os", snyk.Tag{Key: "harbor-project", Value: "library"}))
+	require.Empty(t, server.ProjectTags("nginx-os"))
+
+	require.NoError(t, client.SetProjectAttributes(context.Background(), "nginx-os", snyk.ProjectAttributes{Environment: []string{"backend", "internal"}}))
+	require.Equal(t, snyk.ProjectAttributes{Environment: []string{"backend", "internal"}}, server.ProjectAttributes("nginx-os"))
+	require.Error(t, client.SetProjectAttributes(context.Background(), "nginx-os", snyk.ProjectAttributes{Lifecycle: []string{"staging"}}))
+
+	require.ErrorIs(t, client.AddProjectTag(context.Background(), "unknown", snyk.Tag{Key: "harbor-project", Value: "library"}), snyk.ErrNotFound)
+}
@@ -0,0 +1,44 @@
+package snyk
+
+import (
+	"context"
+	"fmt"
+	"net/http"
+
+	"go.opentelemetry.io/contrib/instrumentation/net/http/otelhttp"
+	"go.opentelemetry.io/otel"
+	"go.opentelemetry.io/otel/attribute"
+	"go.opentelemetry.io/otel/codes"
+	"go.opentelemetry.io/otel/propagation"
+	"go.opentelemetry.io/otel/trace"
+)
+
+const instrumentationName = "github.com/ricoberger/harbor-snyk-scanner/pkg/snyk"
+
+// newTracingTransport returns a http.RoundTripper, which creates a client span for each request against the Snyk API.
+// Like the metricsTransport it is used below the retryTransport, so that each retry gets its own span. The span is
+// named after the endpoint, so that the name does not contain any ids. The trace context is not propagated, because
+// Snyk is a third party and doesn't need to know our trace ids.
+func newTracingTransport(next http.RoundTripper) http.RoundTripper {
+	return otelhttp.NewTransport(next,
+		otelhttp.WithPropagators(propagation.NewCompositeTextMapPropagator()),
+		otelhttp.WithSpanNameFormatter(func(_ string, r *http.Request) string {
+			return fmt.Sprintf("Snyk %s %s", r.Method, getEndpoint(r.URL.Path))
+		}),
+	)
+}
+
+// startSpan starts a new internal span with the given name and attributes for a function of the Snyk client.
+func startSpan(ctx context.Context, name string, attributes ...attribute.KeyValue) (context.Context, trace.Span) {
+	return otel.Tracer(instrumentationName).Start(ctx, name, trace.WithAttributes(attributes...))
+}
+
+// endSpan records the given error in the span, before the span is ended.
+func endSpan(span trace.Span, err error) {
+	if err != nil {
+		span.RecordError(err)
+		span.SetStatus(codes.Error, err.Error())
+	}
+
+	span.End()
+}
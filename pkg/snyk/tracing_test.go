@@ -0,0 +1,83 @@
+package snyk
+
+import (
+	"context"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+
+	"github.com/stretchr/testify/require"
+	"go.opentelemetry.io/otel"
+	"go.opentelemetry.io/otel/attribute"
+	"go.opentelemetry.io/otel/codes"
+	"go.opentelemetry.io/otel/propagation"
+	sdktrace "go.opentelemetry.io/otel/sdk/trace"
+	"go.opentelemetry.io/otel/sdk/trace/tracetest"
+	"go.opentelemetry.io/otel/trace"
+)
+
+func TestTracing(t *testing.T) {
+	exporter := tracetest.NewInMemoryExporter()
+
+	defer func(previousProvider trace.TracerProvider, previousPropagator propagation.TextMapPropagator) {
+		otel.SetTracerProvider(previousProvider)
+		otel.SetTextMapPropagator(previousPropagator)
+	}(otel.GetTracerProvider(), otel.GetTextMapPropagator())
+
+	otel.SetTracerProvider(sdktrace.NewTracerProvider(sdktrace.WithSyncer(exporter)))
+	otel.SetTextMapPropagator(propagation.TraceContext{})
+
+	ts := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		require.Empty(t, r.Header.Get("traceparent"))
+
+		project := strings.Split(r.URL.Path, "/")[6]
+		if strings.HasPrefix(project, "failing") {
+			w.WriteHeader(http.StatusNotFound)
+			fmt.Fprint(w, `{"code": 404, "message": "Project not found"}`)
+			return
+		}
+
+		fmt.Fprintf(w, `{"issues": [{"id": "%s-1"}]}`, project)
+	}))
+	defer ts.Close()
+
+	c := &client{baseURL: ts.URL, organisationID: "org", concurrency: 2, httpClient: &http.Client{Transport: newTracingTransport(ts.Client().Transport)}}
+
+	_, err := c.GetAggregatedIssues(context.Background(), []string{"project1", "failing1"})
+	require.Error(t, err)
+
+	spans := exporter.GetSpans()
+	require.Len(t, spans, 5)
+
+	spansByName := make(map[string][]tracetest.SpanStub)
+	for _, span := range spans {
+		spansByName[span.Name] = append(spansByName[span.Name], span)
+	}
+
+	require.Len(t, spansByName["GetIssues"], 1)
+	issuesSpan := spansByName["GetIssues"][0]
+	require.Equal(t, codes.Error, issuesSpan.Status.Code)
+	require.Contains(t, issuesSpan.Attributes, attribute.Int("snyk.projects", 2))
+
+	require.Len(t, spansByName["GetProjectIssues"], 2)
+	projectSpans := make(map[trace.SpanID]tracetest.SpanStub)
+	for _, span := range spansByName["GetProjectIssues"] {
+		require.Equal(t, issuesSpan.SpanContext.SpanID(), span.Parent.SpanID())
+		projectSpans[span.SpanContext.SpanID()] = span
+
+		if span.Attributes[0] == attribute.String("snyk.project.id", "failing1") {
+			require.Equal(t, codes.Error, span.Status.Code)
+		} else {
+			require.Equal(t, attribute.String("snyk.project.id", "project1"), span.Attributes[0])
+			require.Equal(t, codes.Unset, span.Status.Code)
+		}
+	}
+
+	require.Len(t, spansByName["Snyk POST aggregated-issues"], 2)
+	for _, span := range spansByName["Snyk POST aggregated-issues"] {
+		require.Equal(t, trace.SpanKindClient, span.SpanKind)
+		require.Contains(t, projectSpans, span.Parent.SpanID())
+	}
+}
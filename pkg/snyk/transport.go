@@ -0,0 +1,227 @@
+package snyk
+
+import (
+	"context"
+	"io"
+	"io/ioutil"
+	"math/rand"
+	"net/http"
+	"strconv"
+	"time"
+
+	"github.com/ricoberger/harbor-snyk-scanner/pkg/log"
+
+	"go.uber.org/zap"
+	"golang.org/x/time/rate"
+)
+
+// Key to use when setting the number of retries for a request.
+type ctxKeyRetries int
+
+// retriesKey is the key that holds the number of retries for a request in a context.
+const retriesKey ctxKeyRetries = 0
+
+// withRetries returns a new context, which allows the retryTransport to retry a request with the given context up to
+// the given number of times. This is used for requests which are not idempotent by their method, like the POST request
+// to get the aggregated issues or the import of an image.
+func withRetries(ctx context.Context, retries int) context.Context {
+	return context.WithValue(ctx, retriesKey, retries)
+}
+
+// retryTransport is a http.RoundTripper, which retries failed requests with a jittered exponential backoff. A request
+// is retried when it failed with a network error, when we are rate limited or when the Snyk API returned a server
+// error. If the response contains a Retry-After header or the rate limit headers, the value of these headers is used
+// instead of the backoff. All requests must also wait for the token bucket limiter, so that we stay under the API
+// quota of our organisation.
+type retryTransport struct {
+	next       http.RoundTripper
+	limiter    *rate.Limiter
+	timeout    time.Duration
+	maxRetries int
+	waitMin    time.Duration
+	waitMax    time.Duration
+}
+
+// retries returns the number of retries for the given request. GET and HEAD requests can always be retried. For all
+// other requests the number of retries must be set explicitly via the withRetries function.
+func (t *retryTransport) retries(req *http.Request) int {
+	if retries, ok := req.Context().Value(retriesKey).(int); ok {
+		return retries
+	}
+
+	if req.Method == http.MethodGet || req.Method == http.MethodHead {
+		return t.maxRetries
+	}
+
+	return 0
+}
+
+// backoff returns the duration we wait before the given retry. It is an exponential backoff between the configured
+// min and max duration, where the returned value is randomly chosen between the half and the full backoff.
+func (t *retryTransport) backoff(retry int) time.Duration {
+	wait := t.waitMin
+	for i := 0; i < retry && wait < t.waitMax; i++ {
+		wait = wait * 2
+	}
+
+	if wait > t.waitMax {
+		wait = t.waitMax
+	}
+
+	if wait <= 1 {
+		return wait
+	}
+
+	return wait/2 + time.Duration(rand.Int63n(int64(wait/2)))
+}
+
+func (t *retryTransport) RoundTrip(req *http.Request) (*http.Response, error) {
+	retries := t.retries(req)
+	if req.Body != nil && req.GetBody == nil {
+		retries = 0
+	}
+
+	for retry := 0; ; retry++ {
+		attempt := req
+		if retry > 0 && req.Body != nil {
+			body, err := req.GetBody()
+			if err != nil {
+				return nil, err
+			}
+
+			attempt = req.Clone(req.Context())
+			attempt.Body = body
+		}
+
+		if t.limiter != nil {
+			if err := t.limiter.Wait(req.Context()); err != nil {
+				return nil, err
+			}
+		}
+
+		resp, err := t.roundTrip(attempt)
+		if retry >= retries || req.Context().Err() != nil {
+			return resp, err
+		}
+
+		wait, ok := t.shouldRetry(resp, err, retry)
+		if !ok {
+			return resp, err
+		}
+
+		log.Debug(req.Context(), "Retry request to Snyk API", zap.String("method", req.Method), zap.String("url", req.URL.Redacted()), zap.Int("retry", retry+1), zap.Duration("wait", wait), zap.Error(err))
+
+		if resp != nil {
+			io.Copy(ioutil.Discard, resp.Body)
+			resp.Body.Close()
+		}
+
+		timer := time.NewTimer(wait)
+		select {
+		case <-req.Context().Done():
+			timer.Stop()
+			return nil, req.Context().Err()
+		case <-timer.C:
+		}
+	}
+}
+
+// roundTrip sends a single request with the configured timeout. The timeout is canceled, when the body of the response
+// is closed.
+func (t *retryTransport) roundTrip(req *http.Request) (*http.Response, error) {
+	if t.timeout <= 0 {
+		return t.next.RoundTrip(req)
+	}
+
+	ctx, cancel := context.WithTimeout(req.Context(), t.timeout)
+
+	resp, err := t.next.RoundTrip(req.WithContext(ctx))
+	if err != nil {
+		cancel()
+		return nil, err
+	}
+
+	resp.Body = &cancelBody{ReadCloser: resp.Body, cancel: cancel}
+	return resp, nil
+}
+
+// shouldRetry returns true, when the request should be retried and the duration we have to wait before the retry. If
+// the server tells us to wait longer than our max wait duration, we do not retry the request and return the response
+// to the caller instead, so that the caller can decide how to handle the rate limit.
+func (t *retryTransport) shouldRetry(resp *http.Response, err error, retry int) (time.Duration, bool) {
+	if err != nil {
+		return t.backoff(retry), true
+	}
+
+	if resp.StatusCode != http.StatusTooManyRequests && resp.StatusCode != http.StatusBadGateway && resp.StatusCode != http.StatusServiceUnavailable && resp.StatusCode != http.StatusGatewayTimeout && resp.StatusCode != http.StatusInternalServerError {
+		return 0, false
+	}
+
+	if wait, ok := getRateLimitWait(resp.Header, time.Now()); ok {
+		if wait > t.waitMax {
+			return 0, false
+		}
+
+		return wait, true
+	}
+
+	return t.backoff(retry), true
+}
+
+// getRateLimitWait returns the duration we have to wait before we can send the next request, based on the Retry-After
+// header or the X-RateLimit-Remaining and X-RateLimit-Reset headers of a response. The reset header can be a unix
+// timestamp or the number of seconds until the limit is reset.
+func getRateLimitWait(header http.Header, now time.Time) (time.Duration, bool) {
+	if value := header.Get("Retry-After"); value != "" {
+		return parseRetryAfter(value, now), true
+	}
+
+	if header.Get("X-RateLimit-Remaining") != "0" {
+		return 0, false
+	}
+
+	reset, err := strconv.ParseInt(header.Get("X-RateLimit-Reset"), 10, 64)
+	if err != nil || reset < 0 {
+		return 0, false
+	}
+
+	if reset > 1000000000 {
+		if wait := time.Unix(reset, 0).Sub(now); wait > 0 {
+			return wait, true
+		}
+
+		return 0, true
+	}
+
+	return time.Duration(reset) * time.Second, true
+}
+
+// cancelBody cancels the context of a request, when the body of the response is closed.
+type cancelBody struct {
+	io.ReadCloser
+	cancel context.CancelFunc
+}
+
+func (b *cancelBody) Close() error {
+	err := b.ReadCloser.Close()
+	b.cancel()
+	return err
+}
+
+// newRetryTransport returns a new retryTransport for the given transport. If the requests per second are 0, the
+// requests are not limited.
+func newRetryTransport(next http.RoundTripper, timeout time.Duration, maxRetries int, waitMin, waitMax time.Duration, requestsPerSecond float64, burst int) *retryTransport {
+	var limiter *rate.Limiter
+	if requestsPerSecond > 0 {
+		limiter = rate.NewLimiter(rate.Limit(requestsPerSecond), burst)
+	}
+
+	return &retryTransport{
+		next:       next,
+		limiter:    limiter,
+		timeout:    timeout,
+		maxRetries: maxRetries,
+		waitMin:    waitMin,
+		waitMax:    waitMax,
+	}
+}
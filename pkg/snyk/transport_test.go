@@ -0,0 +1,146 @@
+package snyk
+
+import (
+	"bytes"
+	"context"
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/require"
+)
+
+func TestRetryTransport(t *testing.T) {
+	var requests int32
+
+	ts := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		count := atomic.AddInt32(&requests, 1)
+
+		body, _ := ioutil.ReadAll(r.Body)
+
+		switch r.URL.Path {
+		case "/unavailable":
+			if count < 3 {
+				w.WriteHeader(http.StatusServiceUnavailable)
+				return
+			}
+		case "/rate-limited":
+			if count < 2 {
+				w.Header().Set("Retry-After", "0")
+				w.WriteHeader(http.StatusTooManyRequests)
+				return
+			}
+		case "/rate-limited-long":
+			w.Header().Set("Retry-After", "3600")
+			w.WriteHeader(http.StatusTooManyRequests)
+			return
+		case "/bad-request":
+			w.WriteHeader(http.StatusBadRequest)
+			return
+		}
+
+		w.Write(body)
+	}))
+	defer ts.Close()
+
+	transport := newRetryTransport(http.DefaultTransport, 10*time.Second, 3, 1*time.Millisecond, 10*time.Millisecond, 0, 0)
+	httpClient := &http.Client{Transport: transport}
+
+	send := func(ctx context.Context, method, path string, body []byte) (*http.Response, int32) {
+		atomic.StoreInt32(&requests, 0)
+
+		req, err := http.NewRequestWithContext(ctx, method, ts.URL+path, bytes.NewReader(body))
+		require.NoError(t, err)
+
+		resp, err := httpClient.Do(req)
+		require.NoError(t, err)
+		return resp, atomic.LoadInt32(&requests)
+	}
+
+	t.Run("retry get request", func(t *testing.T) {
+		resp, count := send(context.Background(), http.MethodGet, "/unavailable", nil)
+		defer resp.Body.Close()
+		require.Equal(t, http.StatusOK, resp.StatusCode)
+		require.Equal(t, int32(3), count)
+	})
+
+	t.Run("retry rate limited request", func(t *testing.T) {
+		resp, count := send(context.Background(), http.MethodGet, "/rate-limited", nil)
+		defer resp.Body.Close()
+		require.Equal(t, http.StatusOK, resp.StatusCode)
+		require.Equal(t, int32(2), count)
+	})
+
+	t.Run("do not retry when retry after is too long", func(t *testing.T) {
+		resp, count := send(context.Background(), http.MethodGet, "/rate-limited-long", nil)
+		defer resp.Body.Close()
+		require.Equal(t, http.StatusTooManyRequests, resp.StatusCode)
+		require.Equal(t, int32(1), count)
+	})
+
+	t.Run("do not retry client errors", func(t *testing.T) {
+		resp, count := send(context.Background(), http.MethodGet, "/bad-request", nil)
+		defer resp.Body.Close()
+		require.Equal(t, http.StatusBadRequest, resp.StatusCode)
+		require.Equal(t, int32(1), count)
+	})
+
+	t.Run("do not retry post request", func(t *testing.T) {
+		resp, count := send(context.Background(), http.MethodPost, "/unavailable", []byte("body"))
+		defer resp.Body.Close()
+		require.Equal(t, http.StatusServiceUnavailable, resp.StatusCode)
+		require.Equal(t, int32(1), count)
+	})
+
+	t.Run("retry post request with retries", func(t *testing.T) {
+		resp, count := send(withRetries(context.Background(), 2), http.MethodPost, "/unavailable", []byte("body"))
+		defer resp.Body.Close()
+		require.Equal(t, http.StatusOK, resp.StatusCode)
+		require.Equal(t, int32(3), count)
+
+		body, err := ioutil.ReadAll(resp.Body)
+		require.NoError(t, err)
+		require.Equal(t, "body", string(body))
+	})
+}
+
+func TestRetryTransportRateLimit(t *testing.T) {
+	ts := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {}))
+	defer ts.Close()
+
+	httpClient := &http.Client{Transport: newRetryTransport(http.DefaultTransport, 10*time.Second, 0, 0, 0, 20, 1)}
+
+	start := time.Now()
+	for i := 0; i < 5; i++ {
+		resp, err := httpClient.Get(ts.URL)
+		require.NoError(t, err)
+		resp.Body.Close()
+	}
+
+	require.GreaterOrEqual(t, time.Since(start), 150*time.Millisecond)
+}
+
+func TestGetRateLimitWait(t *testing.T) {
+	now := time.Unix(1640995200, 0)
+
+	wait, ok := getRateLimitWait(http.Header{"Retry-After": []string{"10"}}, now)
+	require.True(t, ok)
+	require.Equal(t, 10*time.Second, wait)
+
+	wait, ok = getRateLimitWait(http.Header{"X-Ratelimit-Remaining": []string{"0"}, "X-Ratelimit-Reset": []string{"1640995230"}}, now)
+	require.True(t, ok)
+	require.Equal(t, 30*time.Second, wait)
+
+	wait, ok = getRateLimitWait(http.Header{"X-Ratelimit-Remaining": []string{"0"}, "X-Ratelimit-Reset": []string{"5"}}, now)
+	require.True(t, ok)
+	require.Equal(t, 5*time.Second, wait)
+
+	_, ok = getRateLimitWait(http.Header{"X-Ratelimit-Remaining": []string{"10"}, "X-Ratelimit-Reset": []string{"5"}}, now)
+	require.False(t, ok)
+
+	_, ok = getRateLimitWait(http.Header{}, now)
+	require.False(t, ok)
+}
@@ -4,28 +4,72 @@ import (
 	"time"
 )
 
+const (
+	// IssueTypeVuln is the type of an issue for a vulnerability in a package.
+	IssueTypeVuln = "vuln"
+	// IssueTypeLicense is the type of an issue for a package with a license, which violates the license policy of the
+	// organisation.
+	IssueTypeLicense = "license"
+)
+
 type ErrorResponse struct {
 	Code    int    `json:"code"`
 	Message string `json:"message"`
 	Error   string `json:"error"`
+	Errors  []struct {
+		Status string `json:"status"`
+		Detail string `json:"detail"`
+	} `json:"errors,omitempty"`
+}
+
+type ImportRequest struct {
+	Target ImportTarget `json:"target"`
+}
+
+type ImportTarget struct {
+	Name string `json:"name"`
 }
 
 type ImportJobResponse struct {
-	ID      string    `json:"id"`
-	Status  string    `json:"status"`
-	Created time.Time `json:"created"`
-	Logs    []struct {
-		Name     string    `json:"name"`
-		Created  time.Time `json:"created"`
-		Status   string    `json:"status"`
-		Projects []struct {
-			TargetFile  string `json:"targetFile,omitempty"`
-			Success     bool   `json:"success"`
-			UserMessage string `json:"userMessage,omitempty"`
-			ProjectURL  string `json:"projectUrl"`
-			ProjectID   string `json:"projectId,omitempty"`
-		} `json:"projects"`
-	} `json:"logs"`
+	ID      string         `json:"id"`
+	Status  string         `json:"status"`
+	Created time.Time      `json:"created"`
+	Logs    []ImportJobLog `json:"logs"`
+}
+
+type ImportJobLog struct {
+	Name     string             `json:"name"`
+	Created  time.Time          `json:"created"`
+	Status   string             `json:"status"`
+	Projects []ImportJobProject `json:"projects"`
+}
+
+type ImportJobProject struct {
+	TargetFile  string `json:"targetFile,omitempty"`
+	Success     bool   `json:"success"`
+	UserMessage string `json:"userMessage,omitempty"`
+	ProjectURL  string `json:"projectUrl"`
+	ProjectID   string `json:"projectId,omitempty"`
+}
+
+type AggregatedIssuesRequest struct {
+	IncludeDescription       bool                    `json:"includeDescription"`
+	IncludeIntroducedThrough bool                    `json:"includeIntroducedThrough"`
+	Filters                  AggregatedIssuesFilters `json:"filters"`
+}
+
+type AggregatedIssuesFilters struct {
+	Severities      []string `json:"severities"`
+	ExploitMaturity []string `json:"exploitMaturity"`
+	Types           []string `json:"types"`
+	Ignored         bool     `json:"ignored"`
+	Patched         bool     `json:"patched"`
+	Priority        struct {
+		Score struct {
+			Min int `json:"min"`
+			Max int `json:"max"`
+		} `json:"score"`
+	} `json:"priority"`
 }
 
 type IssuesResponse struct {
@@ -84,3 +128,107 @@ type Issue struct {
 		Paths string `json:"paths"`
 	} `json:"links"`
 }
+
+type Tag struct {
+	Key   string `json:"key"`
+	Value string `json:"value"`
+}
+
+type ProjectResponse struct {
+	ID   string `json:"id"`
+	Name string `json:"name"`
+	Tags []Tag  `json:"tags"`
+}
+
+type ProjectAttributes struct {
+	Criticality []string `json:"criticality,omitempty"`
+	Environment []string `json:"environment,omitempty"`
+	Lifecycle   []string `json:"lifecycle,omitempty"`
+}
+
+type Project struct {
+	ID         string
+	Name       string
+	Origin     string
+	Tags       []Tag
+	Created    time.Time
+	LastTested time.Time
+}
+
+type RESTLinks struct {
+	Next string `json:"next,omitempty"`
+}
+
+type RESTProjectsResponse struct {
+	Data []struct {
+		ID         string `json:"id"`
+		Type       string `json:"type"`
+		Attributes struct {
+			Name    string    `json:"name"`
+			Type    string    `json:"type"`
+			Origin  string    `json:"origin"`
+			Status  string    `json:"status"`
+			Created time.Time `json:"created"`
+			Tags    []Tag     `json:"tags"`
+		} `json:"attributes"`
+		Meta struct {
+			LatestIssueCounts struct {
+				UpdatedAt time.Time `json:"updated_at"`
+			} `json:"latest_issue_counts"`
+		} `json:"meta"`
+	} `json:"data"`
+	Links RESTLinks `json:"links"`
+}
+
+type RESTIssuesResponse struct {
+	Data  []RESTIssue `json:"data"`
+	Links RESTLinks   `json:"links"`
+}
+
+type RESTIssue struct {
+	ID         string `json:"id"`
+	Type       string `json:"type"`
+	Attributes struct {
+		Key                    string `json:"key"`
+		Title                  string `json:"title"`
+		Type                   string `json:"type"`
+		Description            string `json:"description"`
+		EffectiveSeverityLevel string `json:"effective_severity_level"`
+		Ignored                bool   `json:"ignored"`
+		Status                 string `json:"status"`
+		Problems               []struct {
+			ID     string `json:"id"`
+			Source string `json:"source"`
+			URL    string `json:"url"`
+		} `json:"problems"`
+		Coordinates []struct {
+			IsUpgradeable bool `json:"is_upgradeable"`
+			IsPinnable    bool `json:"is_pinnable"`
+			IsPatchable   bool `json:"is_patchable"`
+			Remedies      []struct {
+				Type        string `json:"type"`
+				Description string `json:"description"`
+				Details     struct {
+					UpgradePackage string `json:"upgrade_package"`
+				} `json:"details"`
+			} `json:"remedies"`
+			Representations []struct {
+				Dependency struct {
+					PackageName    string `json:"package_name"`
+					PackageVersion string `json:"package_version"`
+				} `json:"dependency"`
+			} `json:"representations"`
+		} `json:"coordinates"`
+		Severities []struct {
+			Source string  `json:"source"`
+			Level  string  `json:"level"`
+			Score  float64 `json:"score"`
+			Vector string  `json:"vector"`
+		} `json:"severities"`
+		Risk struct {
+			Score struct {
+				Value int `json:"value"`
+			} `json:"score"`
+		} `json:"risk"`
+	} `json:"attributes"`
+}
@@ -0,0 +1,143 @@
+// Package tracing configures the OpenTelemetry tracing for the scanner. When tracing is enabled, the spans are exported
+// via OTLP over HTTP to the configured endpoint. The W3C trace context propagator is always configured, so that an
+// incoming traceparent header is passed through, even when we do not export our own spans.
+package tracing
+
+import (
+	"context"
+	"fmt"
+	"os"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/ricoberger/harbor-snyk-scanner/pkg/log"
+	"github.com/ricoberger/harbor-snyk-scanner/pkg/version"
+
+	flag "github.com/spf13/pflag"
+	"go.opentelemetry.io/otel"
+	"go.opentelemetry.io/otel/exporters/otlp/otlptrace/otlptracehttp"
+	"go.opentelemetry.io/otel/propagation"
+	"go.opentelemetry.io/otel/sdk/resource"
+	sdktrace "go.opentelemetry.io/otel/sdk/trace"
+	semconv "go.opentelemetry.io/otel/semconv/v1.7.0"
+	"go.uber.org/zap"
+)
+
+var (
+	enabled     bool
+	endpoint    string
+	urlPath     string
+	insecure    bool
+	headers     map[string]string
+	timeout     time.Duration
+	sampleRatio float64
+	serviceName string
+)
+
+// init is used to define all flags, which are needed to configure the tracing. By default tracing is disabled. When it
+// is enabled the spans are exported to an OpenTelemetry collector, which accepts OTLP over HTTP.
+func init() {
+	defaultEnabled := false
+	if os.Getenv("TRACING_ENABLED") != "" {
+		defaultEnabled, _ = strconv.ParseBool(os.Getenv("TRACING_ENABLED"))
+	}
+
+	defaultEndpoint := "localhost:4318"
+	if os.Getenv("TRACING_ENDPOINT") != "" {
+		defaultEndpoint = os.Getenv("TRACING_ENDPOINT")
+	}
+
+	defaultURLPath := "/v1/traces"
+	if os.Getenv("TRACING_URL_PATH") != "" {
+		defaultURLPath = os.Getenv("TRACING_URL_PATH")
+	}
+
+	defaultInsecure := false
+	if os.Getenv("TRACING_INSECURE") != "" {
+		defaultInsecure, _ = strconv.ParseBool(os.Getenv("TRACING_INSECURE"))
+	}
+
+	defaultHeaders := make(map[string]string)
+	if os.Getenv("TRACING_HEADERS") != "" {
+		for _, header := range strings.Split(os.Getenv("TRACING_HEADERS"), ",") {
+			if parts := strings.SplitN(header, "=", 2); len(parts) == 2 {
+				defaultHeaders[parts[0]] = parts[1]
+			}
+		}
+	}
+
+	defaultTimeout := 10 * time.Second
+	if os.Getenv("TRACING_TIMEOUT") != "" {
+		parsedTimeout, err := time.ParseDuration(os.Getenv("TRACING_TIMEOUT"))
+		if err == nil {
+			defaultTimeout = parsedTimeout
+		}
+	}
+
+	defaultSampleRatio := 1.0
+	if os.Getenv("TRACING_SAMPLE_RATIO") != "" {
+		parsedSampleRatio, err := strconv.ParseFloat(os.Getenv("TRACING_SAMPLE_RATIO"), 64)
+		if err == nil {
+			defaultSampleRatio = parsedSampleRatio
+		}
+	}
+
+	defaultServiceName := "harbor-snyk-scanner"
+	if os.Getenv("TRACING_SERVICE_NAME") != "" {
+		defaultServiceName = os.Getenv("TRACING_SERVICE_NAME")
+	}
+
+	flag.BoolVar(&enabled, "tracing.enabled", defaultEnabled, "Enable the export of traces via OTLP over HTTP.")
+	flag.StringVar(&endpoint, "tracing.endpoint", defaultEndpoint, "The host and port of the OpenTelemetry collector, where the traces are exported to.")
+	flag.StringVar(&urlPath, "tracing.url-path", defaultURLPath, "The url path of the OpenTelemetry collector, where the traces are exported to.")
+	flag.BoolVar(&insecure, "tracing.insecure", defaultInsecure, "Use HTTP instead of HTTPS for the export of the traces.")
+	flag.StringToStringVar(&headers, "tracing.headers", defaultHeaders, "Additional headers, which are sent with each export of traces, e.g. for the authentication against the collector.")
+	flag.DurationVar(&timeout, "tracing.timeout", defaultTimeout, "The timeout for a single export of traces.")
+	flag.Float64Var(&sampleRatio, "tracing.sample-ratio", defaultSampleRatio, "The ratio of traces, which are sampled when there is no sampled parent span. Must be between 0 and 1.")
+	flag.StringVar(&serviceName, "tracing.service-name", defaultServiceName, "The name of the service, which is added to all exported spans.")
+}
+
+// Setup configures the global propagator and, when tracing is enabled, the global tracer provider. The returned
+// function must be called on shutdown, so that all remaining spans are exported.
+func Setup() (func(ctx context.Context) error, error) {
+	otel.SetTextMapPropagator(propagation.NewCompositeTextMapPropagator(propagation.TraceContext{}, propagation.Baggage{}))
+
+	if !enabled {
+		return func(ctx context.Context) error { return nil }, nil
+	}
+
+	if sampleRatio < 0 || sampleRatio > 1 {
+		return nil, fmt.Errorf("invalid sample ratio %v, must be between 0 and 1", sampleRatio)
+	}
+
+	options := []otlptracehttp.Option{
+		otlptracehttp.WithEndpoint(endpoint),
+		otlptracehttp.WithURLPath(urlPath),
+		otlptracehttp.WithHeaders(headers),
+		otlptracehttp.WithTimeout(timeout),
+	}
+	if insecure {
+		options = append(options, otlptracehttp.WithInsecure())
+	}
+
+	exporter, err := otlptracehttp.New(context.Background(), options...)
+	if err != nil {
+		return nil, fmt.Errorf("could not create exporter: %w", err)
+	}
+
+	provider := sdktrace.NewTracerProvider(
+		sdktrace.WithBatcher(exporter),
+		sdktrace.WithSampler(sdktrace.ParentBased(sdktrace.TraceIDRatioBased(sampleRatio))),
+		sdktrace.WithResource(resource.NewWithAttributes(semconv.SchemaURL, semconv.ServiceNameKey.String(serviceName), semconv.ServiceVersionKey.String(version.Version))),
+	)
+
+	otel.SetTracerProvider(provider)
+	otel.SetErrorHandler(otel.ErrorHandlerFunc(func(err error) {
+		log.Warn(nil, "OpenTelemetry error", zap.Error(err))
+	}))
+
+	log.Info(nil, "Tracing enabled", zap.String("endpoint", endpoint), zap.Float64("sampleRatio", sampleRatio))
+
+	return provider.Shutdown, nil
+}
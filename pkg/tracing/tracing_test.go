@@ -0,0 +1,48 @@
+package tracing
+
+import (
+	"context"
+	"testing"
+
+	"github.com/stretchr/testify/require"
+	"go.opentelemetry.io/otel"
+	sdktrace "go.opentelemetry.io/otel/sdk/trace"
+)
+
+func TestSetup(t *testing.T) {
+	defer func(previousEnabled bool, previousSampleRatio float64) {
+		enabled = previousEnabled
+		sampleRatio = previousSampleRatio
+	}(enabled, sampleRatio)
+
+	previousProvider := otel.GetTracerProvider()
+	defer otel.SetTracerProvider(previousProvider)
+
+	t.Run("disabled", func(t *testing.T) {
+		enabled = false
+
+		shutdown, err := Setup()
+		require.NoError(t, err)
+		require.NoError(t, shutdown(context.Background()))
+		require.ElementsMatch(t, []string{"traceparent", "tracestate", "baggage"}, otel.GetTextMapPropagator().Fields())
+		require.Equal(t, previousProvider, otel.GetTracerProvider())
+	})
+
+	t.Run("invalid sample ratio", func(t *testing.T) {
+		enabled = true
+		sampleRatio = 2
+
+		_, err := Setup()
+		require.EqualError(t, err, "invalid sample ratio 2, must be between 0 and 1")
+	})
+
+	t.Run("enabled", func(t *testing.T) {
+		enabled = true
+		sampleRatio = 0.5
+
+		shutdown, err := Setup()
+		require.NoError(t, err)
+		require.IsType(t, &sdktrace.TracerProvider{}, otel.GetTracerProvider())
+		require.NoError(t, shutdown(context.Background()))
+	})
+}